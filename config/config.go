@@ -0,0 +1,360 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/appditto/natrium-wallet-server/utils"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// Config is everything the server is configured with
+// It's loaded from a YAML or TOML file, environment variables (the env tags) override the file
+type Config struct {
+	// Port the API and websocket listen on
+	Port int `yaml:"port" toml:"port" env:"PORT"`
+	// Serve metrics on their own port, 0 serves them on Port
+	MetricsPort int  `yaml:"metrics_port" toml:"metrics_port" env:"METRICS_PORT"`
+	BananoMode  bool `yaml:"banano_mode" toml:"banano_mode" env:"BANANO_MODE"`
+	// Socket.io endpoint, only for natrium.io/donate
+	SocketIO bool `yaml:"socket_io" toml:"socket_io" env:"SOCKET_IO"`
+	// Has every scope
+	AdminAPIKey string `yaml:"admin_api_key" toml:"admin_api_key" env:"ADMIN_API_KEY" secret:"true"`
+	// Scopes of requests made without an API key
+	AnonymousScopes []string `yaml:"anonymous_scopes" toml:"anonymous_scopes" env:"ANONYMOUS_SCOPES"`
+	// How long in-flight requests and websocket clients get to finish on shutdown
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+
+	Database      DatabaseConfig      `yaml:"database" toml:"database"`
+	Redis         RedisConfig         `yaml:"redis" toml:"redis"`
+	RPC           RPCConfig           `yaml:"rpc" toml:"rpc"`
+	NodeWebsocket NodeWebsocketConfig `yaml:"node_websocket" toml:"node_websocket"`
+	Work          WorkConfig          `yaml:"work" toml:"work"`
+	Push          PushConfig          `yaml:"push" toml:"push"`
+	HTTP          HTTPConfig          `yaml:"http" toml:"http"`
+	RateLimit     RateLimitConfig     `yaml:"rate_limit" toml:"rate_limit"`
+	Websocket     WebsocketConfig     `yaml:"websocket" toml:"websocket"`
+	Health        HealthConfig        `yaml:"health" toml:"health"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host" toml:"host" env:"DB_HOST"`
+	Port     string `yaml:"port" toml:"port" env:"DB_PORT"`
+	Password string `yaml:"password" toml:"password" env:"DB_PASS" secret:"true"`
+	User     string `yaml:"user" toml:"user" env:"DB_USER"`
+	DBName   string `yaml:"name" toml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"sslmode" toml:"sslmode" env:"DB_SSLMODE"`
+}
+
+type RedisConfig struct {
+	Host string `yaml:"host" toml:"host" env:"REDIS_HOST"`
+	Port int    `yaml:"port" toml:"port" env:"REDIS_PORT"`
+	DB   int    `yaml:"db" toml:"db" env:"REDIS_DB"`
+	// In memory redis, for tests
+	Mock bool `yaml:"mock" toml:"mock" env:"MOCK_REDIS"`
+}
+
+type RPCConfig struct {
+	Url string `yaml:"url" toml:"url" env:"RPC_URL" secret:"true"`
+	// Pool of nodes with health checks and failover, url|weight, replaces Url
+	Nodes []string `yaml:"nodes" toml:"nodes" env:"RPC_URLS" secret:"true"`
+	// How many blocks behind the best node in the pool a node can be, 0 to not check
+	MaxSyncLag uint64 `yaml:"max_sync_lag" toml:"max_sync_lag" env:"RPC_MAX_SYNC_LAG"`
+	// How long a node in the pool has to answer before we fail over to the next one
+	Timeout Duration `yaml:"timeout" toml:"timeout" env:"RPC_TIMEOUT"`
+}
+
+type NodeWebsocketConfig struct {
+	Url string `yaml:"url" toml:"url" env:"NODE_WS_URL" secret:"true"`
+	// Fan out confirmations to all replicas over redis pub/sub
+	PubSub bool `yaml:"pubsub" toml:"pubsub" env:"WS_PUBSUB"`
+}
+
+// WorkProviderConfig is how long a work provider gets and how likely it is to be picked
+type WorkProviderConfig struct {
+	Timeout Duration `yaml:"timeout" toml:"timeout" env:"TIMEOUT"`
+	Weight  int      `yaml:"weight" toml:"weight" env:"WEIGHT"`
+}
+
+type WorkConfig struct {
+	// race, ordered or weighted
+	Strategy string `yaml:"strategy" toml:"strategy" env:"WORK_STRATEGY"`
+
+	BpowKey string             `yaml:"bpow_key" toml:"bpow_key" env:"BPOW_KEY" secret:"true"`
+	BpowUrl string             `yaml:"bpow_url" toml:"bpow_url" env:"BPOW_URL"`
+	Bpow    WorkProviderConfig `yaml:"bpow" toml:"bpow" envPrefix:"BPOW_"`
+
+	// Work server, it has an API key in it
+	Url         string             `yaml:"url" toml:"url" env:"WORK_URL" secret:"true"`
+	UrlProvider WorkProviderConfig `yaml:"url_provider" toml:"url_provider" envPrefix:"WORK_URL_"`
+
+	UseNode bool               `yaml:"use_node" toml:"use_node" env:"WORK_USE_NODE"`
+	Node    WorkProviderConfig `yaml:"node" toml:"node" envPrefix:"WORK_NODE_"`
+
+	// Our own CPU, last resort
+	UseLocal     bool               `yaml:"use_local" toml:"use_local" env:"WORK_USE_LOCAL"`
+	LocalThreads int                `yaml:"local_threads" toml:"local_threads" env:"WORK_LOCAL_THREADS"`
+	Local        WorkProviderConfig `yaml:"local" toml:"local" envPrefix:"WORK_LOCAL_"`
+
+	// Generate work ahead of time for new frontiers of subscribed accounts
+	Precache        bool `yaml:"precache" toml:"precache" env:"WORK_PRECACHE"`
+	PrecacheMaxJobs int  `yaml:"precache_max_jobs" toml:"precache_max_jobs" env:"WORK_PRECACHE_MAX_JOBS"`
+}
+
+type PushConfig struct {
+	// Path to a Firebase service account key, push notifications are off without it
+	FcmCredentialsFile string `yaml:"fcm_credentials_file" toml:"fcm_credentials_file" env:"FCM_CREDENTIALS_FILE"`
+	// Defaults to the service account's project
+	FcmProjectID string `yaml:"fcm_project_id" toml:"fcm_project_id" env:"FCM_PROJECT_ID"`
+	// Legacy server key, no longer supported by FCM, it's ignored with a warning
+	FcmApiKey string `yaml:"fcm_api_key" toml:"fcm_api_key" env:"FCM_API_KEY" secret:"true"`
+	// Smallest amount notified of in NANO or BANANO, unless a device set its own
+	MinimumAmount string `yaml:"minimum_amount" toml:"minimum_amount" env:"PUSH_MINIMUM_AMOUNT"`
+	// How many notifications each replica sends at once
+	Concurrency int `yaml:"concurrency" toml:"concurrency" env:"PUSH_CONCURRENCY"`
+	// Failed notifications are retried with exponential backoff, until they've been tried this many times
+	MaxAttempts     int      `yaml:"max_attempts" toml:"max_attempts" env:"PUSH_MAX_ATTEMPTS"`
+	RetryBackoff    Duration `yaml:"retry_backoff" toml:"retry_backoff" env:"PUSH_RETRY_BACKOFF"`
+	MaxRetryBackoff Duration `yaml:"max_retry_backoff" toml:"max_retry_backoff" env:"PUSH_MAX_RETRY_BACKOFF"`
+	// Path to an APNs .p8 signing key, for iOS devices registered with the apns platform
+	ApnsKeyFile string `yaml:"apns_key_file" toml:"apns_key_file" env:"APNS_KEY_FILE"`
+	ApnsKeyID   string `yaml:"apns_key_id" toml:"apns_key_id" env:"APNS_KEY_ID"`
+	ApnsTeamID  string `yaml:"apns_team_id" toml:"apns_team_id" env:"APNS_TEAM_ID"`
+	// The app's bundle ID
+	ApnsTopic string `yaml:"apns_topic" toml:"apns_topic" env:"APNS_TOPIC"`
+	// For development builds of the app
+	ApnsSandbox bool `yaml:"apns_sandbox" toml:"apns_sandbox" env:"APNS_SANDBOX"`
+}
+
+type HTTPConfig struct {
+	// Origins allowed by CORS, empty allows any
+	CorsOrigins []string `yaml:"cors_origins" toml:"cors_origins" env:"CORS_ORIGINS"`
+	// Seconds browsers can cache a preflight response
+	CorsMaxAge int `yaml:"cors_max_age" toml:"cors_max_age" env:"CORS_MAX_AGE"`
+	// Forwarding headers are only believed from these CIDRs
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// Headers with the client IP, in order of precedence
+	IPHeaders []string `yaml:"ip_headers" toml:"ip_headers" env:"IP_HEADERS"`
+}
+
+type RateLimitConfig struct {
+	Default     Rate `yaml:"default" toml:"default" env:"RATE_LIMIT_DEFAULT"`
+	Whitelisted Rate `yaml:"whitelisted" toml:"whitelisted" env:"RATE_LIMIT_WHITELISTED"`
+	Admin       Rate `yaml:"admin" toml:"admin" env:"RATE_LIMIT_ADMIN"`
+	// IPs in the whitelisted tier
+	Whitelist []string `yaml:"whitelist" toml:"whitelist" env:"RATE_LIMIT_WHITELIST"`
+}
+
+type WebsocketConfig struct {
+	SessionTTL    Duration `yaml:"session_ttl" toml:"session_ttl" env:"WS_SESSION_TTL"`
+	SessionBuffer int      `yaml:"session_buffer" toml:"session_buffer" env:"WS_SESSION_BUFFER"`
+	// drop_oldest or disconnect
+	SlowClientPolicy string `yaml:"slow_client_policy" toml:"slow_client_policy" env:"WS_SLOW_CLIENT_POLICY"`
+	CoalescePrices   bool   `yaml:"coalesce_prices" toml:"coalesce_prices" env:"WS_COALESCE_PRICES"`
+}
+
+type HealthConfig struct {
+	// Components that have to pass for readiness, empty is postgres, redis, rpc and node_websocket if there is one
+	ReadyRequired []string `yaml:"ready_required" toml:"ready_required" env:"READY_REQUIRED"`
+	// How old a price can be before the prices component fails
+	PriceMaxAge Duration `yaml:"price_max_age" toml:"price_max_age" env:"PRICE_MAX_AGE"`
+}
+
+// Components readiness can require
+var HealthComponents = []string{"postgres", "redis", "rpc", "prices", "node_websocket"}
+
+// Default is the config before the file and environment are applied
+func Default() *Config {
+	defaultWorkProvider := WorkProviderConfig{Timeout: Duration(30 * time.Second), Weight: 1}
+	trustedProxies := strings.Split(utils.DefaultTrustedProxies, ",")
+	return &Config{
+		Port:            3000,
+		ShutdownTimeout: Duration(25 * time.Second),
+		AnonymousScopes: slices.Clone(dbmodels.DefaultAnonymousScopes),
+		Redis: RedisConfig{
+			Host: "localhost",
+			Port: 6379,
+		},
+		RPC: RPCConfig{
+			Url:     "http://localhost:7076",
+			Timeout: Duration(30 * time.Second),
+		},
+		Work: WorkConfig{
+			Strategy:        "race",
+			BpowUrl:         "https://boompow.banano.cc/graphql",
+			Bpow:            defaultWorkProvider,
+			UrlProvider:     defaultWorkProvider,
+			Node:            defaultWorkProvider,
+			Local:           defaultWorkProvider,
+			LocalThreads:    runtime.NumCPU(),
+			PrecacheMaxJobs: 50,
+		},
+		Push: PushConfig{
+			MinimumAmount:   "1",
+			Concurrency:     10,
+			MaxAttempts:     8,
+			RetryBackoff:    Duration(5 * time.Second),
+			MaxRetryBackoff: Duration(10 * time.Minute),
+		},
+		HTTP: HTTPConfig{
+			CorsMaxAge:     300,
+			TrustedProxies: trustedProxies,
+			IPHeaders:      slices.Clone(utils.DefaultIPHeaders),
+		},
+		RateLimit: RateLimitConfig{
+			Default:     Rate{Requests: 100, Period: time.Minute},
+			Whitelisted: Rate{Requests: 1000, Period: time.Minute},
+			Admin:       Rate{Requests: 10000, Period: time.Minute},
+		},
+		Websocket: WebsocketConfig{
+			SessionTTL:       Duration(24 * time.Hour),
+			SessionBuffer:    100,
+			SlowClientPolicy: "drop_oldest",
+			CoalescePrices:   true,
+		},
+		Health: HealthConfig{
+			PriceMaxAge: Duration(15 * time.Minute),
+		},
+	}
+}
+
+// Load reads the config file, if there is one, over the defaults and then applies the environment
+// The format is decided by the extension, .yaml, .yml or .toml
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			decoder := yaml.NewDecoder(bytes.NewReader(contents))
+			// Typos shouldn't silently fall back to defaults
+			decoder.KnownFields(true)
+			if err := decoder.Decode(cfg); err != nil {
+				return nil, fmt.Errorf("Error parsing %s: %w", path, err)
+			}
+		case ".toml":
+			meta, err := toml.Decode(string(contents), cfg)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing %s: %w", path, err)
+			}
+			if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+				return nil, fmt.Errorf("Error parsing %s: unknown field %s", path, undecoded[0])
+			}
+		default:
+			return nil, fmt.Errorf("Unknown config format %s, use .yaml or .toml", path)
+		}
+	}
+	if err := ApplyEnv(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate returns every problem with the config at once
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Port > 0 && c.Port < 65536, "port %d is out of range", c.Port)
+	check(c.MetricsPort >= 0 && c.MetricsPort < 65536, "metrics_port %d is out of range", c.MetricsPort)
+	check(c.MetricsPort != c.Port, "metrics_port can't be the same as port")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout has to be positive")
+	for _, scope := range c.AnonymousScopes {
+		check(slices.Contains(dbmodels.ApiKeyScopes, scope) && scope != dbmodels.ScopeAdmin, "anonymous_scopes has unknown scope %s", scope)
+	}
+
+	check(c.Redis.Port > 0 && c.Redis.Port < 65536, "redis.port %d is out of range", c.Redis.Port)
+	check(c.Redis.DB >= 0, "redis.db can't be negative")
+	check(c.RPC.Url != "" || len(c.RPC.Nodes) > 0, "rpc.url or rpc.nodes is required")
+	check(c.RPC.Timeout > 0, "rpc.timeout has to be positive")
+	for _, node := range c.RPC.Nodes {
+		parts := strings.Split(node, "|")
+		check(parts[0] != "", "rpc.nodes has an empty url")
+		if len(parts) > 1 {
+			weight, err := strconv.Atoi(parts[1])
+			check(err == nil && weight > 0, "rpc.nodes %s has an invalid weight", node)
+		}
+	}
+
+	work := c.Work
+	check(work.BpowKey != "" || work.Url != "" || work.UseNode || work.UseLocal, "one of work.bpow_key, work.url, work.use_node or work.use_local is required for work generation")
+	check(slices.Contains([]string{"race", "ordered", "weighted"}, strings.ToLower(work.Strategy)), "work.strategy %s isn't race, ordered or weighted", work.Strategy)
+	for name, provider := range map[string]WorkProviderConfig{"bpow": work.Bpow, "url_provider": work.UrlProvider, "node": work.Node, "local": work.Local} {
+		check(provider.Timeout > 0, "work.%s.timeout has to be positive", name)
+		check(provider.Weight > 0, "work.%s.weight has to be positive", name)
+	}
+	check(!work.UseLocal || work.LocalThreads > 0, "work.local_threads has to be positive")
+	check(!work.Precache || work.PrecacheMaxJobs > 0, "work.precache_max_jobs has to be positive")
+
+	if c.Push.FcmApiKey != "" {
+		klog.Warningf("push.fcm_api_key is deprecated and ignored, it's for the legacy FCM API, use push.fcm_credentials_file with a service account key instead")
+	}
+	_, err := utils.AmountToRaw(c.Push.MinimumAmount, c.BananoMode)
+	check(err == nil, "push.minimum_amount %s isn't an amount", c.Push.MinimumAmount)
+	check(c.Push.Concurrency > 0, "push.concurrency has to be positive")
+	check(c.Push.MaxAttempts > 0, "push.max_attempts has to be positive")
+	check(c.Push.RetryBackoff > 0 && c.Push.MaxRetryBackoff >= c.Push.RetryBackoff, "push.retry_backoff has to be positive and no more than push.max_retry_backoff")
+	if c.Push.ApnsKeyFile != "" {
+		check(c.Push.ApnsKeyID != "" && c.Push.ApnsTeamID != "" && c.Push.ApnsTopic != "", "push.apns_key_file needs push.apns_key_id, push.apns_team_id and push.apns_topic")
+	}
+
+	check(c.HTTP.CorsMaxAge >= 0, "http.cors_max_age can't be negative")
+	_, err = utils.ParseTrustedProxies(strings.Join(c.HTTP.TrustedProxies, ","))
+	check(err == nil, "http.trusted_proxies: %v", err)
+
+	for name, rate := range map[string]Rate{"default": c.RateLimit.Default, "whitelisted": c.RateLimit.Whitelisted, "admin": c.RateLimit.Admin} {
+		check(rate.Requests > 0 && rate.Period > 0, "rate_limit.%s has to allow some requests", name)
+	}
+
+	check(c.Websocket.SessionTTL > 0, "websocket.session_ttl has to be positive")
+	check(c.Websocket.SessionBuffer > 0, "websocket.session_buffer has to be positive")
+	check(slices.Contains([]string{"drop_oldest", "disconnect"}, c.Websocket.SlowClientPolicy), "websocket.slow_client_policy %s isn't drop_oldest or disconnect", c.Websocket.SlowClientPolicy)
+
+	check(c.Health.PriceMaxAge > 0, "health.price_max_age has to be positive")
+	for _, component := range c.Health.ReadyRequired {
+		check(slices.Contains(HealthComponents, component), "health.ready_required has unknown component %s", component)
+		check(component != "node_websocket" || c.NodeWebsocket.Url != "", "health.ready_required has node_websocket without a node_websocket.url")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// ReadyRequired returns the components required for readiness
+func (c *Config) ReadyRequired() []string {
+	if len(c.Health.ReadyRequired) > 0 {
+		return c.Health.ReadyRequired
+	}
+	required := []string{"postgres", "redis", "rpc"}
+	if c.NodeWebsocket.Url != "" {
+		required = append(required, "node_websocket")
+	}
+	return required
+}
+
+// PricePrefix is the prefix of the coin's prices in redis
+func (c *Config) PricePrefix() string {
+	if c.BananoMode {
+		return "banano"
+	}
+	return "nano"
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// ApplyEnv overrides the config with environment variables named by the env tags
+// Nested structs add their envPrefix to their fields' names, empty variables are ignored
+func ApplyEnv(cfg *Config) error {
+	return applyEnv(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func applyEnv(value reflect.Value, prefix string) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		fieldValue := value.Field(i)
+		if name, ok := field.Tag.Lookup("env"); ok {
+			env := os.Getenv(prefix + name)
+			if env == "" {
+				continue
+			}
+			if err := setFromString(fieldValue, env); err != nil {
+				return fmt.Errorf("Invalid %s%s specified: %w", prefix, name, err)
+			}
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(fieldValue, prefix+field.Tag.Get("envPrefix")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func setFromString(value reflect.Value, str string) error {
+	if value.Addr().Type().Implements(textUnmarshalerType) {
+		return value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(str))
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(str)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case reflect.Uint64:
+		parsed, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetUint(parsed)
+	case reflect.Slice:
+		// Comma separated
+		var parsed []string
+		for _, item := range strings.Split(str, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				parsed = append(parsed, item)
+			}
+		}
+		value.Set(reflect.ValueOf(parsed))
+	default:
+		return fmt.Errorf("Unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config with every secret that's set replaced, so it can be printed
+func (c *Config) Redacted() *Config {
+	copied := *c
+	redact(reflect.ValueOf(&copied).Elem())
+	return &copied
+}
+
+func redact(value reflect.Value) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		fieldValue := value.Field(i)
+		secret := field.Tag.Get("secret") == "true"
+		if secret && field.Type.Kind() == reflect.Slice && fieldValue.Len() > 0 {
+			// A new slice, the copy shares the original's
+			redactedSlice := make([]string, fieldValue.Len())
+			for j := range redactedSlice {
+				redactedSlice[j] = redacted
+			}
+			fieldValue.Set(reflect.ValueOf(redactedSlice))
+		} else if secret && field.Type.Kind() == reflect.String && fieldValue.String() != "" {
+			fieldValue.SetString(redacted)
+		} else if field.Type.Kind() == reflect.Struct {
+			redact(fieldValue)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written like 30s or 5m in config files
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Rate is a number of requests per period, written like 100/1m
+type Rate struct {
+	Requests int64
+	Period   time.Duration
+}
+
+// ParseRate parses a rate like 100/1m
+func ParseRate(rate string) (Rate, error) {
+	parts := strings.Split(rate, "/")
+	if len(parts) != 2 {
+		return Rate{}, fmt.Errorf("Invalid rate %s", rate)
+	}
+	requests, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || requests < 1 {
+		return Rate{}, fmt.Errorf("Invalid rate %s", rate)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return Rate{}, fmt.Errorf("Invalid rate %s", rate)
+	}
+	return Rate{Requests: requests, Period: period}, nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Requests, r.Period)
+}
+
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalText(text []byte) error {
+	parsed, err := ParseRate(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
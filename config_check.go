@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/appditto/natrium-wallet-server/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Print the effective config with secrets redacted, then whether it's valid. Returns the exit code
+func checkConfig(cfg *config.Config) int {
+	out, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error printing config: %v\n", err)
+		return 1
+	}
+	fmt.Print(string(out))
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Config is valid")
+	return 0
+}
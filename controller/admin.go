@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"k8s.io/klog/v2"
+)
+
+// HandleQueueStats responds with the send queue of every connected websocket client
+func (h *Hub) HandleQueueStats(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, h.QueueStats())
+}
+
+type createApiKeyRequest struct {
+	Name     string     `json:"name"`
+	Scopes   []string   `json:"scopes"`
+	RateTier string     `json:"rate_tier"`
+	MaxCount int64      `json:"max_count"`
+	Expires  *time.Time `json:"expires_at"`
+}
+
+// HandleCreateApiKey creates an API key, the response is the only time the key itself is shown
+func (a *Authenticator) HandleCreateApiKey(w http.ResponseWriter, r *http.Request) {
+	var request createApiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		ErrInvalidRequest(w, r)
+		return
+	}
+	key, apiKey, err := a.ApiKeyRepo.CreateApiKey(request.Name, request.Scopes, request.RateTier, request.MaxCount, request.Expires)
+	if err != nil {
+		ErrBadrequest(w, r, err.Error())
+		return
+	}
+	klog.Infof("API key %s created by %s", apiKey.Name, IdentityFromContext(r.Context()).Name)
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, map[string]interface{}{
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+func (a *Authenticator) HandleListApiKeys(w http.ResponseWriter, r *http.Request) {
+	apiKeys, err := a.ApiKeyRepo.GetApiKeys()
+	if err != nil {
+		klog.Errorf("Error getting API keys %v", err)
+		ErrInternalServerError(w, r, "Error getting API keys")
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, apiKeys)
+}
+
+// HandleDeleteApiKey revokes an API key, other replicas stop accepting it once their cache expires
+func (a *Authenticator) HandleDeleteApiKey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		ErrInvalidRequest(w, r)
+		return
+	}
+	if err := a.ApiKeyRepo.DeleteApiKey(id); err != nil {
+		klog.Errorf("Error deleting API key %v", err)
+		ErrInternalServerError(w, r, "Error deleting API key")
+		return
+	}
+	a.mutex.Lock()
+	for keyHash, cached := range a.cache {
+		if cached.apiKey.ID == id {
+			delete(a.cache, keyHash)
+		}
+	}
+	a.mutex.Unlock()
+	klog.Infof("API key %s deleted by %s", id, IdentityFromContext(r.Context()).Name)
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, map[string]interface{}{"deleted": id})
+}
+
+func (w *PushWorker) HandlePushQueueStats(rw http.ResponseWriter, r *http.Request) {
+	stats, err := w.Queue.Stats()
+	if err != nil {
+		klog.Errorf("Error getting push queue stats %v", err)
+		ErrInternalServerError(rw, r, "Error getting push queue stats")
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.JSON(rw, r, stats)
+}
+
+// HandleDeadPushJobs lists the notifications that ran out of attempts, with why the last one failed
+func (w *PushWorker) HandleDeadPushJobs(rw http.ResponseWriter, r *http.Request) {
+	jobs, err := w.Queue.DeadJobs()
+	if err != nil {
+		klog.Errorf("Error getting dead push jobs %v", err)
+		ErrInternalServerError(rw, r, "Error getting dead push jobs")
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.JSON(rw, r, jobs)
+}
+
+// HandleReplayPushJob queues a dead notification again, with its attempts reset
+func (w *PushWorker) HandleReplayPushJob(rw http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	replayed, err := w.Queue.Replay(id)
+	if err != nil {
+		klog.Errorf("Error replaying push job %v", err)
+		ErrInternalServerError(rw, r, "Error replaying push job")
+		return
+	}
+	if !replayed {
+		ErrNotFound(rw, r)
+		return
+	}
+	klog.Infof("Push job %s replayed by %s", id, IdentityFromContext(r.Context()).Name)
+	render.Status(r, http.StatusOK)
+	render.JSON(rw, r, map[string]interface{}{"replayed": []string{id}})
+}
+
+// HandleReplayPushJobs queues every dead notification again
+func (w *PushWorker) HandleReplayPushJobs(rw http.ResponseWriter, r *http.Request) {
+	jobs, err := w.Queue.DeadJobs()
+	if err != nil {
+		klog.Errorf("Error getting dead push jobs %v", err)
+		ErrInternalServerError(rw, r, "Error getting dead push jobs")
+		return
+	}
+	ids := []string{}
+	for _, job := range jobs {
+		replayed, err := w.Queue.Replay(job.ID)
+		if err != nil {
+			klog.Errorf("Error replaying push job %v", err)
+			ErrInternalServerError(rw, r, "Error replaying push job")
+			return
+		}
+		if replayed {
+			ids = append(ids, job.ID)
+		}
+	}
+	klog.Infof("%d push jobs replayed by %s", len(ids), IdentityFromContext(r.Context()).Name)
+	render.Status(r, http.StatusOK)
+	render.JSON(rw, r, map[string]interface{}{"replayed": ids})
+}
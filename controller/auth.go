@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/appditto/natrium-wallet-server/repository"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+	"k8s.io/klog/v2"
+)
+
+type contextKey int
+
+const identityContextKey contextKey = iota
+
+// How long a looked up key is trusted before checking the database again, so revoking a key takes up to this long
+const apiKeyCacheTTL = time.Minute
+
+// How long a key that doesn't exist is remembered, so a bogus key doesn't cost a database query on every request
+const apiKeyMissTTL = 30 * time.Second
+
+// Most misses remembered at once, so random keys can't grow the cache without bound
+const maxApiKeyMisses = 10000
+
+type cachedApiKey struct {
+	apiKey    *dbmodels.ApiKey
+	fetchedAt time.Time
+}
+
+// Authenticator resolves the API key in the Authorization header to the identity of whoever made the request
+type Authenticator struct {
+	// nil if only ADMIN_API_KEY is accepted
+	ApiKeyRepo *repository.ApiKeyRepo
+	// Has every scope, set from the environment
+	AdminAPIKey string
+
+	cache  map[string]cachedApiKey
+	misses map[string]time.Time
+	mutex  sync.Mutex
+}
+
+// IdentityFromContext returns the API key a request was made with, nil if it had none
+func IdentityFromContext(ctx context.Context) *dbmodels.ApiKey {
+	identity, _ := ctx.Value(identityContextKey).(*dbmodels.ApiKey)
+	return identity
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// Authenticate returns the API key's identity, nil if it's not a valid key
+func (a *Authenticator) Authenticate(key string) (*dbmodels.ApiKey, error) {
+	if a.AdminAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.AdminAPIKey)) == 1 {
+		return &dbmodels.ApiKey{
+			Name:     "admin",
+			Scopes:   dbmodels.ScopeAdmin,
+			RateTier: dbmodels.RateTierAdmin,
+			MaxCount: adminMaxCount,
+		}, nil
+	}
+	if a.ApiKeyRepo == nil {
+		return nil, nil
+	}
+
+	keyHash := repository.HashApiKey(key)
+	a.mutex.Lock()
+	cached, ok := a.cache[keyHash]
+	missedAt, missed := a.misses[keyHash]
+	a.mutex.Unlock()
+	if missed && time.Since(missedAt) < apiKeyMissTTL {
+		return nil, nil
+	}
+	if !ok || time.Since(cached.fetchedAt) > apiKeyCacheTTL {
+		apiKey, err := a.ApiKeyRepo.GetApiKey(key)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			a.mutex.Lock()
+			delete(a.cache, keyHash)
+			a.rememberMiss(keyHash)
+			a.mutex.Unlock()
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// Last used is only as precise as the cache
+		if err := a.ApiKeyRepo.TouchApiKey(apiKey.ID); err != nil {
+			klog.Errorf("Error updating API key last used %v", err)
+		}
+		cached = cachedApiKey{apiKey: apiKey, fetchedAt: time.Now()}
+		a.mutex.Lock()
+		if a.cache == nil {
+			a.cache = make(map[string]cachedApiKey)
+		}
+		a.cache[keyHash] = cached
+		a.mutex.Unlock()
+	}
+	if cached.apiKey.IsExpired() {
+		return nil, nil
+	}
+	return cached.apiKey, nil
+}
+
+// rememberMiss caches that the key doesn't exist, the mutex has to be held
+func (a *Authenticator) rememberMiss(keyHash string) {
+	if a.misses == nil {
+		a.misses = make(map[string]time.Time)
+	}
+	if len(a.misses) >= maxApiKeyMisses {
+		for missHash, missedAt := range a.misses {
+			if time.Since(missedAt) >= apiKeyMissTTL {
+				delete(a.misses, missHash)
+			}
+		}
+		// All recent, start over rather than grow
+		if len(a.misses) >= maxApiKeyMisses {
+			a.misses = make(map[string]time.Time)
+		}
+	}
+	a.misses[keyHash] = time.Now()
+}
+
+// Middleware attaches the identity of the request's API key to its context
+// Requests without a key go through as they are, requests with an invalid one are rejected
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyFromRequest(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		identity, err := a.Authenticate(key)
+		if err != nil {
+			klog.Errorf("Error authenticating API key %v", err)
+			ErrInternalServerError(w, r, "Error authenticating")
+			return
+		}
+		if identity == nil {
+			ErrUnauthorized(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityContextKey, identity)))
+	})
+}
+
+// RequireScope only lets requests made with an API key that has the scope through
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity := IdentityFromContext(r.Context())
+			if identity == nil {
+				ErrUnauthorized(w, r)
+				return
+			}
+			if !identity.HasScope(scope) {
+				ErrForbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// The scope an API key needs for an action
+func actionScope(action string) string {
+	switch action {
+	case "process", "republish":
+		return dbmodels.ScopeProcess
+	case "work_generate":
+		return dbmodels.ScopeWork
+	}
+	return dbmodels.ScopeRPCRead
+}
+
+// hasScope checks the API key's scopes, or the anonymous scopes if there's no key
+func (hc *HttpController) hasScope(identity *dbmodels.ApiKey, scope string) bool {
+	if identity != nil {
+		return identity.HasScope(scope)
+	}
+	if hc.AnonymousScopes == nil {
+		return slices.Contains(dbmodels.DefaultAnonymousScopes, scope)
+	}
+	return slices.Contains(hc.AnonymousScopes, scope)
+}
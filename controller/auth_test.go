@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/appditto/natrium-wallet-server/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticator(t *testing.T) {
+	authenticator := &Authenticator{AdminAPIKey: "secret"}
+	var identity *dbmodels.ApiKey
+	handler := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = IdentityFromContext(r.Context())
+	}))
+	request := func(authorization string) int {
+		identity = nil
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api", nil)
+		req.Header.Set("Authorization", authorization)
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// No key is anonymous
+	assert.Equal(t, http.StatusOK, request(""))
+	assert.Nil(t, identity)
+	// An invalid key is rejected rather than treated as anonymous
+	assert.Equal(t, http.StatusUnauthorized, request("wrong"))
+	// ADMIN_API_KEY has every scope
+	assert.Equal(t, http.StatusOK, request("Bearer secret"))
+	assert.Equal(t, "admin", identity.Name)
+	assert.True(t, identity.HasScope(dbmodels.ScopeWork))
+	assert.Equal(t, adminMaxCount, identity.MaxCount)
+}
+
+func TestAuthenticatorMisses(t *testing.T) {
+	// No database behind it, so a lookup would panic
+	authenticator := &Authenticator{ApiKeyRepo: &repository.ApiKeyRepo{}}
+	authenticator.rememberMiss(repository.HashApiKey("bogus"))
+	identity, err := authenticator.Authenticate("bogus")
+	assert.Nil(t, err)
+	assert.Nil(t, identity)
+
+	// Bounded, expired misses are dropped first
+	authenticator.misses = map[string]time.Time{}
+	for i := 0; i < maxApiKeyMisses; i++ {
+		authenticator.misses[fmt.Sprintf("expired%d", i)] = time.Now().Add(-apiKeyMissTTL)
+	}
+	authenticator.rememberMiss("new")
+	assert.Equal(t, 1, len(authenticator.misses))
+}
+
+func TestRequireScope(t *testing.T) {
+	hub := NewHub(false, nil, nil)
+	handler := RequireScope(dbmodels.ScopeAdmin)(http.HandlerFunc(hub.HandleQueueStats))
+	request := func(identity *dbmodels.ApiKey) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/admin/ws/queues", nil)
+		if identity != nil {
+			req = req.WithContext(context.WithValue(req.Context(), identityContextKey, identity))
+		}
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request(nil).Code)
+	assert.Equal(t, http.StatusForbidden, request(&dbmodels.ApiKey{Name: "reader", Scopes: "rpc:read,process"}).Code)
+	w := request(&dbmodels.ApiKey{Name: "admin", Scopes: "admin"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]\n", w.Body.String())
+}
+
+// Requests made with an API key can only use the actions its scopes allow
+func TestActionScopes(t *testing.T) {
+	request := func(identity *dbmodels.ApiKey, body map[string]interface{}) *httptest.ResponseRecorder {
+		serialized, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api", bytes.NewReader(serialized))
+		req = req.WithContext(context.WithValue(req.Context(), identityContextKey, identity))
+		controller.HandleAction(w, req)
+		return w
+	}
+
+	reader := &dbmodels.ApiKey{Name: "reader", Scopes: "rpc:read"}
+	assert.Equal(t, http.StatusForbidden, request(reader, map[string]interface{}{"action": "process", "block": "{}"}).Code)
+	assert.Equal(t, http.StatusForbidden, request(reader, map[string]interface{}{"action": "work_generate", "hash": "718CC2121C3E641059BC1C2CFC45666C99E8AE922F7A807B7D07B62C995D79E2"}).Code)
+
+	// Processing a block doesn't include having work generated for it
+	processor := &dbmodels.ApiKey{Name: "processor", Scopes: "process"}
+	block := signedTestBlock(t)
+	block.Work = nil
+	w := request(processor, map[string]interface{}{
+		"action":     "process",
+		"json_block": true,
+		"do_work":    true,
+		"block":      block,
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	worker := &dbmodels.ApiKey{Name: "worker", Scopes: "work"}
+	w = request(worker, map[string]interface{}{"action": "work_generate", "hash": "invalid"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"error\":\"Invalid hash\"}\n", w.Body.String())
+	w = request(worker, map[string]interface{}{"action": "work_generate", "hash": "718CC2121C3E641059BC1C2CFC45666C99E8AE922F7A807B7D07B62C995D79E2", "difficulty_multiplier": 128})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// Requests without an API key are held to the anonymous scopes
+func TestAnonymousScopes(t *testing.T) {
+	anonymous := &HttpController{RPCClient: controller.RPCClient, AnonymousScopes: []string{dbmodels.ScopeRPCRead}}
+	request := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		serialized, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		anonymous.HandleAction(w, httptest.NewRequest("POST", "/api", bytes.NewReader(serialized)))
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, request(map[string]interface{}{"action": "process", "block": "{}"}).Code)
+	assert.Equal(t, http.StatusForbidden, request(map[string]interface{}{"action": "republish", "hash": "718CC2121C3E641059BC1C2CFC45666C99E8AE922F7A807B7D07B62C995D79E2"}).Code)
+
+	// The wallet publishes blocks without a key, so they're allowed by default
+	assert.Equal(t, true, controller.hasScope(nil, dbmodels.ScopeProcess))
+	assert.Equal(t, false, controller.hasScope(nil, dbmodels.ScopeAdmin))
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/appditto/natrium-wallet-server/models"
+	"github.com/appditto/natrium-wallet-server/utils"
+	"github.com/appditto/natrium-wallet-server/utils/ed25519"
+	"golang.org/x/exp/slices"
+)
+
+// Error codes for blocks we reject without asking the node
+const (
+	BlockErrorInvalidAccount        = "invalid_account"
+	BlockErrorInvalidPrevious       = "invalid_previous"
+	BlockErrorInvalidRepresentative = "invalid_representative"
+	BlockErrorInvalidBalance        = "invalid_balance"
+	BlockErrorInvalidLink           = "invalid_link"
+	BlockErrorInvalidSignature      = "invalid_signature"
+	BlockErrorInsufficientWork      = "insufficient_work"
+)
+
+type BlockValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *BlockValidationError) Error() string {
+	return e.Message
+}
+
+// Links of epoch blocks, "epoch v1 block" and "epoch v2 block" padded with zeros
+var epochLinks = []string{
+	"65706F636820763120626C6F636B000000000000000000000000000000000000",
+	"65706F636820763220626C6F636B000000000000000000000000000000000000",
+}
+
+// Accounts allowed to sign epoch blocks
+var nanoEpochSigners = []string{
+	"nano_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3",
+	"nano_3qb6o6i1tkzr6jwr5s7eehfxwg9x6eemitdinbpi7u8bjjwsgqfj4wzser3x",
+}
+var bananoEpochSigners = []string{
+	"ban_1bananobh5rat99qfgt1ptpieie5swmoth87thi74qgbfrij7dcgjiij94xr",
+}
+
+func isEpochLink(link string) bool {
+	return slices.Contains(epochLinks, strings.ToUpper(link))
+}
+
+// validateStateBlock checks every field of a state block and its signature
+func validateStateBlock(block *models.ProcessJsonBlock, bananoMode bool) *BlockValidationError {
+	if !utils.ValidateAddress(block.Account, bananoMode) {
+		return &BlockValidationError{Code: BlockErrorInvalidAccount, Message: "Invalid account"}
+	}
+	if _, err := utils.DecodeHash(block.Previous); err != nil {
+		return &BlockValidationError{Code: BlockErrorInvalidPrevious, Message: "Invalid previous"}
+	}
+	if !utils.ValidateAddress(block.Representative, bananoMode) {
+		return &BlockValidationError{Code: BlockErrorInvalidRepresentative, Message: "Invalid representative"}
+	}
+	if _, err := utils.BalanceToBytes(block.Balance); err != nil {
+		return &BlockValidationError{Code: BlockErrorInvalidBalance, Message: "Invalid balance"}
+	}
+	if _, err := utils.DecodeHash(block.Link); err != nil && !utils.ValidateAddress(block.Link, bananoMode) {
+		return &BlockValidationError{Code: BlockErrorInvalidLink, Message: "Invalid link"}
+	}
+
+	hash, err := utils.HashStateBlock(block.Account, block.Previous, block.Representative, block.Balance, block.Link)
+	if err != nil {
+		return &BlockValidationError{Code: BlockErrorInvalidSignature, Message: "Unable to hash block"}
+	}
+	signature, err := hex.DecodeString(block.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return &BlockValidationError{Code: BlockErrorInvalidSignature, Message: "Invalid signature"}
+	}
+
+	// Epoch blocks are signed by the epoch signer instead of the account
+	signers := []string{block.Account}
+	if isEpochLink(block.Link) && bananoMode {
+		signers = append(signers, bananoEpochSigners...)
+	} else if isEpochLink(block.Link) {
+		signers = append(signers, nanoEpochSigners...)
+	}
+	for _, signer := range signers {
+		pub, err := utils.AddressToPub(signer)
+		if err == nil && ed25519.Verify(pub, hash, signature) {
+			return nil
+		}
+	}
+	return &BlockValidationError{Code: BlockErrorInvalidSignature, Message: "Invalid signature"}
+}
+
+// workThreshold returns the minimum work for the subtype
+// An unknown subtype gets the lowest threshold, the node will still enforce the right one
+func workThreshold(subtype *string, bananoMode bool) uint64 {
+	if bananoMode || subtype == nil {
+		return utils.ReceiveWorkThreshold
+	}
+	if slices.Contains([]string{"change", "send"}, *subtype) {
+		return utils.SendWorkThreshold
+	}
+	return utils.ReceiveWorkThreshold
+}
+
+// workRoot returns what work is generated on, the account public key for open blocks, otherwise previous
+func workRoot(block *models.ProcessJsonBlock) ([]byte, error) {
+	if utils.IsZeroHash(block.Previous) {
+		return utils.AddressToPub(block.Account)
+	}
+	return utils.DecodeHash(block.Previous)
+}
+
+// validateBlockWork checks the block's work meets the threshold for its subtype
+func validateBlockWork(block *models.ProcessJsonBlock, subtype *string, bananoMode bool) *BlockValidationError {
+	if block.Work == nil {
+		return &BlockValidationError{Code: BlockErrorInsufficientWork, Message: "Missing work"}
+	}
+	root, err := workRoot(block)
+	if err != nil {
+		return &BlockValidationError{Code: BlockErrorInvalidPrevious, Message: "Invalid previous"}
+	}
+	if !utils.ValidateWork(root, *block.Work, workThreshold(subtype, bananoMode)) {
+		return &BlockValidationError{Code: BlockErrorInsufficientWork, Message: fmt.Sprintf("Insufficient work %s", *block.Work)}
+	}
+	return nil
+}
+
+// inferSubtype works out the subtype of a block by comparing its balance to the frontier's
+// accountInfo is the account_info response for the block's account, unused for open blocks
+// Returns nil if it can't be determined, the node will decide
+func inferSubtype(block *models.ProcessJsonBlock, accountInfo map[string]interface{}) *string {
+	var subtype string
+	if utils.IsZeroHash(block.Previous) {
+		subtype = "open"
+		return &subtype
+	}
+	if accountInfo == nil {
+		return nil
+	}
+	// Unopened account, or the block isn't on top of the frontier
+	frontier, ok := accountInfo["frontier"].(string)
+	if !ok || !strings.EqualFold(frontier, block.Previous) {
+		return nil
+	}
+	frontierBalanceStr, ok := accountInfo["balance"].(string)
+	if !ok {
+		return nil
+	}
+	frontierBalance, ok := new(big.Int).SetString(frontierBalanceStr, 10)
+	if !ok {
+		return nil
+	}
+	balance, ok := new(big.Int).SetString(block.Balance, 10)
+	if !ok {
+		return nil
+	}
+
+	switch balance.Cmp(frontierBalance) {
+	case -1:
+		subtype = "send"
+	case 1:
+		subtype = "receive"
+	default:
+		if isEpochLink(block.Link) {
+			subtype = "epoch"
+		} else if utils.IsZeroHash(block.Link) {
+			subtype = "change"
+		} else {
+			// Same balance with a link is not a valid block
+			return nil
+		}
+	}
+	return &subtype
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appditto/natrium-wallet-server/models"
+	"github.com/appditto/natrium-wallet-server/utils"
+	"github.com/appditto/natrium-wallet-server/utils/ed25519"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRepresentative = "nano_1gyeqc6u5j3oaxbe5qy1hyz3q745a318kh8h9ocnpan7fuxnq85cxqboapu5"
+
+// Builds a receive block signed by a new random account
+func signedTestBlock(t *testing.T) *models.ProcessJsonBlock {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Equal(t, nil, err)
+	account, err := utils.PubToAddress(pub, false)
+	assert.Equal(t, nil, err)
+	work := "00000000000000ab"
+	block := &models.ProcessJsonBlock{
+		Type:           "state",
+		Account:        account,
+		Previous:       "80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F",
+		Representative: testRepresentative,
+		Balance:        "1000000000000000000000000000000",
+		Link:           "000D1BAEC8EC208142C99059B393051BAC8380F9B5A2E6B2489A277D81789F3F",
+		Work:           &work,
+	}
+	hash, err := utils.HashStateBlock(block.Account, block.Previous, block.Representative, block.Balance, block.Link)
+	assert.Equal(t, nil, err)
+	block.Signature = hex.EncodeToString(ed25519.Sign(priv, hash))
+	return block
+}
+
+func TestValidateStateBlock(t *testing.T) {
+	block := signedTestBlock(t)
+	assert.Nil(t, validateStateBlock(block, false))
+
+	// Tampering with anything signed breaks the signature
+	tampered := *block
+	tampered.Balance = "2000000000000000000000000000000"
+	assert.Equal(t, BlockErrorInvalidSignature, validateStateBlock(&tampered, false).Code)
+
+	tampered = *block
+	tampered.Representative = "nano_1gyeqc6u5j3oaxbe5qy1hyz3q745a318kh8h9ocnpan7fuxnq85cxqboapu6"
+	assert.Equal(t, BlockErrorInvalidRepresentative, validateStateBlock(&tampered, false).Code)
+
+	tampered = *block
+	tampered.Link = "xyz"
+	assert.Equal(t, BlockErrorInvalidLink, validateStateBlock(&tampered, false).Code)
+
+	tampered = *block
+	tampered.Previous = "80A6"
+	assert.Equal(t, BlockErrorInvalidPrevious, validateStateBlock(&tampered, false).Code)
+
+	tampered = *block
+	tampered.Balance = "abc"
+	assert.Equal(t, BlockErrorInvalidBalance, validateStateBlock(&tampered, false).Code)
+
+	// Banano mode expects ban_ addresses
+	assert.Equal(t, BlockErrorInvalidAccount, validateStateBlock(block, true).Code)
+}
+
+func TestValidateBlockWork(t *testing.T) {
+	block := signedTestBlock(t)
+	receive := "receive"
+	send := "send"
+	// 00000000000000ab is not enough for anything on previous
+	assert.Equal(t, BlockErrorInsufficientWork, validateBlockWork(block, &receive, false).Code)
+	assert.Equal(t, BlockErrorInsufficientWork, validateBlockWork(block, &send, false).Code)
+	assert.Equal(t, utils.SendWorkThreshold, workThreshold(&send, false))
+	assert.Equal(t, utils.ReceiveWorkThreshold, workThreshold(&receive, false))
+	assert.Equal(t, utils.ReceiveWorkThreshold, workThreshold(&send, true))
+
+	block.Work = nil
+	assert.Equal(t, BlockErrorInsufficientWork, validateBlockWork(block, &receive, false).Code)
+}
+
+func TestProcessRejectsInvalidBlock(t *testing.T) {
+	block := signedTestBlock(t)
+	block.Balance = "2000000000000000000000000000000"
+	reqBody := map[string]interface{}{
+		"action":     "process",
+		"json_block": true,
+		"subtype":    "receive",
+		"block":      block,
+	}
+	body, _ := json.Marshal(reqBody)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	controller.HandleAction(w, req)
+	resp := w.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+
+	var respJson map[string]interface{}
+	respBody, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respBody, &respJson)
+	assert.Equal(t, BlockErrorInvalidSignature, respJson["code"])
+}
+
+func TestInferSubtype(t *testing.T) {
+	block := signedTestBlock(t)
+	accountInfo := map[string]interface{}{
+		"frontier": "80a6745762493fa21a22718abfa4f635656a707b48b3324198ac7f3938de6d4f",
+		"balance":  "1000000000000000000000000000000",
+	}
+	infer := func(balance string, link string, accountInfo map[string]interface{}) *string {
+		b := *block
+		b.Balance = balance
+		b.Link = link
+		return inferSubtype(&b, accountInfo)
+	}
+	link := block.Link
+	zeroLink := "0000000000000000000000000000000000000000000000000000000000000000"
+
+	assert.Equal(t, "send", *infer("999999999999999999999999999999", link, accountInfo))
+	assert.Equal(t, "receive", *infer("1000000000000000000000000000001", link, accountInfo))
+	assert.Equal(t, "change", *infer("1000000000000000000000000000000", zeroLink, accountInfo))
+	assert.Equal(t, "epoch", *infer("1000000000000000000000000000000", epochLinks[1], accountInfo))
+	// A send to the burn address is still a send
+	assert.Equal(t, "send", *infer("0", zeroLink, accountInfo))
+	// Same balance with a link can't be anything
+	assert.Nil(t, infer("1000000000000000000000000000000", link, accountInfo))
+
+	// Not on top of the frontier
+	accountInfo["frontier"] = "0E3F07F7F2B8AEDEA4A984E29BFE1E3933BA473DD3E27C662EC041F6EA3917A0"
+	assert.Nil(t, infer("999999999999999999999999999999", link, accountInfo))
+	// Unopened account
+	assert.Nil(t, infer("999999999999999999999999999999", link, map[string]interface{}{"error": "Account not found"}))
+
+	// Open blocks don't need the ledger
+	open := *block
+	open.Previous = "0"
+	assert.Equal(t, "open", *inferSubtype(&open, nil))
+}
@@ -2,12 +2,16 @@ package controller
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-chi/render"
 )
 
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+	// Seconds until a rate limited request would be allowed
+	RetryAfter int64 `json:"retry_after,omitempty"`
 }
 
 var InvalidRequestError = ErrorResponse{
@@ -42,3 +46,52 @@ func ErrInternalServerError(w http.ResponseWriter, r *http.Request, errorText st
 		Error: errorText,
 	})
 }
+
+func ErrInvalidBlock(w http.ResponseWriter, r *http.Request, err *BlockValidationError) {
+	render.Status(r, http.StatusBadRequest)
+	render.JSON(w, r, &ErrorResponse{
+		Error: err.Message,
+		Code:  err.Code,
+	})
+}
+
+func ErrRequestTooLarge(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusRequestEntityTooLarge)
+	render.JSON(w, r, &ErrorResponse{
+		Error: "Request too large",
+	})
+}
+
+func ErrUnauthorized(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusUnauthorized)
+	render.JSON(w, r, &ErrorResponse{
+		Error: "Unauthorized",
+	})
+}
+
+func rateLimitedError(retryAfter time.Duration) *ErrorResponse {
+	return &ErrorResponse{
+		Error:      "Too many requests",
+		Code:       "rate_limited",
+		RetryAfter: ceilSeconds(retryAfter),
+	}
+}
+
+func ErrRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	render.Status(r, http.StatusTooManyRequests)
+	render.JSON(w, r, rateLimitedError(retryAfter))
+}
+
+func ErrForbidden(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusForbidden)
+	render.JSON(w, r, &ErrorResponse{
+		Error: "Forbidden",
+	})
+}
+
+func ErrNotFound(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusNotFound)
+	render.JSON(w, r, &ErrorResponse{
+		Error: "Not found",
+	})
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// HealthCheck is a dependency the server needs to be ready
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+	// Not ready if a required check fails, the rest are only reported
+	Required bool
+}
+
+// HealthController serves liveness and readiness probes
+type HealthController struct {
+	Checks []*HealthCheck
+	// How long each check gets before it counts as failed
+	Timeout time.Duration
+}
+
+type ComponentStatus struct {
+	Status    string  `json:"status"`
+	Required  bool    `json:"required"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+const (
+	healthStatusOk    = "ok"
+	healthStatusError = "error"
+)
+
+// Require marks the checks in a comma separated list as required and the rest as optional
+func (hc *HealthController) Require(names string) error {
+	required := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			required[name] = true
+		}
+	}
+	for _, check := range hc.Checks {
+		check.Required = required[check.Name]
+		delete(required, check.Name)
+	}
+	for name := range required {
+		return fmt.Errorf("Unknown readiness component %s", name)
+	}
+	return nil
+}
+
+// runCheck runs a check, giving up on it after the timeout
+func (hc *HealthController) runCheck(ctx context.Context, check *HealthCheck) ComponentStatus {
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	// Buffered so the check can finish after we've given up on it
+	result := make(chan error, 1)
+	go func() {
+		result <- check.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = fmt.Errorf("Timed out after %s", timeout)
+	}
+	status := ComponentStatus{
+		Status:    healthStatusOk,
+		Required:  check.Required,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = healthStatusError
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// Readiness runs every check at once, it's ready if every required one passed
+func (hc *HealthController) Readiness(ctx context.Context) (ReadinessResponse, bool) {
+	response := ReadinessResponse{
+		Status:     healthStatusOk,
+		Components: make(map[string]ComponentStatus, len(hc.Checks)),
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range hc.Checks {
+		wg.Add(1)
+		go func(check *HealthCheck) {
+			defer wg.Done()
+			status := hc.runCheck(ctx, check)
+			mutex.Lock()
+			response.Components[check.Name] = status
+			mutex.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, status := range response.Components {
+		if status.Required && status.Status != healthStatusOk {
+			ready = false
+			response.Status = healthStatusError
+		}
+	}
+	return response, ready
+}
+
+// HandleHealthz is the liveness probe, if we can respond at all we're alive
+func (hc *HealthController) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, map[string]string{"status": healthStatusOk})
+}
+
+// HandleReadyz is the readiness probe, with the status of every component
+func (hc *HealthController) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	response, ready := hc.Readiness(r.Context())
+	if ready {
+		render.Status(r, http.StatusOK)
+	} else {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, response)
+}
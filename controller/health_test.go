@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthz(t *testing.T) {
+	hc := &HealthController{Checks: []*HealthCheck{{Name: "redis", Required: true, Check: func(ctx context.Context) error {
+		return errors.New("down")
+	}}}}
+	w := httptest.NewRecorder()
+	hc.HandleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	// Liveness doesn't depend on anything else
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"status\":\"ok\"}\n", w.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	var prices error
+	hc := &HealthController{
+		Timeout: 50 * time.Millisecond,
+		Checks: []*HealthCheck{
+			{Name: "postgres", Check: func(ctx context.Context) error { return nil }},
+			{Name: "rpc", Check: func(ctx context.Context) error {
+				// Slower than the timeout
+				<-ctx.Done()
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			}},
+			{Name: "prices", Check: func(ctx context.Context) error { return prices }},
+		},
+	}
+	assert.Nil(t, hc.Require("postgres, prices"))
+	assert.NotNil(t, hc.Require("postgres,mongo"))
+	assert.Nil(t, hc.Require("postgres, prices"))
+
+	readyz := func() (int, ReadinessResponse) {
+		w := httptest.NewRecorder()
+		hc.HandleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var response ReadinessResponse
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	// The rpc check fails, but it isn't required
+	code, response := readyz()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", response.Status)
+	assert.Len(t, response.Components, 3)
+	assert.Equal(t, ComponentStatus{Status: "ok", Required: true, LatencyMs: response.Components["postgres"].LatencyMs}, response.Components["postgres"])
+	assert.Equal(t, "error", response.Components["rpc"].Status)
+	assert.False(t, response.Components["rpc"].Required)
+	assert.Equal(t, "Timed out after 50ms", response.Components["rpc"].Error)
+	assert.GreaterOrEqual(t, response.Components["rpc"].LatencyMs, float64(50))
+
+	prices = errors.New("usd price is 1h0m0s old")
+	code, response = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "error", response.Status)
+	assert.Equal(t, "usd price is 1h0m0s old", response.Components["prices"].Error)
+}
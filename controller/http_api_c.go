@@ -9,11 +9,12 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/appditto/natrium-wallet-server/database"
 	"github.com/appditto/natrium-wallet-server/models"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
 	"github.com/appditto/natrium-wallet-server/net"
 	"github.com/appditto/natrium-wallet-server/repository"
 	"github.com/appditto/natrium-wallet-server/utils"
-	"github.com/appleboy/go-fcm"
 	"github.com/go-chi/render"
 	"github.com/mitchellh/mapstructure"
 	"golang.org/x/exp/slices"
@@ -27,9 +28,24 @@ type HttpController struct {
 	RPCClient    *net.RPCClient
 	BananoMode   bool
 	FcmTokenRepo *repository.FcmTokenRepo
-	FcmClient    *fcm.Client
+	// Push notifications are queued here for the workers to send, nil if they're disabled
+	PushQueue *database.PushQueue
+	// Smallest amount notified of in raw, unless a device set its own
+	PushMinimum *big.Int
+	// Prices in redis are under this prefix, for fiat amounts in notifications
+	PricePrefix string
+	// Work generated ahead of time for subscribed accounts, nil if disabled
+	WorkPrecache *net.WorkPrecache
+	// Scopes of requests made without an API key, nil for dbmodels.DefaultAnonymousScopes
+	AnonymousScopes []string
 }
 
+// Most a count can be, unless the API key says otherwise
+const (
+	maxCount      int64 = 1000
+	adminMaxCount int64 = 100000
+)
+
 var supportedActions = []string{
 	"account_history",
 	"process",
@@ -84,6 +100,20 @@ func (hc *HttpController) HandleAction(w http.ResponseWriter, r *http.Request) {
 
 	action := strings.ToLower(fmt.Sprintf("%v", baseRequest["action"]))
 
+	// Requests are limited to the API key's scopes, or the anonymous scopes without one
+	identity := IdentityFromContext(r.Context())
+	if !hc.hasScope(identity, actionScope(action)) {
+		ErrForbidden(w, r)
+		return
+	}
+	if identity != nil {
+		klog.Infof("API key %s: %s, %s", identity.Name, action, utils.IPAddress(r))
+		if action == "work_generate" {
+			hc.handleWorkGenerate(w, r, baseRequest)
+			return
+		}
+	}
+
 	if !slices.Contains(supportedActions, action) {
 		klog.Errorf("Action %s is not supported", action)
 		ErrUnsupportedAction(w, r)
@@ -98,16 +128,13 @@ func (hc *HttpController) HandleAction(w http.ResponseWriter, r *http.Request) {
 			ErrInvalidRequest(w, r)
 			return
 		}
-		// Get admin api key
-		adminAPIKey := r.Header.Get("Authorization")
-		// get from env
-		adminAPIKeyEnv := utils.GetEnv("ADMIN_API_KEY", "")
-		maxCount := 1000
-		if adminAPIKeyEnv != "" && adminAPIKey == adminAPIKeyEnv {
-			maxCount = 100000
+		// API keys can have a higher max
+		requestMaxCount := maxCount
+		if identity != nil && identity.MaxCount > 0 {
+			requestMaxCount = identity.MaxCount
 		}
-		if countAsInt > int64(maxCount) || countAsInt < 0 {
-			countAsInt = int64(maxCount)
+		if countAsInt > requestMaxCount || countAsInt < 0 {
+			countAsInt = requestMaxCount
 		}
 		baseRequest["count"] = countAsInt
 	}
@@ -193,19 +220,37 @@ func (hc *HttpController) HandleAction(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Reject malformed or badly signed blocks without bothering the node
+		if err := validateStateBlock(processRequestJsonBlock.Block, hc.BananoMode); err != nil {
+			ErrInvalidBlock(w, r, err)
+			return
+		}
+
 		// Check if we wanna calculate work as part of this request
 		doWork := false
 		if processRequestJsonBlock.DoWork != nil && processRequestJsonBlock.Block.Work == nil {
 			doWork = *processRequestJsonBlock.DoWork
 		}
+		if doWork && !hc.hasScope(identity, dbmodels.ScopeWork) {
+			ErrForbidden(w, r)
+			return
+		}
 
 		// Determine the type of block
+		var accountInfo map[string]interface{}
 		if processRequestJsonBlock.SubType == nil {
-			if strings.ReplaceAll(processRequestJsonBlock.Block.Link, "0", "") == "" {
-				subtype := "change"
-				processRequestJsonBlock.SubType = &subtype
+			// Infer it from the ledger, so the node gets an explicit subtype and we pick the right difficulty
+			if !utils.IsZeroHash(processRequestJsonBlock.Block.Previous) {
+				var err error
+				accountInfo, err = hc.RPCClient.MakeAccountInfoRequest(processRequestJsonBlock.Block.Account)
+				if err != nil {
+					klog.Errorf("Error making account info request %s", err)
+					ErrInternalServerError(w, r, "Error making account info request")
+					return
+				}
 			}
-		} else if !slices.Contains([]string{"change", "open", "receive", "send"}, *processRequestJsonBlock.SubType) {
+			processRequestJsonBlock.SubType = inferSubtype(processRequestJsonBlock.Block, accountInfo)
+		} else if !slices.Contains([]string{"change", "open", "receive", "send", "epoch"}, *processRequestJsonBlock.SubType) {
 			ErrBadrequest(w, r, fmt.Sprintf("Invalid subtype %s", *processRequestJsonBlock.SubType))
 			return
 		}
@@ -215,7 +260,7 @@ func (hc *HttpController) HandleAction(w http.ResponseWriter, r *http.Request) {
 		// Open blocks generate work on the public key, others use previous
 		if doWork {
 			var workBase string
-			if processRequestJsonBlock.Block.Previous == "0" || processRequestJsonBlock.Block.Previous == "0000000000000000000000000000000000000000000000000000000000000000" {
+			if utils.IsZeroHash(processRequestJsonBlock.Block.Previous) {
 				workbaseBytes, err := utils.AddressToPub(processRequestJsonBlock.Block.Account)
 				if err != nil {
 					ErrBadrequest(w, r, err.Error())
@@ -227,11 +272,14 @@ func (hc *HttpController) HandleAction(w http.ResponseWriter, r *http.Request) {
 			} else {
 				workBase = processRequestJsonBlock.Block.Previous
 				// Since we are here, let's validate the frontier
-				accountInfo, err := hc.RPCClient.MakeAccountInfoRequest(processRequestJsonBlock.Block.Account)
-				if err != nil {
-					klog.Errorf("Error making account info request %s", err)
-					ErrInternalServerError(w, r, "Error making account info request")
-					return
+				if accountInfo == nil {
+					var err error
+					accountInfo, err = hc.RPCClient.MakeAccountInfoRequest(processRequestJsonBlock.Block.Account)
+					if err != nil {
+						klog.Errorf("Error making account info request %s", err)
+						ErrInternalServerError(w, r, "Error making account info request")
+						return
+					}
 				}
 				if _, ok := accountInfo["error"]; !ok {
 					// Account is opened
@@ -247,13 +295,19 @@ func (hc *HttpController) HandleAction(w http.ResponseWriter, r *http.Request) {
 			if hc.BananoMode {
 				difficultyMultiplier = 1
 			} else if processRequestJsonBlock.SubType == nil {
-				// ! TODO - would be good to check if this is a send or receive if subtype isn't included
+				// Couldn't infer the subtype, so assume the highest difficulty
 				difficultyMultiplier = 64
 			} else if slices.Contains([]string{"change", "send"}, *processRequestJsonBlock.SubType) {
 				difficultyMultiplier = 64
 			} else {
 				difficultyMultiplier = 1
 			}
+			if hc.WorkPrecache != nil && !utils.IsZeroHash(processRequestJsonBlock.Block.Previous) {
+				if work, ok := hc.WorkPrecache.Get(workBase, difficultyMultiplier); ok {
+					processRequestJsonBlock.Block.Work = &work
+					doWork = false
+				}
+			}
 			if doWork {
 				work, err := hc.RPCClient.WorkGenerate(workBase, difficultyMultiplier)
 				if err != nil {
@@ -269,6 +323,10 @@ func (hc *HttpController) HandleAction(w http.ResponseWriter, r *http.Request) {
 			ErrInvalidRequest(w, r)
 			return
 		}
+		if err := validateBlockWork(processRequestJsonBlock.Block, processRequestJsonBlock.SubType, hc.BananoMode); err != nil {
+			ErrInvalidBlock(w, r, err)
+			return
+		}
 
 		// Now G2G to actually broadcast it
 		finalProcessRequest := map[string]interface{}{
@@ -326,7 +384,8 @@ func (hc *HttpController) HandleAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rawResp, err := hc.RPCClient.MakeRequest(baseRequest)
+	// Read-only actions may be served from the cache
+	rawResp, err := hc.RPCClient.MakeCachedRequest(baseRequest)
 	if err != nil {
 		klog.Errorf("Error making request %s", err)
 		ErrInternalServerError(w, r, "Error making request")
@@ -359,7 +418,7 @@ func (hc *HttpController) HandleHTTPCallback(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Supports push notificaiton
-	if hc.FcmClient == nil {
+	if hc.PushQueue == nil {
 		render.Status(r, http.StatusOK)
 		return
 	}
@@ -378,9 +437,6 @@ func (hc *HttpController) HandleHTTPCallback(w http.ResponseWriter, r *http.Requ
 	// if cached_hash is not None:
 	// 		return web.HTTPOk()
 
-	minimumNotification := big.NewInt(0)
-	minimumNotification.SetString("1000000000000000000000000", 10)
-
 	curBalance := big.NewInt(0)
 	curBalance, ok := curBalance.SetString(callbackBlock.Balance, 10)
 	if !ok {
@@ -397,70 +453,46 @@ func (hc *HttpController) HandleHTTPCallback(w http.ResponseWriter, r *http.Requ
 
 	// Delta
 	sendAmount := big.NewInt(0).Sub(prevBalance, curBalance)
-	if sendAmount.Cmp(minimumNotification) > 0 {
+	if sendAmount.Sign() > 0 {
 		// Is a send we want to notify if we can
-		// See if we have any tokens for this account
-		tokens, err := hc.FcmTokenRepo.GetTokensForAccount(callbackBlock.LinkAsAccount)
-		if err != nil {
-			klog.Errorf("Error finding tokens for account %s %v", tokens, err)
-			// No tokens
-			render.Status(r, http.StatusOK)
-			return
-		}
-		if len(tokens) == 0 {
-			// No tokens
-			render.Status(r, http.StatusOK)
-			return
-		}
+		hc.queueNotifications(callback.Hash, callback.Account, callbackBlock.LinkAsAccount, sendAmount)
+	}
 
-		// We have tokens, make it happen
-		var notificationTitle string
-		var appName string
+	render.Status(r, http.StatusOK)
+}
+
+// handleWorkGenerate generates work with our work providers, only for API keys with the work scope
+func (hc *HttpController) handleWorkGenerate(w http.ResponseWriter, r *http.Request, baseRequest map[string]interface{}) {
+	var workRequest models.WorkGenerateRequest
+	if err := mapstructure.WeakDecode(baseRequest, &workRequest); err != nil {
+		ErrInvalidRequest(w, r)
+		return
+	}
+	if _, err := utils.DecodeHash(workRequest.Hash); err != nil {
+		ErrBadrequest(w, r, "Invalid hash")
+		return
+	}
+	if workRequest.DifficultyMultiplier == 0 {
+		// Send difficulty
+		workRequest.DifficultyMultiplier = 64
 		if hc.BananoMode {
-			appName = "Kalium"
-			asBan, err := utils.RawToBanano(sendAmount.String(), true)
-			if err != nil {
-				klog.Errorf("Error converting raw to banano %s", err)
-				render.Status(r, http.StatusOK)
-				return
-			}
-			notificationTitle = fmt.Sprintf("Received %s BANANO", strconv.FormatFloat(asBan, 'f', -1, 64))
-		} else {
-			appName = "Natrium"
-			asBan, err := utils.RawToNano(sendAmount.String(), true)
-			if err != nil {
-				klog.Errorf("Error converting raw to nano %s", err)
-				render.Status(r, http.StatusOK)
-				return
-			}
-			notificationTitle = fmt.Sprintf("Received Ӿ%s", strconv.FormatFloat(asBan, 'f', -1, 64))
-		}
-		notificationBody := fmt.Sprintf("Open %s to receive this transaction.", appName)
-
-		for _, token := range tokens {
-			// Create the message to be sent.
-			msg := &fcm.Message{
-				To:       token.FcmToken,
-				Priority: "high",
-				Data: map[string]interface{}{
-					"click_action": "FLUTTER_NOTIFICATION_CLICK",
-					"account":      callbackBlock.LinkAsAccount,
-				},
-				Notification: &fcm.Notification{
-					Title: notificationTitle,
-					Body:  notificationBody,
-					Tag:   callbackBlock.LinkAsAccount,
-					Sound: "default",
-				},
-			}
-			_, err := hc.FcmClient.Send(msg)
-			if err != nil {
-				klog.Errorf("Error sending notification %s", err)
-				render.Status(r, http.StatusOK)
-				return
-			}
+			workRequest.DifficultyMultiplier = 1
 		}
 	}
+	if workRequest.DifficultyMultiplier < 1 || workRequest.DifficultyMultiplier > 64 {
+		ErrBadrequest(w, r, "difficulty_multiplier must be between 1 and 64")
+		return
+	}
 
+	work, err := hc.RPCClient.WorkGenerate(workRequest.Hash, workRequest.DifficultyMultiplier)
+	if err != nil {
+		klog.Errorf("Error generating work %s", err)
+		ErrInternalServerError(w, r, "Error generating work")
+		return
+	}
 	render.Status(r, http.StatusOK)
+	render.JSON(w, r, map[string]interface{}{
+		"hash": workRequest.Hash,
+		"work": work,
+	})
 }
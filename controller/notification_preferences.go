@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/models"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/appditto/natrium-wallet-server/net"
+	"github.com/appditto/natrium-wallet-server/repository"
+	"github.com/appditto/natrium-wallet-server/utils"
+	"github.com/go-chi/render"
+	"github.com/mitchellh/mapstructure"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+)
+
+// Language tags like en, pt-BR or zh-Hant
+var languagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// Language notifications are written in unless the device picks one, the column's default
+const defaultNotificationLanguage = "en"
+
+// PreferencesError is a problem with a notification preferences request, the message is shown to the client
+type PreferencesError struct {
+	Message string
+}
+
+func (e *PreferencesError) Error() string {
+	return e.Message
+}
+
+// applyNotificationPreferences checks the request and changes the preferences it sets
+func applyNotificationPreferences(preferences *dbmodels.NotificationPreferences, request *models.NotificationPreferences, bananoMode bool) *PreferencesError {
+	if request.MinimumAmount != nil {
+		preferences.MinimumRaw = ""
+		if *request.MinimumAmount != "" {
+			minimum, err := utils.AmountToRaw(*request.MinimumAmount, bananoMode)
+			if err != nil {
+				return &PreferencesError{Message: "Invalid minimum amount"}
+			}
+			preferences.MinimumRaw = minimum.String()
+		}
+	}
+	if request.Language != nil {
+		language := strings.ReplaceAll(*request.Language, "_", "-")
+		if language == "" {
+			// Back to the default
+			language = defaultNotificationLanguage
+		} else if !languagePattern.MatchString(language) {
+			return &PreferencesError{Message: "Invalid language"}
+		}
+		preferences.Language = language
+	}
+	if request.Currency != nil {
+		currency := strings.ToUpper(*request.Currency)
+		if currency != "" && !slices.Contains(net.CurrencyList, currency) {
+			return &PreferencesError{Message: "Invalid currency"}
+		}
+		preferences.Currency = currency
+	}
+	if quiet := request.QuietHours; quiet != nil {
+		if quiet.Start == "" && quiet.End == "" {
+			preferences.QuietStart, preferences.QuietEnd, preferences.Timezone = "", "", ""
+		} else {
+			start, startErr := time.Parse("15:04", quiet.Start)
+			end, endErr := time.Parse("15:04", quiet.End)
+			if startErr != nil || endErr != nil || start.Equal(end) {
+				return &PreferencesError{Message: "Invalid quiet hours"}
+			}
+			if _, err := time.LoadLocation(quiet.Timezone); err != nil || quiet.Timezone == "Local" {
+				return &PreferencesError{Message: "Invalid timezone"}
+			}
+			preferences.QuietStart = start.Format("15:04")
+			preferences.QuietEnd = end.Format("15:04")
+			preferences.Timezone = quiet.Timezone
+		}
+	}
+	for event, enabled := range request.Events {
+		switch event {
+		case dbmodels.NotificationEventReceive:
+			preferences.NotifyReceive = enabled
+		case dbmodels.NotificationEventSend:
+			preferences.NotifySend = enabled
+		default:
+			return &PreferencesError{Message: "Invalid event " + event}
+		}
+	}
+	return nil
+}
+
+// notificationPreferencesResponse is every preference of the token, in the shape of a request
+func notificationPreferencesResponse(token *dbmodels.FcmToken, bananoMode bool) *models.NotificationPreferences {
+	preferences := token.NotificationPreferences
+	minimumAmount := ""
+	if minimum, err := utils.RawToBigInt(preferences.MinimumRaw); err == nil {
+		minimumAmount = utils.RawToAmount(minimum, bananoMode)
+	}
+	return &models.NotificationPreferences{
+		FcmToken:      token.FcmToken,
+		Account:       token.Account,
+		MinimumAmount: &minimumAmount,
+		Language:      &preferences.Language,
+		Currency:      &preferences.Currency,
+		QuietHours: &models.QuietHours{
+			Start:    preferences.QuietStart,
+			End:      preferences.QuietEnd,
+			Timezone: preferences.Timezone,
+		},
+		Events: map[string]bool{
+			dbmodels.NotificationEventReceive: preferences.NotifyReceive,
+			dbmodels.NotificationEventSend:    preferences.NotifySend,
+		},
+	}
+}
+
+// updateNotificationPreferences changes the token's preferences for the account, it has to be registered for it already
+func updateNotificationPreferences(repo *repository.FcmTokenRepo, request *models.NotificationPreferences, bananoMode bool) (*models.NotificationPreferences, error) {
+	account := normalizeAccount(request.Account, bananoMode)
+	if !utils.ValidateAddress(account, bananoMode) {
+		return nil, &PreferencesError{Message: "Invalid account"}
+	}
+	if request.FcmToken == "" {
+		return nil, &PreferencesError{Message: "fcm_token_v2 is required"}
+	}
+	token, err := repo.GetToken(request.FcmToken, account)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, &PreferencesError{Message: "Notifications aren't enabled for this account"}
+	}
+	if err := applyNotificationPreferences(&token.NotificationPreferences, request, bananoMode); err != nil {
+		return nil, err
+	}
+	if err := repo.UpdatePreferences(token.FcmToken, token.Account, &token.NotificationPreferences); err != nil {
+		return nil, err
+	}
+	return notificationPreferencesResponse(token, bananoMode), nil
+}
+
+// HandleNotificationPreferences changes a device's notification preferences for an account, and responds with all of them
+func (hc *HttpController) HandleNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	var request models.NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		ErrInvalidRequest(w, r)
+		return
+	}
+	response, err := updateNotificationPreferences(hc.FcmTokenRepo, &request, hc.BananoMode)
+	var preferencesErr *PreferencesError
+	if errors.As(err, &preferencesErr) {
+		ErrBadrequest(w, r, preferencesErr.Message)
+		return
+	}
+	if err != nil {
+		klog.Errorf("Error updating notification preferences %v", err)
+		ErrInternalServerError(w, r, "Error updating notification preferences")
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, response)
+}
+
+// handleNotificationPreferences is the websocket version of HandleNotificationPreferences
+func (c *Client) handleNotificationPreferences(baseRequest map[string]interface{}) {
+	var request models.NotificationPreferences
+	if err := mapstructure.Decode(baseRequest, &request); err != nil {
+		klog.Errorf("Error unmarshalling websocket notification_preferences request %s", err)
+		errJson, _ := json.Marshal(InvalidRequestError)
+		c.Hub.BroadcastToClient(c, errJson)
+		return
+	}
+	response, err := updateNotificationPreferences(c.Hub.FcmTokenRepo, &request, c.Hub.BananoMode)
+	var preferencesErr *PreferencesError
+	if errors.As(err, &preferencesErr) {
+		errJson, _ := json.Marshal(&ErrorResponse{Error: preferencesErr.Message})
+		c.Hub.BroadcastToClient(c, errJson)
+		return
+	}
+	if err != nil {
+		klog.Errorf("Error updating notification preferences %v", err)
+		c.Hub.BroadcastToClient(c, []byte("{\"error\":\"Error updating notification preferences\"}"))
+		return
+	}
+	responseJson, _ := json.Marshal(response)
+	c.Hub.BroadcastToClient(c, responseJson)
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/models"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/stretchr/testify/assert"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestApplyNotificationPreferences(t *testing.T) {
+	preferences := dbmodels.NotificationPreferences{Language: "en", NotifyReceive: true}
+	err := applyNotificationPreferences(&preferences, &models.NotificationPreferences{
+		MinimumAmount: strPtr("0.5"),
+		Language:      strPtr("pt_BR"),
+		Currency:      strPtr("eur"),
+		QuietHours:    &models.QuietHours{Start: "22:00", End: "7:00", Timezone: "America/Sao_Paulo"},
+		Events:        map[string]bool{"send": true},
+	}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, dbmodels.NotificationPreferences{
+		MinimumRaw:    "500000000000000000000000000000",
+		Language:      "pt-BR",
+		Currency:      "EUR",
+		QuietStart:    "22:00",
+		QuietEnd:      "07:00",
+		Timezone:      "America/Sao_Paulo",
+		NotifyReceive: true,
+		NotifySend:    true,
+	}, preferences)
+
+	// Only what's in the request changes
+	err = applyNotificationPreferences(&preferences, &models.NotificationPreferences{
+		MinimumAmount: strPtr(""),
+		QuietHours:    &models.QuietHours{},
+		Events:        map[string]bool{"receive": false},
+	}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, dbmodels.NotificationPreferences{Language: "pt-BR", Currency: "EUR", NotifySend: true}, preferences)
+
+	// Empty goes back to the default
+	err = applyNotificationPreferences(&preferences, &models.NotificationPreferences{Language: strPtr(""), Currency: strPtr("")}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, dbmodels.NotificationPreferences{Language: "en", NotifySend: true}, preferences)
+
+	for message, request := range map[string]*models.NotificationPreferences{
+		"Invalid minimum amount": {MinimumAmount: strPtr("-1")},
+		"Invalid language":       {Language: strPtr("english!")},
+		"Invalid currency":       {Currency: strPtr("DOGE")},
+		"Invalid quiet hours":    {QuietHours: &models.QuietHours{Start: "22:00", End: "22:00"}},
+		"Invalid timezone":       {QuietHours: &models.QuietHours{Start: "22:00", End: "07:00", Timezone: "Mars/Olympus_Mons"}},
+		"Invalid event change":   {Events: map[string]bool{"change": true}},
+	} {
+		err = applyNotificationPreferences(&preferences, request, false)
+		assert.NotNil(t, err, message)
+		if err != nil {
+			assert.Equal(t, message, err.Message)
+		}
+	}
+}
+
+func TestNotificationPreferencesResponse(t *testing.T) {
+	token := &dbmodels.FcmToken{
+		FcmToken: "token",
+		Account:  "ban_1natrium1o3z5519ifou7xii8crpxpk8y65qmkih8e8bpsjri651oza8imdd",
+		NotificationPreferences: dbmodels.NotificationPreferences{
+			MinimumRaw:    "1500000000000000000000000000000",
+			Language:      "es",
+			NotifyReceive: true,
+		},
+	}
+	response := notificationPreferencesResponse(token, true)
+	assert.Equal(t, "15", *response.MinimumAmount)
+	assert.Equal(t, "es", *response.Language)
+	assert.Equal(t, "", *response.Currency)
+	assert.Equal(t, &models.QuietHours{}, response.QuietHours)
+	assert.Equal(t, map[string]bool{"receive": true, "send": false}, response.Events)
+
+	// The server's default
+	token.MinimumRaw = ""
+	assert.Equal(t, "", *notificationPreferencesResponse(token, true).MinimumAmount)
+}
+
+func TestNotificationText(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	database.GetRedisDB().Hset("prices", "coingecko:nano-eur", 0.8)
+	hc := &HttpController{PricePrefix: "nano"}
+	amount, _ := new(big.Int).SetString("2500000000000000000000000000000", 10)
+	prices := map[string]float64{}
+
+	title, body := hc.notificationText(dbmodels.NotificationEventReceive, amount, "en", "", prices)
+	assert.Equal(t, "Received Ӿ2.5", title)
+	assert.Equal(t, "Open Natrium to receive this transaction.", body)
+	title, body = hc.notificationText(dbmodels.NotificationEventSend, amount, "en", "EUR", prices)
+	assert.Equal(t, "Sent Ӿ2.5", title)
+	assert.Equal(t, "Worth €2.00. Open Natrium to see this transaction.", body)
+	assert.Equal(t, map[string]float64{"EUR": 0.8}, prices)
+	// No price, so no fiat amount
+	_, body = hc.notificationText(dbmodels.NotificationEventReceive, amount, "en", "JPY", prices)
+	assert.Equal(t, "Open Natrium to receive this transaction.", body)
+	// In the device's language
+	title, body = hc.notificationText(dbmodels.NotificationEventReceive, amount, "de-AT", "EUR", prices)
+	assert.Equal(t, "Ӿ2,5 erhalten", title)
+	assert.Equal(t, "Im Wert von 2,00\u00a0€. Öffne Natrium, um diese Transaktion zu empfangen.", body)
+
+	hc.BananoMode = true
+	title, body = hc.notificationText(dbmodels.NotificationEventReceive, amount, "", "", prices)
+	assert.Equal(t, "Received 25 BANANO", title)
+	assert.Equal(t, "Open Kalium to receive this transaction.", body)
+}
+
+func TestNotificationMinimum(t *testing.T) {
+	hc := &HttpController{PushMinimum: big.NewInt(1000)}
+	assert.Equal(t, big.NewInt(1000), hc.notificationMinimum(&dbmodels.FcmToken{}))
+	assert.Equal(t, big.NewInt(5), hc.notificationMinimum(&dbmodels.FcmToken{NotificationPreferences: dbmodels.NotificationPreferences{MinimumRaw: "5"}}))
+}
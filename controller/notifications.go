@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/appditto/natrium-wallet-server/notifications"
+	"github.com/appditto/natrium-wallet-server/utils"
+	"k8s.io/klog/v2"
+)
+
+// notification is one device to notify of a block
+type notification struct {
+	event string
+	token dbmodels.FcmToken
+}
+
+// queueNotifications notifies the devices of the receiving account, and of the sending account if they want to know about sends
+// Each device's preferences decide whether it's notified, what the notification says and when it's sent
+func (hc *HttpController) queueNotifications(hash string, sender string, receiver string, amount *big.Int) {
+	var notifications []notification
+	for _, target := range []struct {
+		event   string
+		account string
+	}{{dbmodels.NotificationEventReceive, receiver}, {dbmodels.NotificationEventSend, sender}} {
+		if target.account == "" {
+			continue
+		}
+		tokens, err := hc.FcmTokenRepo.GetTokensForAccount(target.account)
+		if err != nil {
+			klog.Errorf("Error finding tokens for account %s %v", target.account, err)
+			continue
+		}
+		for _, token := range tokens {
+			if token.Wants(target.event) && amount.Cmp(hc.notificationMinimum(&token)) >= 0 {
+				notifications = append(notifications, notification{event: target.event, token: token})
+			}
+		}
+	}
+	if len(notifications) == 0 {
+		return
+	}
+
+	now := time.Now()
+	prices := map[string]float64{}
+	for _, n := range notifications {
+		title, body := hc.notificationText(n.event, amount, n.token.Language, n.token.Currency, prices)
+		// Held back until the device's quiet hours are over
+		at, _ := n.token.QuietUntil(now)
+		// Queue them, so a slow or failing push service doesn't hold up the node
+		// Jobs are deduped by block and token, so a callback we get more than once is only notified once
+		_, err := hc.PushQueue.EnqueueAt(&database.PushJob{
+			Hash:     hash,
+			Token:    n.token.FcmToken,
+			Platform: n.token.Platform,
+			Title:    title,
+			Body:     body,
+			Tag:      n.token.Account,
+			Data: map[string]string{
+				"click_action": "FLUTTER_NOTIFICATION_CLICK",
+				"account":      n.token.Account,
+				"event":        n.event,
+			},
+		}, at)
+		if err != nil {
+			klog.Errorf("Error queueing notification %s %v", hash, err)
+		}
+	}
+}
+
+// notificationMinimum is the smallest amount the device is notified of
+func (hc *HttpController) notificationMinimum(token *dbmodels.FcmToken) *big.Int {
+	if minimum, err := utils.RawToBigInt(token.MinimumRaw); err == nil {
+		return minimum
+	}
+	if hc.PushMinimum == nil {
+		return big.NewInt(0)
+	}
+	return hc.PushMinimum
+}
+
+// notificationText is the title and body of a notification in the device's language, the body has the amount in fiat too if there's a currency
+// Prices are cached in prices, so they're only looked up once per callback
+func (hc *HttpController) notificationText(event string, amount *big.Int, language string, currency string, prices map[string]float64) (string, string) {
+	brand := notifications.BrandFor(hc.BananoMode)
+	if currency == "" {
+		return notifications.DefaultCatalog().Render(brand, language, event, amount, nil)
+	}
+	price, ok := prices[currency]
+	if !ok {
+		price = hc.price(currency)
+		prices[currency] = price
+	}
+	// No price, leave it out
+	if price == 0 {
+		return notifications.DefaultCatalog().Render(brand, language, event, amount, nil)
+	}
+	asFloat, _ := strconv.ParseFloat(utils.RawToAmount(amount, hc.BananoMode), 64)
+	return notifications.DefaultCatalog().Render(brand, language, event, amount, &notifications.Fiat{Currency: currency, Amount: asFloat * price})
+}
+
+// price is the coin's price in the currency, 0 if we don't have one
+func (hc *HttpController) price(currency string) float64 {
+	key := fmt.Sprintf("coingecko:%s-%s", hc.PricePrefix, strings.ToLower(currency))
+	priceStr, err := database.GetRedisDB().Hget("prices", key)
+	if err != nil {
+		klog.Errorf("Error getting price %s %v", key, err)
+		return 0
+	}
+	price, err := strconv.ParseFloat(priceStr, 64)
+	if err != nil {
+		klog.Errorf("Error parsing price %s %v", key, err)
+		return 0
+	}
+	return price
+}
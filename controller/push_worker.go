@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/metrics"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/appditto/natrium-wallet-server/net"
+	"k8s.io/klog/v2"
+)
+
+// TokenRemover forgets tokens that will never work again, FcmTokenRepo in production
+type TokenRemover interface {
+	DeleteFcmToken(token string) error
+}
+
+// PushWorker delivers queued push notifications, retrying failures with exponential backoff
+type PushWorker struct {
+	Queue  *database.PushQueue
+	Sender net.PushSender
+	Tokens TokenRemover
+	// How many notifications this replica sends at once
+	Workers int
+	// Jobs are dead lettered after this many failed attempts
+	MaxAttempts int
+	// Wait before the first retry, doubled for every retry after that
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// For each attempt
+	SendTimeout time.Duration
+}
+
+// Run delivers jobs until the context is done, then waits for the ones in progress
+func (w *PushWorker) Run(ctx context.Context) {
+	for {
+		err := w.Queue.Init()
+		if err == nil {
+			break
+		}
+		klog.Errorf("Error creating push queue %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work(ctx)
+		}()
+	}
+
+	// Retries that are due, and jobs left behind by workers that died
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			if err := w.Queue.Close(); err != nil {
+				klog.Errorf("Error leaving push queue %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := w.Queue.PromoteDue(time.Now(), 100); err != nil {
+				klog.Errorf("Error promoting push retries %v", err)
+			}
+			stale, err := w.Queue.ClaimStale(100)
+			if err != nil {
+				klog.Errorf("Error claiming stale push jobs %v", err)
+			}
+			for _, job := range stale {
+				w.Deliver(job)
+			}
+		}
+	}
+}
+
+func (w *PushWorker) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		jobs, err := w.Queue.Read(1, time.Second)
+		if err != nil {
+			klog.Errorf("Error reading push queue %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, job := range jobs {
+			w.Deliver(job)
+		}
+	}
+}
+
+// Deliver sends the job, then acks, retries or dead letters it
+// Sends aren't tied to the worker's context, so shutting down doesn't cut them off
+func (w *PushWorker) Deliver(job *database.PushJob) {
+	if job.ID == "" {
+		klog.Errorf("Dropping push job %s: %s", job.StreamID, job.LastError)
+		w.ack(job)
+		return
+	}
+	// Handled before, but not acked
+	if sent, err := w.Queue.WasSent(job); err == nil && sent {
+		w.ack(job)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.SendTimeout)
+	err := w.Sender.Send(ctx, &net.PushMessage{
+		Token:    job.Token,
+		Platform: job.Platform,
+		Title:    job.Title,
+		Body:     job.Body,
+		Tag:      job.Tag,
+		Data:     job.Data,
+	})
+	cancel()
+	platform := job.Platform
+	if platform == "" {
+		platform = dbmodels.PlatformFCM
+	}
+	if err == nil {
+		metrics.PushSends.WithLabelValues(platform).Inc()
+		if err := w.Queue.MarkSent(job); err != nil {
+			klog.Errorf("Error marking push job sent %v", err)
+		}
+		w.ack(job)
+		return
+	}
+
+	metrics.PushFailures.WithLabelValues(platform).Inc()
+	if errors.Is(err, net.ErrInvalidToken) {
+		klog.Infof("Removing invalid push token %s: %v", job.Token, err)
+		if err := w.Tokens.DeleteFcmToken(job.Token); err != nil {
+			klog.Errorf("Error removing invalid push token %v", err)
+		}
+		w.ack(job)
+		return
+	}
+
+	job.Attempts++
+	job.LastError = err.Error()
+	// Retrying won't configure the platform, it's replayed once it is
+	if errors.Is(err, net.ErrNoPushSender) {
+		klog.Errorf("Giving up on push job %s: %v", job.ID, err)
+		w.deadLetter(job)
+		return
+	}
+	if job.Attempts >= w.MaxAttempts {
+		klog.Errorf("Giving up on push job %s after %d attempts: %v", job.ID, job.Attempts, err)
+		w.deadLetter(job)
+		return
+	}
+	metrics.PushRetries.Inc()
+	if err := w.Queue.Retry(job, time.Now().Add(w.backoff(job.Attempts))); err != nil {
+		klog.Errorf("Error retrying push job %v", err)
+	}
+}
+
+func (w *PushWorker) deadLetter(job *database.PushJob) {
+	metrics.PushDeadLetters.Inc()
+	if err := w.Queue.DeadLetter(job); err != nil {
+		klog.Errorf("Error dead lettering push job %v", err)
+	}
+}
+
+func (w *PushWorker) ack(job *database.PushJob) {
+	if err := w.Queue.Ack(job); err != nil {
+		klog.Errorf("Error acking push job %v", err)
+	}
+}
+
+// backoff doubles with every attempt up to the max, with some jitter so retries after an outage are spread out
+func (w *PushWorker) backoff(attempts int) time.Duration {
+	backoff := w.RetryBackoff
+	for i := 1; i < attempts && backoff < w.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > w.MaxRetryBackoff {
+		backoff = w.MaxRetryBackoff
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/metrics"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/appditto/natrium-wallet-server/net"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTokenRemover struct {
+	removed []string
+}
+
+func (f *fakeTokenRemover) DeleteFcmToken(token string) error {
+	f.removed = append(f.removed, token)
+	return nil
+}
+
+// fakePushSender keeps what it's asked to send instead of sending it
+type fakePushSender struct {
+	// Tokens that fail, with their error
+	Errors map[string]error
+
+	mu   sync.Mutex
+	sent []*net.PushMessage
+}
+
+func (f *fakePushSender) Send(ctx context.Context, msg *net.PushMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err, ok := f.Errors[msg.Token]; ok {
+		return err
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// SetError makes sends to the token fail with err, or succeed again if it's nil
+func (f *fakePushSender) SetError(token string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.Errors, token)
+		return
+	}
+	if f.Errors == nil {
+		f.Errors = map[string]error{}
+	}
+	f.Errors[token] = err
+}
+
+// Sent returns the messages sent successfully so far
+func (f *fakePushSender) Sent() []*net.PushMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*net.PushMessage(nil), f.sent...)
+}
+
+func newTestPushWorker(prefix string) (*PushWorker, *fakePushSender, *fakeTokenRemover) {
+	sender := &fakePushSender{}
+	tokens := &fakeTokenRemover{}
+	worker := &PushWorker{
+		Queue:           database.NewPushQueue(prefix, "replica1"),
+		Sender:          sender,
+		Tokens:          tokens,
+		Workers:         1,
+		MaxAttempts:     3,
+		RetryBackoff:    time.Millisecond,
+		MaxRetryBackoff: 2 * time.Millisecond,
+		SendTimeout:     time.Second,
+	}
+	worker.Queue.Init()
+	return worker, sender, tokens
+}
+
+// Reads and delivers whatever is in the stream, promoting retries first
+func deliverQueued(t *testing.T, worker *PushWorker) int {
+	assert.Equal(t, nil, worker.Queue.PromoteDue(time.Now().Add(time.Hour), 100))
+	jobs, err := worker.Queue.Read(100, 0)
+	assert.Equal(t, nil, err)
+	for _, job := range jobs {
+		worker.Deliver(job)
+	}
+	return len(jobs)
+}
+
+func TestPushWorkerDeliver(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	worker, sender, tokens := newTestPushWorker("push_worker_test_deliver")
+	fcmSends := testutil.ToFloat64(metrics.PushSends.WithLabelValues("fcm"))
+	apnsFailures := testutil.ToFloat64(metrics.PushFailures.WithLabelValues("apns"))
+
+	worker.Queue.Enqueue(&database.PushJob{Hash: "ABC", Token: "good", Title: "Received Ӿ1"})
+	worker.Queue.Enqueue(&database.PushJob{Hash: "ABC", Token: "invalid", Platform: "apns", Title: "Received Ӿ1"})
+	sender.SetError("invalid", fmt.Errorf("%w: BadDeviceToken", net.ErrInvalidToken))
+	assert.Equal(t, 2, deliverQueued(t, worker))
+	assert.Equal(t, fcmSends+1, testutil.ToFloat64(metrics.PushSends.WithLabelValues("fcm")))
+	assert.Equal(t, apnsFailures+1, testutil.ToFloat64(metrics.PushFailures.WithLabelValues("apns")))
+
+	assert.Equal(t, 1, len(sender.Sent()))
+	assert.Equal(t, "good", sender.Sent()[0].Token)
+	assert.Equal(t, "Received Ӿ1", sender.Sent()[0].Title)
+	// Invalid tokens are removed, not retried
+	assert.Equal(t, []string{"invalid"}, tokens.removed)
+	stats, _ := worker.Queue.Stats()
+	assert.Equal(t, database.PushQueueStats{Queued: 0, Retrying: 0, Dead: 0}, *stats)
+	assert.Equal(t, 0, deliverQueued(t, worker))
+
+	// Already delivered, e.g. the replica died before acking
+	job := &database.PushJob{Hash: "DEF", Token: "good", Title: "Received Ӿ2"}
+	worker.Queue.Enqueue(job)
+	worker.Queue.MarkSent(job)
+	assert.Equal(t, 1, deliverQueued(t, worker))
+	assert.Equal(t, 1, len(sender.Sent()))
+}
+
+func TestPushWorkerRetries(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	worker, sender, tokens := newTestPushWorker("push_worker_test_retries")
+
+	worker.Queue.Enqueue(&database.PushJob{Hash: "ABC", Token: "flaky", Title: "Received Ӿ1"})
+	sender.SetError("flaky", errors.New("FCM returned status 503"))
+	assert.Equal(t, 1, deliverQueued(t, worker))
+	stats, _ := worker.Queue.Stats()
+	assert.Equal(t, database.PushQueueStats{Queued: 0, Retrying: 1, Dead: 0}, *stats)
+
+	// Dead lettered once it runs out of attempts
+	assert.Equal(t, 1, deliverQueued(t, worker))
+	assert.Equal(t, 1, deliverQueued(t, worker))
+	assert.Equal(t, 0, deliverQueued(t, worker))
+	stats, _ = worker.Queue.Stats()
+	assert.Equal(t, database.PushQueueStats{Queued: 0, Retrying: 0, Dead: 1}, *stats)
+	assert.Equal(t, 0, len(tokens.removed))
+
+	w := httptest.NewRecorder()
+	worker.HandleDeadPushJobs(w, httptest.NewRequest(http.MethodGet, "/admin/push/dead", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var dead []database.PushJob
+	json.Unmarshal(w.Body.Bytes(), &dead)
+	assert.Equal(t, 1, len(dead))
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Equal(t, "FCM returned status 503", dead[0].LastError)
+
+	// Replayed once the problem is fixed
+	sender.SetError("flaky", nil)
+	replay := func(id string) *httptest.ResponseRecorder {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		r := httptest.NewRequest(http.MethodPost, "/admin/push/dead/"+id+"/replay", nil)
+		ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+		ctx = context.WithValue(ctx, identityContextKey, &dbmodels.ApiKey{Name: "ops"})
+		w := httptest.NewRecorder()
+		worker.HandleReplayPushJob(w, r.WithContext(ctx))
+		return w
+	}
+	w = replay(dead[0].ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"replayed\":[\""+dead[0].ID+"\"]}\n", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, replay(dead[0].ID).Code)
+	assert.Equal(t, 1, deliverQueued(t, worker))
+	assert.Equal(t, 1, len(sender.Sent()))
+	stats, _ = worker.Queue.Stats()
+	assert.Equal(t, database.PushQueueStats{Queued: 0, Retrying: 0, Dead: 0}, *stats)
+}
+
+// A platform without a sender isn't retried
+func TestPushWorkerNoSender(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	worker, sender, _ := newTestPushWorker("push_worker_test_no_sender")
+	worker.Sender = net.PushRouter{dbmodels.PlatformFCM: sender}
+
+	worker.Queue.Enqueue(&database.PushJob{Hash: "ABC", Token: "apns1", Platform: dbmodels.PlatformAPNs, Title: "Received Ӿ1"})
+	assert.Equal(t, 1, deliverQueued(t, worker))
+	stats, _ := worker.Queue.Stats()
+	assert.Equal(t, database.PushQueueStats{Queued: 0, Retrying: 0, Dead: 1}, *stats)
+	dead, err := worker.Queue.DeadJobs()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(dead))
+	assert.Equal(t, "No push sender for platform apns", dead[0].LastError)
+}
+
+func TestPushWorkerBackoff(t *testing.T) {
+	worker := &PushWorker{RetryBackoff: 5 * time.Second, MaxRetryBackoff: time.Minute}
+	for attempts, expected := range map[int]time.Duration{1: 5 * time.Second, 2: 10 * time.Second, 4: 40 * time.Second, 5: time.Minute, 20: time.Minute} {
+		backoff := worker.backoff(attempts)
+		assert.GreaterOrEqual(t, backoff, expected)
+		assert.LessOrEqual(t, backoff, expected+expected/5)
+	}
+}
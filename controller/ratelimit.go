@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/config"
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/metrics"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/appditto/natrium-wallet-server/utils"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+)
+
+// Largest request body we read to work out what a request costs, the biggest real ones are lists of accounts
+const maxRequestBodySize = 1 << 20
+
+// RateLimitTier is a named token bucket, everyone in the tier gets their own
+type RateLimitTier struct {
+	Name string
+	database.TokenBucket
+}
+
+// NewRateLimitTier is a tier with a bucket of rate.Requests tokens refilled over rate.Period
+func NewRateLimitTier(name string, rate config.Rate) RateLimitTier {
+	return RateLimitTier{
+		Name:        name,
+		TokenBucket: database.TokenBucket{Capacity: rate.Requests, Period: rate.Period},
+	}
+}
+
+// ParseRateLimitTier parses a limit like 100/1m, 100 tokens refilled over a minute
+func ParseRateLimitTier(name string, limit string) (RateLimitTier, error) {
+	rate, err := config.ParseRate(limit)
+	if err != nil {
+		return RateLimitTier{}, fmt.Errorf("Invalid rate limit %s for tier %s", limit, name)
+	}
+	return NewRateLimitTier(name, rate), nil
+}
+
+// What an action costs, anything not listed costs 1
+var actionCosts = map[string]int64{
+	"process":            5,
+	"account_history":    2,
+	"accounts_balances":  2,
+	"accounts_frontiers": 2,
+	"accounts_pending":   2,
+	"blocks_info":        2,
+	"chain":              2,
+	"history":            2,
+	"representatives":    2,
+	"accounts_subscribe": 2,
+}
+
+// On top of the action's cost, every this many items asked for by count or listed in hashes or accounts costs 1 more
+const itemsPerCost = 100
+
+// RequestCost is what a request costs against the rate limit, by its action and how much it asks for
+func RequestCost(baseRequest map[string]interface{}) int64 {
+	action := strings.ToLower(fmt.Sprintf("%v", baseRequest["action"]))
+	cost, ok := actionCosts[action]
+	if !ok {
+		cost = 1
+	}
+
+	var items int64
+	if val, ok := baseRequest["count"]; ok {
+		count, err := strconv.ParseInt(fmt.Sprintf("%v", val), 10, 64)
+		if err == nil {
+			// Negative counts are trimmed to the max by HandleAction
+			if count < 0 {
+				count = maxCount
+			}
+			items = count
+		}
+	}
+	for _, field := range []string{"hashes", "accounts"} {
+		if list, ok := baseRequest[field].([]interface{}); ok && int64(len(list)) > items {
+			items = int64(len(list))
+		}
+	}
+	return cost + int64(math.Ceil(float64(items)/itemsPerCost))
+}
+
+// RateLimiter charges requests against a token bucket in redis, shared by every replica
+type RateLimiter struct {
+	Prefix string
+
+	Default     RateLimitTier
+	Whitelisted RateLimitTier
+	Admin       RateLimitTier
+
+	// IPs in the whitelisted tier
+	Whitelist []string
+	// Paths that are never limited, like the node's callback
+	Unlimited []string
+}
+
+// NewRateLimiter creates a rate limiter with the configured tiers, keys are prefixed with prefix
+func NewRateLimiter(prefix string, cfg config.RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		Prefix:      prefix,
+		Default:     NewRateLimitTier(dbmodels.RateTierDefault, cfg.Default),
+		Whitelisted: NewRateLimitTier(dbmodels.RateTierWhitelisted, cfg.Whitelisted),
+		Admin:       NewRateLimitTier(dbmodels.RateTierAdmin, cfg.Admin),
+		Whitelist:   cfg.Whitelist,
+	}
+}
+
+// Tier returns the tier for the request, by its API key or IP
+func (rl *RateLimiter) Tier(r *http.Request) RateLimitTier {
+	if identity := IdentityFromContext(r.Context()); identity != nil {
+		switch identity.RateTier {
+		case rl.Whitelisted.Name:
+			return rl.Whitelisted
+		case rl.Admin.Name:
+			return rl.Admin
+		}
+		return rl.Default
+	}
+	if slices.Contains(rl.Whitelist, utils.IPAddress(r)) {
+		return rl.Whitelisted
+	}
+	return rl.Default
+}
+
+// Key is who the request is charged to, its API key or IP
+func (rl *RateLimiter) Key(r *http.Request) string {
+	if identity := IdentityFromContext(r.Context()); identity != nil {
+		return "key:" + identity.Name
+	}
+	return utils.IPAddress(r)
+}
+
+// Take charges cost to key's bucket in the tier
+// If redis is down it lets everything through, returns nil in that case
+func (rl *RateLimiter) Take(tier RateLimitTier, key string, cost int64) *database.TokenBucketResult {
+	res, err := tier.Take(fmt.Sprintf("%s:ratelimit:%s:%s", rl.Prefix, tier.Name, key), cost)
+	if err != nil {
+		klog.Errorf("Error checking rate limit for %s %v", key, err)
+		return nil
+	}
+	return res
+}
+
+// Middleware rate limits every request by API key or IP, /api requests are charged by what they ask for
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(rl.Unlimited, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cost := int64(1)
+		if r.Method == http.MethodPost && r.Body != nil {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+			r.Body.Close()
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				ErrRequestTooLarge(w, r)
+				return
+			}
+			if err != nil {
+				ErrInvalidRequest(w, r)
+				return
+			}
+			// The handler still needs it
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			var baseRequest map[string]interface{}
+			if err := json.Unmarshal(body, &baseRequest); err == nil {
+				if _, ok := baseRequest["action"]; ok {
+					cost = RequestCost(baseRequest)
+				}
+			}
+		}
+
+		tier := rl.Tier(r)
+		res := rl.Take(tier, rl.Key(r), cost)
+		if res == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		setRateLimitHeaders(w, tier, res)
+		if !res.Allowed {
+			metrics.RateLimitRejections.WithLabelValues(tier.Name, "http").Inc()
+			ErrRateLimited(w, r, res.RetryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setRateLimitHeaders sets the RateLimit header fields from the IETF draft
+func setRateLimitHeaders(w http.ResponseWriter, tier RateLimitTier, res *database.TokenBucketResult) {
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(tier.Capacity, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset), 10))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", tier.Capacity, ceilSeconds(tier.Period)))
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// allowRequest charges a websocket message to the client's tier, sending it an error if it's over the limit
+func (c *Client) allowRequest(baseRequest map[string]interface{}) bool {
+	if c.Hub.RateLimiter == nil {
+		return true
+	}
+	res := c.Hub.RateLimiter.Take(c.RateLimitTier, c.rateLimitKey, RequestCost(baseRequest))
+	if res == nil || res.Allowed {
+		return true
+	}
+	metrics.RateLimitRejections.WithLabelValues(c.RateLimitTier.Name, "websocket").Inc()
+	errJson, _ := json.Marshal(rateLimitedError(res.RetryAfter))
+	c.Hub.BroadcastToClient(c, errJson)
+	return false
+}
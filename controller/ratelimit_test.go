@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestCost(t *testing.T) {
+	assert.Equal(t, int64(1), RequestCost(map[string]interface{}{"action": "version"}))
+	assert.Equal(t, int64(5), RequestCost(map[string]interface{}{"action": "process"}))
+	// Charged for how much it asks for
+	assert.Equal(t, int64(4), RequestCost(map[string]interface{}{"action": "account_history", "count": "150"}))
+	assert.Equal(t, int64(12), RequestCost(map[string]interface{}{"action": "account_history", "count": -1}))
+	hashes := make([]interface{}, 1000)
+	assert.Equal(t, int64(12), RequestCost(map[string]interface{}{"action": "blocks_info", "hashes": hashes}))
+	assert.Equal(t, int64(3), RequestCost(map[string]interface{}{"action": "accounts_balances", "accounts": []interface{}{"a", "b"}}))
+}
+
+func TestParseRateLimitTier(t *testing.T) {
+	tier, err := ParseRateLimitTier("default", "100/1m")
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimitTier{Name: "default", TokenBucket: database.TokenBucket{Capacity: 100, Period: time.Minute}}, tier)
+	for _, invalid := range []string{"100", "0/1m", "100/0s", "abc/1m", "100/abc"} {
+		_, err = ParseRateLimitTier("default", invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	limiter := &RateLimiter{
+		Prefix:      "ratelimit_test",
+		Default:     RateLimitTier{Name: "default", TokenBucket: database.TokenBucket{Capacity: 6, Period: time.Minute}},
+		Whitelisted: RateLimitTier{Name: "whitelisted", TokenBucket: database.TokenBucket{Capacity: 100, Period: time.Minute}},
+		Admin:       RateLimitTier{Name: "admin", TokenBucket: database.TokenBucket{Capacity: 1000, Period: time.Minute}},
+		Whitelist:   []string{"10.0.0.2"},
+	}
+	var received []string
+	authenticator := &Authenticator{AdminAPIKey: "secret"}
+	handler := authenticator.Middleware(limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := new(bytes.Buffer)
+		body.ReadFrom(r.Body)
+		received = append(received, body.String())
+	})))
+	request := func(ip string, authorization string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api", bytes.NewReader([]byte(body)))
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("Authorization", authorization)
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("10.0.0.1", "", `{"action":"process"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "6", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "50", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "6;w=60", w.Header().Get("RateLimit-Policy"))
+	// The handler still gets the body
+	assert.Equal(t, []string{`{"action":"process"}`}, received)
+
+	// Not enough left for another
+	rejections := metrics.RateLimitRejections.WithLabelValues("default", "http")
+	before := testutil.ToFloat64(rejections)
+	w = request("10.0.0.1", "", `{"action":"process"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(rejections))
+	assert.Equal(t, "40", w.Header().Get("Retry-After"))
+	assert.Equal(t, "{\"error\":\"Too many requests\",\"code\":\"rate_limited\",\"retry_after\":40}\n", w.Body.String())
+	assert.Len(t, received, 1)
+	// But enough for something cheaper
+	w = request("10.0.0.1", "", `{"action":"version"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	// Whitelisted IPs and the admin key are in higher tiers, not unlimited
+	w = request("10.0.0.2", "", `{"action":"process"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "100", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "95", w.Header().Get("RateLimit-Remaining"))
+	w = request("10.0.0.1", "secret", `{"action":"process"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1000", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "995", w.Header().Get("RateLimit-Remaining"))
+	// Charged to the key, not the IP
+	w = request("10.0.0.1", "", `{"action":"version"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// The node's callbacks are never throttled, however many there are
+	limiter.Unlimited = []string{"/callback"}
+	for i := 0; i < 100; i++ {
+		w = httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/callback", bytes.NewReader([]byte(`{"hash":"ABC"}`)))
+		req.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, "", w.Header().Get("RateLimit-Limit"))
+
+	// Bodies over the limit aren't read, let alone charged
+	w = request("10.0.0.3", "", `{"action":"accounts_balances","accounts":["`+strings.Repeat("a", maxRequestBodySize)+`"]}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "", w.Header().Get("RateLimit-Limit"))
+}
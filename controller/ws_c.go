@@ -2,6 +2,7 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,8 +10,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/appditto/natrium-wallet-server/config"
 	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/metrics"
 	"github.com/appditto/natrium-wallet-server/models"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
 	"github.com/appditto/natrium-wallet-server/net"
 	"github.com/appditto/natrium-wallet-server/repository"
 	"github.com/appditto/natrium-wallet-server/utils"
@@ -32,7 +36,13 @@ const (
 	PingPeriod = (PongWait * 9) / 10
 
 	// Maximum message size allowed from peer.
-	MaxMessageSize = 512
+	MaxMessageSize = 8192
+
+	// Maximum accounts in a single accounts_subscribe
+	MaxSubscribeAccounts = 50
+
+	// Reason in the close frame sent when the server shuts down, clients should reconnect and restore their session
+	ShutdownCloseReason = "Server restarting, reconnect"
 )
 
 // Client is a middleman between the websocket connection and the hub.
@@ -45,13 +55,27 @@ type Client struct {
 	// Buffered channel of outbound messages.
 	Send chan []byte
 
+	// Latest price update not sent yet, price updates are coalesced rather than queued
+	pendingPrice []byte
+	priceReady   chan struct{}
+
 	// IP Address
 	IPAddress string
 	ID        uuid.UUID
 	Accounts  []string // Subscribed accounts
 	Currency  string
 
-	mutex sync.Mutex
+	// Decided by the upgrade request
+	RateLimitTier RateLimitTier
+	rateLimitKey  string
+
+	// Guards delivery, ID and closing Send
+	mutex         sync.Mutex
+	closed        bool
+	disconnecting bool
+	// Sent as the close frame once the queue is drained, empty by default
+	closeMessage []byte
+	counters     clientQueueCounters
 }
 
 var Upgrader = websocket.Upgrader{}
@@ -71,11 +95,32 @@ type Hub struct {
 	// Unregister requests from clients.
 	Unregister chan *Client
 
+	// Index of subscribed account -> clients, so confirmations can be dispatched
+	// without walking every connected client
+	accounts map[string]map[*Client]bool
+
+	// Guards Clients and accounts, which are read outside of Run
+	mutex sync.RWMutex
+
 	BananoMode  bool
 	PricePrefix string
 
 	RPCClient    *net.RPCClient
 	FcmTokenRepo *repository.FcmTokenRepo
+
+	// How long sessions are kept, and how many missed confirmations each one buffers
+	SessionTTL        time.Duration
+	SessionBufferSize int
+
+	// What to do when a client can't keep up
+	SlowClientPolicy SlowClientPolicy
+	CoalescePrices   bool
+
+	// Shared with the HTTP API, nil if disabled
+	RateLimiter *RateLimiter
+
+	// Set by Shutdown, clients that connect afterwards are closed straight away
+	shuttingDown bool
 }
 
 func NewHub(bananomode bool, rpcClient *net.RPCClient, fcmTokenRepo *repository.FcmTokenRepo) *Hub {
@@ -90,40 +135,162 @@ func NewHub(bananomode bool, rpcClient *net.RPCClient, fcmTokenRepo *repository.
 		Register:     make(chan *Client),
 		Unregister:   make(chan *Client),
 		Clients:      make(map[*Client]bool),
+		accounts:     make(map[string]map[*Client]bool),
 		BananoMode:   bananomode,
 		PricePrefix:  pricePrefix,
 		RPCClient:    rpcClient,
 		FcmTokenRepo: fcmTokenRepo,
+
+		SessionTTL:        DefaultSessionTTL,
+		SessionBufferSize: DefaultSessionBufferSize,
+
+		SlowClientPolicy: SlowClientDropOldest,
+		CoalescePrices:   true,
 	}
 }
 
+// ApplyConfig sets how the hub keeps sessions and treats slow clients
+func (h *Hub) ApplyConfig(cfg config.WebsocketConfig) error {
+	policy, err := ParseSlowClientPolicy(cfg.SlowClientPolicy)
+	if err != nil {
+		return err
+	}
+	h.SessionTTL = cfg.SessionTTL.Duration()
+	h.SessionBufferSize = cfg.SessionBuffer
+	h.SlowClientPolicy = policy
+	h.CoalescePrices = cfg.CoalescePrices
+	return nil
+}
+
 func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.Register:
+			h.mutex.Lock()
 			h.Clients[client] = true
+			h.updateMetrics()
+			shuttingDown := h.shuttingDown
+			h.mutex.Unlock()
+			if shuttingDown {
+				client.closeWithMessage(websocket.CloseServiceRestart, ShutdownCloseReason)
+			}
 		case client := <-h.Unregister:
+			h.mutex.Lock()
 			if _, ok := h.Clients[client]; ok {
-				delete(h.Clients, client)
-				close(client.Send)
+				h.removeClient(client)
 			}
+			h.updateMetrics()
+			h.mutex.Unlock()
 		case message := <-h.Broadcast:
-			for client := range h.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.Clients, client)
-				}
+			for _, client := range h.ConnectedClients() {
+				client.deliver(message)
 			}
 		}
 	}
 }
 
-func (h *Hub) BroadcastToClient(client *Client, message []byte) {
-	client.mutex.Lock()
-	defer client.mutex.Unlock()
-	client.Send <- message
+// updateMetrics sets the client and subscription gauges, caller must hold the lock
+func (h *Hub) updateMetrics() {
+	metrics.WebsocketClients.Set(float64(len(h.Clients)))
+	metrics.WebsocketSubscribedAccounts.Set(float64(len(h.accounts)))
+}
+
+// removeClient drops a client and all of its subscriptions, caller must hold the lock
+func (h *Hub) removeClient(client *Client) {
+	delete(h.Clients, client)
+	for _, account := range client.Accounts {
+		h.removeSubscription(client, account)
+	}
+	client.close()
+}
+
+// removeSubscription drops a client from an account's index, caller must hold the lock
+func (h *Hub) removeSubscription(client *Client, account string) {
+	if subscribers, ok := h.accounts[account]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(h.accounts, account)
+		}
+	}
+}
+
+// SubscribeAccount registers a client as interested in confirmations for an account
+func (h *Hub) SubscribeAccount(client *Client, account string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.Clients[client]; !ok {
+		// Client already unregistered
+		return
+	}
+	if !slices.Contains(client.Accounts, account) {
+		client.Accounts = append(client.Accounts, account)
+	}
+	if _, ok := h.accounts[account]; !ok {
+		h.accounts[account] = make(map[*Client]bool)
+	}
+	h.accounts[account][client] = true
+	h.updateMetrics()
+}
+
+// UnsubscribeAccount removes an account from a client's subscriptions
+func (h *Hub) UnsubscribeAccount(client *Client, account string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if idx := slices.Index(client.Accounts, account); idx >= 0 {
+		client.Accounts = slices.Delete(client.Accounts, idx, idx+1)
+	}
+	h.removeSubscription(client, account)
+	h.updateMetrics()
+}
+
+// ClientsForAccount returns every client subscribed to the given account
+func (h *Hub) ClientsForAccount(account string) []*Client {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	subscribers := h.accounts[account]
+	clients := make([]*Client, 0, len(subscribers))
+	for client := range subscribers {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// ConnectedClients returns a snapshot of all registered clients
+func (h *Hub) ConnectedClients() []*Client {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	clients := make([]*Client, 0, len(h.Clients))
+	for client := range h.Clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Shutdown closes every client with a close frame telling it to reconnect, so it can restore its session on another replica
+// It returns once every client has disconnected or the context is done
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mutex.Lock()
+	h.shuttingDown = true
+	h.mutex.Unlock()
+	for _, client := range h.ConnectedClients() {
+		client.closeWithMessage(websocket.CloseServiceRestart, ShutdownCloseReason)
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		h.mutex.RLock()
+		remaining := len(h.Clients)
+		h.mutex.RUnlock()
+		if remaining == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d websocket clients still connected", remaining)
+		case <-ticker.C:
+		}
+	}
 }
 
 var (
@@ -138,12 +305,18 @@ var (
 // reads from this goroutine.
 func (c *Client) readPump() {
 	defer func() {
+		// A newer connection with the same session may have marked it online already, it's fixed on its next pong
+		c.Hub.markSessionOffline(c)
 		c.Hub.Unregister <- c
 		c.Conn.Close()
 	}()
 	c.Conn.SetReadLimit(MaxMessageSize)
 	c.Conn.SetReadDeadline(time.Now().Add(PongWait))
-	c.Conn.SetPongHandler(func(string) error { c.Conn.SetReadDeadline(time.Now().Add(PongWait)); return nil })
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(PongWait))
+		c.Hub.markSessionOnline(c)
+		return nil
+	})
 	for {
 		_, msg, err := c.Conn.ReadMessage()
 		if err != nil {
@@ -170,6 +343,10 @@ func (c *Client) readPump() {
 			continue
 		}
 
+		if !c.allowRequest(baseRequest) {
+			continue
+		}
+
 		if baseRequest["action"] == "account_subscribe" {
 			var subscribeRequest models.AccountSubscribe
 			if err = mapstructure.Decode(baseRequest, &subscribeRequest); err != nil {
@@ -178,39 +355,26 @@ func (c *Client) readPump() {
 				c.Hub.BroadcastToClient(c, errJson)
 				continue
 			}
+			// Only the uuid, restore the session
+			if subscribeRequest.Account == "" && subscribeRequest.Uuid != nil {
+				c.handleSessionRestore(*subscribeRequest.Uuid)
+				continue
+			}
 			// Check if account is valid
 			if !utils.ValidateAddress(subscribeRequest.Account, c.Hub.BananoMode) {
 				klog.Errorf("Invalid account %s , %v", subscribeRequest.Account, c.Hub.BananoMode)
 				c.Hub.BroadcastToClient(c, []byte("{\"error\":\"Invalid account\"}"))
 				continue
 			}
+			platform, ok := pushPlatform(subscribeRequest.Platform)
+			if !ok {
+				c.Hub.BroadcastToClient(c, []byte("{\"error\":\"Invalid platform\"}"))
+				continue
+			}
 
 			// Handle subscribe
-			// If UUID is present and valid, use that, otherwise generate a new one
-			if subscribeRequest.Uuid != nil {
-				id, err := uuid.Parse(*subscribeRequest.Uuid)
-				if err != nil {
-					c.ID = uuid.New()
-				} else {
-					c.ID = id
-				}
-			} else {
-				// Create a UUID for this subscription
-				c.ID = uuid.New()
-			}
-			// Get curency
-			if subscribeRequest.Currency != nil && slices.Contains(net.CurrencyList, strings.ToUpper(*subscribeRequest.Currency)) {
-				c.Currency = strings.ToUpper(*subscribeRequest.Currency)
-			} else {
-				c.Currency = "USD"
-			}
-			// Force nano_ address
-			if !c.Hub.BananoMode {
-				// Ensure account has nano_ address
-				if strings.HasPrefix(subscribeRequest.Account, "xrb_") {
-					subscribeRequest.Account = fmt.Sprintf("nano_%s", strings.TrimPrefix(subscribeRequest.Account, "xrb_"))
-				}
-			}
+			c.setSession(subscribeRequest.Uuid, subscribeRequest.Currency)
+			subscribeRequest.Account = c.Hub.normalizeAccount(subscribeRequest.Account)
 
 			klog.Infof("Received account_subscribe: %s, %s", subscribeRequest.Account, c.IPAddress)
 
@@ -223,32 +387,10 @@ func (c *Client) readPump() {
 			}
 
 			// Add account to tracker
-			if !slices.Contains(c.Accounts, subscribeRequest.Account) {
-				c.Accounts = append(c.Accounts, subscribeRequest.Account)
-			}
+			c.Hub.SubscribeAccount(c, subscribeRequest.Account)
 
 			// Get price info to include in response
-			priceCur, err := database.GetRedisDB().Hget("prices", fmt.Sprintf("coingecko:%s-%s", c.Hub.PricePrefix, strings.ToLower(c.Currency)))
-			if err != nil {
-				klog.Errorf("Error getting price %s %v", fmt.Sprintf("coingecko:%s-%s", c.Hub.PricePrefix, strings.ToLower(c.Currency)), err)
-			}
-			priceBtc, err := database.GetRedisDB().Hget("prices", fmt.Sprintf("coingecko:%s-btc", c.Hub.PricePrefix))
-			if err != nil {
-				klog.Errorf("Error getting BTC price %v", err)
-			}
-			accountInfo["uuid"] = c.ID
-			accountInfo["currency"] = c.Currency
-			accountInfo["price"] = priceCur
-			accountInfo["btc"] = priceBtc
-			if c.Hub.BananoMode {
-				// Also tag nano price
-				// response['nano'] = float(await r.app['rdata'].hget("prices", f"{self.price_prefix}-nano"))
-				priceNano, err := database.GetRedisDB().Hget("prices", fmt.Sprintf("coingecko:%s-nano", c.Hub.PricePrefix))
-				if err != nil {
-					klog.Errorf("Error getting nano price %v", err)
-				}
-				accountInfo["nano"] = priceNano
-			}
+			c.tagSession(accountInfo)
 
 			// Tag pending count
 			pendingCount, err := c.Hub.RPCClient.GetReceivableCount(subscribeRequest.Account, c.Hub.BananoMode)
@@ -264,18 +406,17 @@ func (c *Client) readPump() {
 				c.Hub.BroadcastToClient(c, []byte("{\"error\":\"subscribe error\"}"))
 				continue
 			}
+			c.saveSession(notificationUpdate(subscribeRequest.FcmToken, subscribeRequest.NotificationEnabled))
 			c.Hub.BroadcastToClient(c, response)
 
-			// The user may have a different UUID every time, 1 token, and multiple accounts
-			// We store account/token in postgres since that's what we care about
-			// Or remove the token, if notifications disabled
-			if !subscribeRequest.NotificationEnabled {
-				// Set token in db
-				c.Hub.FcmTokenRepo.DeleteFcmToken(subscribeRequest.FcmToken)
-			} else {
-				// Add/update token if not exists
-				c.Hub.FcmTokenRepo.AddOrUpdateToken(subscribeRequest.FcmToken, subscribeRequest.Account)
-			}
+			c.updateFcmToken(subscribeRequest.FcmToken, platform, []string{subscribeRequest.Account}, subscribeRequest.NotificationEnabled)
+			c.Hub.replaySession(c)
+		} else if baseRequest["action"] == "accounts_subscribe" {
+			c.handleAccountsSubscribe(baseRequest)
+		} else if baseRequest["action"] == "account_unsubscribe" {
+			c.handleAccountUnsubscribe(baseRequest)
+		} else if baseRequest["action"] == "notification_preferences" {
+			c.handleNotificationPreferences(baseRequest)
 		} else if baseRequest["action"] == "fcm_update" {
 			// Update FCM/notification preferences
 			var fcmUpdateRequest models.FcmUpdate
@@ -290,13 +431,21 @@ func (c *Client) readPump() {
 				c.Hub.BroadcastToClient(c, []byte("{\"error\":\"Invalid account\"}"))
 				continue
 			}
+			platform, ok := pushPlatform(fcmUpdateRequest.Platform)
+			if !ok {
+				c.Hub.BroadcastToClient(c, []byte("{\"error\":\"Invalid platform\"}"))
+				continue
+			}
 			// Do the updoot
 			if !fcmUpdateRequest.Enabled {
 				// Set token in db
 				c.Hub.FcmTokenRepo.DeleteFcmToken(fcmUpdateRequest.FcmToken)
 			} else {
 				// Add token to db if not exists
-				c.Hub.FcmTokenRepo.AddOrUpdateToken(fcmUpdateRequest.FcmToken, fcmUpdateRequest.Account)
+				c.Hub.FcmTokenRepo.AddOrUpdateToken(fcmUpdateRequest.FcmToken, fcmUpdateRequest.Account, platform)
+			}
+			if err := c.Hub.updateSession(c, notificationUpdate(fcmUpdateRequest.FcmToken, fcmUpdateRequest.Enabled)); err != nil {
+				klog.Errorf("Error saving session %v", err)
 			}
 		} else {
 			klog.Errorf("Unknown websocket request %s", msg)
@@ -307,6 +456,244 @@ func (c *Client) readPump() {
 	}
 }
 
+// setID changes the client's uuid, it's read by delivery from other goroutines
+func (c *Client) setID(id uuid.UUID) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ID = id
+}
+
+// setSession sets the client's uuid and currency from a subscribe request
+func (c *Client) setSession(id *string, currency *string) {
+	// If UUID is present and valid, use that, otherwise generate a new one
+	sessionID := uuid.New()
+	if id != nil {
+		if parsed, err := uuid.Parse(*id); err == nil {
+			sessionID = parsed
+		}
+	}
+	c.setID(sessionID)
+	// Get curency
+	if currency != nil && slices.Contains(net.CurrencyList, strings.ToUpper(*currency)) {
+		c.Currency = strings.ToUpper(*currency)
+	} else {
+		c.Currency = "USD"
+	}
+}
+
+// tagSession adds the uuid, currency and prices to a subscribe response
+func (c *Client) tagSession(response map[string]interface{}) {
+	priceCur, err := database.GetRedisDB().Hget("prices", fmt.Sprintf("coingecko:%s-%s", c.Hub.PricePrefix, strings.ToLower(c.Currency)))
+	if err != nil {
+		klog.Errorf("Error getting price %s %v", fmt.Sprintf("coingecko:%s-%s", c.Hub.PricePrefix, strings.ToLower(c.Currency)), err)
+	}
+	priceBtc, err := database.GetRedisDB().Hget("prices", fmt.Sprintf("coingecko:%s-btc", c.Hub.PricePrefix))
+	if err != nil {
+		klog.Errorf("Error getting BTC price %v", err)
+	}
+	response["uuid"] = c.ID
+	response["currency"] = c.Currency
+	response["price"] = priceCur
+	response["btc"] = priceBtc
+	if c.Hub.BananoMode {
+		// Also tag nano price
+		priceNano, err := database.GetRedisDB().Hget("prices", fmt.Sprintf("coingecko:%s-nano", c.Hub.PricePrefix))
+		if err != nil {
+			klog.Errorf("Error getting nano price %v", err)
+		}
+		response["nano"] = priceNano
+	}
+}
+
+func (h *Hub) normalizeAccount(account string) string {
+	return normalizeAccount(account, h.BananoMode)
+}
+
+// normalizeAccount forces nano_ addresses over xrb_ ones
+func normalizeAccount(account string, bananoMode bool) string {
+	if !bananoMode && strings.HasPrefix(account, "xrb_") {
+		return fmt.Sprintf("nano_%s", strings.TrimPrefix(account, "xrb_"))
+	}
+	return account
+}
+
+// updateFcmToken associates the token with the accounts, or removes it if notifications are disabled
+// The user may have a different UUID every time, 1 token, and multiple accounts
+// We store account/token in postgres since that's what we care about
+func (c *Client) updateFcmToken(token string, platform string, accounts []string, enabled bool) {
+	if token == "" {
+		return
+	}
+	if !enabled {
+		if err := c.Hub.FcmTokenRepo.DeleteFcmToken(token); err != nil {
+			klog.Errorf("Error deleting fcm token %v", err)
+		}
+		return
+	}
+	for _, account := range accounts {
+		// Add/update token if not exists
+		if err := c.Hub.FcmTokenRepo.AddOrUpdateToken(token, account, platform); err != nil {
+			klog.Errorf("Error adding fcm token %v", err)
+		}
+	}
+}
+
+// pushPlatform is where a token's notifications go, FCM unless the client says otherwise
+func pushPlatform(platform string) (string, bool) {
+	if platform == "" {
+		return dbmodels.PlatformFCM, true
+	}
+	platform = strings.ToLower(platform)
+	return platform, slices.Contains(dbmodels.PushPlatforms, platform)
+}
+
+// handleAccountsSubscribe subscribes to several accounts at once, responding with the info of each
+func (c *Client) handleAccountsSubscribe(baseRequest map[string]interface{}) {
+	var subscribeRequest models.AccountsSubscribe
+	if err := mapstructure.Decode(baseRequest, &subscribeRequest); err != nil {
+		klog.Errorf("Error unmarshalling websocket accounts_subscribe request %s", err)
+		errJson, _ := json.Marshal(InvalidRequestError)
+		c.Hub.BroadcastToClient(c, errJson)
+		return
+	}
+	if len(subscribeRequest.Accounts) == 0 || len(subscribeRequest.Accounts) > MaxSubscribeAccounts {
+		c.Hub.BroadcastToClient(c, []byte(fmt.Sprintf("{\"error\":\"Between 1 and %d accounts required\"}", MaxSubscribeAccounts)))
+		return
+	}
+	accounts := make([]string, 0, len(subscribeRequest.Accounts))
+	for _, account := range subscribeRequest.Accounts {
+		account = c.Hub.normalizeAccount(account)
+		if !utils.ValidateAddress(account, c.Hub.BananoMode) {
+			klog.Errorf("Invalid account %s , %v", account, c.Hub.BananoMode)
+			c.Hub.BroadcastToClient(c, []byte("{\"error\":\"Invalid account\"}"))
+			return
+		}
+		if !slices.Contains(accounts, account) {
+			accounts = append(accounts, account)
+		}
+	}
+
+	platform, ok := pushPlatform(subscribeRequest.Platform)
+	if !ok {
+		c.Hub.BroadcastToClient(c, []byte("{\"error\":\"Invalid platform\"}"))
+		return
+	}
+
+	c.setSession(subscribeRequest.Uuid, subscribeRequest.Currency)
+
+	klog.Infof("Received accounts_subscribe: %d accounts, %s", len(accounts), c.IPAddress)
+
+	subscribed, ok := c.subscribeAccounts(accounts, notificationUpdate(subscribeRequest.FcmToken, subscribeRequest.NotificationEnabled))
+	if !ok {
+		return
+	}
+	c.updateFcmToken(subscribeRequest.FcmToken, platform, subscribed, subscribeRequest.NotificationEnabled)
+	c.Hub.replaySession(c)
+}
+
+// subscribeAccounts subscribes to every account it can get info for, saves the session, and sends the client the info of each
+// Returns the accounts that were subscribed
+func (c *Client) subscribeAccounts(accounts []string, sessionUpdate func(session *Session)) ([]string, bool) {
+	accountInfos := c.Hub.RPCClient.MakeAccountsInfoRequest(accounts)
+	if len(accountInfos) == 0 {
+		c.Hub.BroadcastToClient(c, []byte("{\"error\":\"subscribe error\"}"))
+		return nil, false
+	}
+	pendingCounts, err := c.Hub.RPCClient.GetReceivableCounts(accounts, c.Hub.BananoMode)
+	if err != nil {
+		klog.Errorf("Error getting pending counts %v", err)
+	}
+
+	// Accounts we couldn't get info for aren't subscribed, the client can retry them
+	subscribed := make([]string, 0, len(accountInfos))
+	results := make(map[string]interface{}, len(accounts))
+	for _, account := range accounts {
+		accountInfo, ok := accountInfos[account]
+		if !ok {
+			results[account] = map[string]interface{}{"error": "subscribe error"}
+			continue
+		}
+		accountInfo["pending_count"] = pendingCounts[account]
+		results[account] = accountInfo
+		c.Hub.SubscribeAccount(c, account)
+		subscribed = append(subscribed, account)
+	}
+
+	response := map[string]interface{}{"accounts": results}
+	c.tagSession(response)
+	serialized, err := json.Marshal(response)
+	if err != nil {
+		klog.Errorf("Error marshalling accounts info %v", err)
+		c.Hub.BroadcastToClient(c, []byte("{\"error\":\"subscribe error\"}"))
+		return nil, false
+	}
+	c.saveSession(sessionUpdate)
+	c.Hub.BroadcastToClient(c, serialized)
+	return subscribed, true
+}
+
+// handleSessionRestore subscribes a reconnecting client to everything its session had
+func (c *Client) handleSessionRestore(idStr string) {
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		errJson, _ := json.Marshal(InvalidRequestError)
+		c.Hub.BroadcastToClient(c, errJson)
+		return
+	}
+	session, err := c.Hub.LoadSession(id)
+	if err != nil || session == nil || len(session.Accounts) == 0 {
+		c.Hub.BroadcastToClient(c, []byte("{\"error\":\"session not found\"}"))
+		return
+	}
+	c.setID(id)
+	c.Currency = session.Currency
+
+	klog.Infof("Restoring session %s: %d accounts, %s", id, len(session.Accounts), c.IPAddress)
+
+	if _, ok := c.subscribeAccounts(session.Accounts, nil); !ok {
+		return
+	}
+	c.Hub.replaySession(c)
+}
+
+// notificationUpdate records the token and whether notifications are on in the session
+func notificationUpdate(token string, enabled bool) func(session *Session) {
+	return func(session *Session) {
+		if token != "" {
+			session.FcmToken = token
+		}
+		session.NotificationEnabled = enabled
+	}
+}
+
+// handleAccountUnsubscribe stops confirmations for an account, and its push notifications for the token
+func (c *Client) handleAccountUnsubscribe(baseRequest map[string]interface{}) {
+	var unsubscribeRequest models.AccountUnsubscribe
+	if err := mapstructure.Decode(baseRequest, &unsubscribeRequest); err != nil {
+		klog.Errorf("Error unmarshalling websocket account_unsubscribe request %s", err)
+		errJson, _ := json.Marshal(InvalidRequestError)
+		c.Hub.BroadcastToClient(c, errJson)
+		return
+	}
+	account := c.Hub.normalizeAccount(unsubscribeRequest.Account)
+	if !utils.ValidateAddress(account, c.Hub.BananoMode) {
+		c.Hub.BroadcastToClient(c, []byte("{\"error\":\"Invalid account\"}"))
+		return
+	}
+
+	c.Hub.UnsubscribeAccount(c, account)
+	if err := c.Hub.updateSession(c, nil); err != nil {
+		klog.Errorf("Error saving session %v", err)
+	}
+
+	// The token stays registered for the client's other accounts
+	if unsubscribeRequest.FcmToken != "" {
+		if err := c.Hub.FcmTokenRepo.DeleteTokenForAccount(unsubscribeRequest.FcmToken, account); err != nil {
+			klog.Errorf("Error deleting fcm token for account %v", err)
+		}
+	}
+}
+
 // writePump pumps messages from the hub to the websocket connection.
 //
 // A goroutine running writePump is started for each connection. The
@@ -324,7 +711,7 @@ func (c *Client) writePump() {
 			c.Conn.SetWriteDeadline(time.Now().Add(WriteWait))
 			if !ok {
 				// The hub closed the channel.
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.Conn.WriteMessage(websocket.CloseMessage, c.closeFrame())
 				return
 			}
 
@@ -336,6 +723,15 @@ func (c *Client) writePump() {
 			if err := w.Close(); err != nil {
 				return
 			}
+		case <-c.priceReady:
+			message := c.takePrice()
+			if message == nil {
+				continue
+			}
+			c.Conn.SetWriteDeadline(time.Now().Add(WriteWait))
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(WriteWait))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -354,7 +750,11 @@ func WebsocketChl(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		klog.Error(err)
 		return
 	}
-	client := &Client{Hub: hub, Conn: conn, Send: make(chan []byte, 256), IPAddress: clientIP, Accounts: []string{}}
+	client := &Client{Hub: hub, Conn: conn, Send: make(chan []byte, SendQueueSize), priceReady: make(chan struct{}, 1), IPAddress: clientIP, Accounts: []string{}}
+	if hub.RateLimiter != nil {
+		client.RateLimitTier = hub.RateLimiter.Tier(r)
+		client.rateLimitKey = hub.RateLimiter.Key(r)
+	}
 	client.Hub.Register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/net"
+	"github.com/appditto/natrium-wallet-server/utils/mocks"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubAccountIndex(t *testing.T) {
+	hub := NewHub(false, nil, nil)
+	go hub.Run()
+
+	client1 := &Client{Hub: hub, Send: make(chan []byte, 256), Accounts: []string{}}
+	client2 := &Client{Hub: hub, Send: make(chan []byte, 256), Accounts: []string{}}
+	hub.Register <- client1
+	hub.Register <- client2
+	// Register is unbuffered, wait until the second registration is applied
+	assert.Eventually(t, func() bool { return len(hub.ConnectedClients()) == 2 }, time.Second, time.Millisecond)
+
+	hub.SubscribeAccount(client1, "account1")
+	hub.SubscribeAccount(client2, "account1")
+	hub.SubscribeAccount(client2, "account2")
+	// Duplicate subscriptions are ignored
+	hub.SubscribeAccount(client2, "account2")
+
+	assert.Len(t, hub.ClientsForAccount("account1"), 2)
+	assert.Equal(t, []*Client{client2}, hub.ClientsForAccount("account2"))
+	assert.Equal(t, []string{"account1"}, client1.Accounts)
+	assert.Equal(t, []string{"account1", "account2"}, client2.Accounts)
+	assert.Len(t, hub.ClientsForAccount("account3"), 0)
+
+	// Unsubscribe
+	hub.UnsubscribeAccount(client2, "account2")
+	assert.Len(t, hub.ClientsForAccount("account2"), 0)
+	assert.Equal(t, []string{"account1"}, client2.Accounts)
+
+	// Unregister removes the client from every account it was subscribed to
+	hub.Unregister <- client1
+	assert.Eventually(t, func() bool { return len(hub.ClientsForAccount("account1")) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, []*Client{client2}, hub.ClientsForAccount("account1"))
+}
+
+func TestAccountsSubscribe(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	account1 := "nano_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3"
+	account2 := "nano_1gyeqc6u5j3oaxbe5qy1hyz3q745a318kh8h9ocnpan7fuxnq85cxqboapu5"
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		var request map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&request)
+		body := "{\"error\": \"Account not found\"}"
+		if request["action"] == "accounts_receivable" {
+			body = fmt.Sprintf("{\"blocks\": {\"%s\": {\"A\": \"1\"}, \"%s\": \"\"}}", account1, account2)
+		} else if request["account"] == account1 {
+			body = "{\"frontier\": \"80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F\", \"balance\": \"1\"}"
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+
+	hub := NewHub(false, &net.RPCClient{Url: "http://localhost:8080"}, nil)
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChl(hub, w, r)
+	}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+
+	// Duplicates and xrb_ addresses are the same account
+	err = conn.WriteJSON(map[string]interface{}{
+		"action":   "accounts_subscribe",
+		"accounts": []string{account1, account2, strings.Replace(account1, "nano_", "xrb_", 1)},
+		"currency": "eur",
+	})
+	assert.Equal(t, nil, err)
+	var response map[string]interface{}
+	err = conn.ReadJSON(&response)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "EUR", response["currency"])
+	assert.NotEmpty(t, response["uuid"])
+	accounts := response["accounts"].(map[string]interface{})
+	assert.Len(t, accounts, 2)
+	assert.Equal(t, "1", accounts[account1].(map[string]interface{})["balance"])
+	assert.Equal(t, float64(1), accounts[account1].(map[string]interface{})["pending_count"])
+	assert.Equal(t, "Account not found", accounts[account2].(map[string]interface{})["error"])
+	assert.Equal(t, float64(0), accounts[account2].(map[string]interface{})["pending_count"])
+	assert.Len(t, hub.ClientsForAccount(account1), 1)
+	assert.Len(t, hub.ClientsForAccount(account2), 1)
+
+	// Unsubscribe one of them
+	err = conn.WriteJSON(map[string]interface{}{"action": "account_unsubscribe", "account": account2})
+	assert.Equal(t, nil, err)
+	assert.Eventually(t, func() bool { return len(hub.ClientsForAccount(account2)) == 0 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, hub.ClientsForAccount(account1), 1)
+
+	// Invalid accounts are rejected
+	err = conn.WriteJSON(map[string]interface{}{"action": "accounts_subscribe", "accounts": []string{account1, "nano_invalid"}})
+	assert.Equal(t, nil, err)
+	response = nil
+	err = conn.ReadJSON(&response)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Invalid account", response["error"])
+	err = conn.WriteJSON(map[string]interface{}{"action": "accounts_subscribe", "accounts": []string{}})
+	assert.Equal(t, nil, err)
+	response = nil
+	err = conn.ReadJSON(&response)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Between 1 and 50 accounts required", response["error"])
+	// Only platforms we can send to
+	err = conn.WriteJSON(map[string]interface{}{"action": "accounts_subscribe", "accounts": []string{account1}, "fcm_token_v2": "token1", "platform": "hms"})
+	assert.Equal(t, nil, err)
+	response = nil
+	err = conn.ReadJSON(&response)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Invalid platform", response["error"])
+}
+
+func TestHubShutdown(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+
+	hub := NewHub(false, nil, nil)
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChl(hub, w, r)
+	}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+	assert.Eventually(t, func() bool { return len(hub.ConnectedClients()) == 1 }, time.Second, time.Millisecond)
+	hub.BroadcastToClient(hub.ConnectedClients()[0], []byte("{\"queued\":true}"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Equal(t, nil, hub.Shutdown(ctx))
+	assert.Len(t, hub.ConnectedClients(), 0)
+
+	// What was already queued is sent before the close frame
+	_, message, err := conn.ReadMessage()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "{\"queued\":true}", string(message))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseServiceRestart))
+	assert.Equal(t, ShutdownCloseReason, err.(*websocket.CloseError).Text)
+
+	// Anyone connecting after is told to go elsewhere
+	conn2, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.Equal(t, nil, err)
+	defer conn2.Close()
+	_, _, err = conn2.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseServiceRestart))
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"k8s.io/klog/v2"
+)
+
+// SlowClientPolicy decides what happens to a message for a client whose send queue is full
+type SlowClientPolicy string
+
+const (
+	// Make room by dropping the oldest queued message
+	SlowClientDropOldest SlowClientPolicy = "drop_oldest"
+	// Close the connection, the client can reconnect and restore its session
+	SlowClientDisconnect SlowClientPolicy = "disconnect"
+)
+
+func ParseSlowClientPolicy(policy string) (SlowClientPolicy, error) {
+	switch SlowClientPolicy(strings.ToLower(policy)) {
+	case SlowClientDropOldest:
+		return SlowClientDropOldest, nil
+	case SlowClientDisconnect:
+		return SlowClientDisconnect, nil
+	}
+	return "", fmt.Errorf("Invalid slow client policy %s", policy)
+}
+
+// Size of each client's send queue
+const SendQueueSize = 256
+
+// ClientQueueStats describes a client's send queue
+type ClientQueueStats struct {
+	ID        uuid.UUID `json:"uuid"`
+	IPAddress string    `json:"ip_address"`
+	Queued    int       `json:"queued"`
+	Capacity  int       `json:"capacity"`
+	Enqueued  uint64    `json:"enqueued"`
+	Dropped   uint64    `json:"dropped"`
+	Coalesced uint64    `json:"coalesced"`
+}
+
+type clientQueueCounters struct {
+	enqueued  atomic.Uint64
+	dropped   atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+// deliver queues a message without blocking, applying the hub's policy if the queue is full
+// Returns false if the message was dropped
+func (c *Client) deliver(message []byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed || c.disconnecting {
+		c.counters.dropped.Add(1)
+		return false
+	}
+	select {
+	case c.Send <- message:
+		c.counters.enqueued.Add(1)
+		return true
+	default:
+	}
+
+	if c.Hub.SlowClientPolicy == SlowClientDisconnect {
+		klog.Infof("Disconnecting slow websocket client %s, %s", c.ID, c.IPAddress)
+		c.counters.dropped.Add(1)
+		c.disconnecting = true
+		// readPump unregisters the client once the connection is closed
+		if c.Conn != nil {
+			c.Conn.Close()
+		}
+		return false
+	}
+
+	// Only writePump receives from Send, and it never waits on the mutex, so there's room after this
+	select {
+	case <-c.Send:
+		c.counters.dropped.Add(1)
+	default:
+	}
+	select {
+	case c.Send <- message:
+		c.counters.enqueued.Add(1)
+		return true
+	default:
+		c.counters.dropped.Add(1)
+		return false
+	}
+}
+
+// deliverPrice replaces any price update the client hasn't been sent yet
+func (c *Client) deliverPrice(message []byte) bool {
+	c.mutex.Lock()
+	if c.closed || c.disconnecting {
+		c.counters.dropped.Add(1)
+		c.mutex.Unlock()
+		return false
+	}
+	if c.pendingPrice != nil {
+		c.counters.coalesced.Add(1)
+	}
+	c.pendingPrice = message
+	c.mutex.Unlock()
+
+	// Wake up writePump if it isn't already
+	select {
+	case c.priceReady <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// takePrice returns the latest price update not sent yet, if any
+func (c *Client) takePrice() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	message := c.pendingPrice
+	c.pendingPrice = nil
+	if message != nil {
+		c.counters.enqueued.Add(1)
+	}
+	return message
+}
+
+// close stops all delivery to the client, it's safe to call more than once
+func (c *Client) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.pendingPrice = nil
+	close(c.Send)
+}
+
+// closeWithMessage closes the client like close, writePump sends the close frame after whatever is still queued
+func (c *Client) closeWithMessage(code int, text string) {
+	c.mutex.Lock()
+	if !c.closed {
+		c.closeMessage = websocket.FormatCloseMessage(code, text)
+	}
+	c.mutex.Unlock()
+	c.close()
+}
+
+// closeFrame is the close frame payload writePump sends
+func (c *Client) closeFrame() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closeMessage == nil {
+		return []byte{}
+	}
+	return c.closeMessage
+}
+
+func (c *Client) QueueStats() ClientQueueStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return ClientQueueStats{
+		ID:        c.ID,
+		IPAddress: c.IPAddress,
+		Queued:    len(c.Send),
+		Capacity:  cap(c.Send),
+		Enqueued:  c.counters.enqueued.Load(),
+		Dropped:   c.counters.dropped.Load(),
+		Coalesced: c.counters.coalesced.Load(),
+	}
+}
+
+// QueueStats returns the send queue stats of every connected client
+func (h *Hub) QueueStats() []ClientQueueStats {
+	clients := h.ConnectedClients()
+	stats := make([]ClientQueueStats, len(clients))
+	for i, client := range clients {
+		stats[i] = client.QueueStats()
+	}
+	return stats
+}
+
+// BroadcastToClient queues a message for the client, it never blocks
+func (h *Hub) BroadcastToClient(client *Client, message []byte) bool {
+	return client.deliver(message)
+}
+
+// BroadcastPriceToClient queues a price update, coalescing it with any unsent one if enabled
+func (h *Hub) BroadcastPriceToClient(client *Client, message []byte) bool {
+	if !h.CoalescePrices {
+		return client.deliver(message)
+	}
+	return client.deliverPrice(message)
+}
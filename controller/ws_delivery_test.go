@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliverDropOldest(t *testing.T) {
+	hub := NewHub(false, nil, nil)
+	client := &Client{Hub: hub, Send: make(chan []byte, 2)}
+
+	assert.True(t, hub.BroadcastToClient(client, []byte("1")))
+	assert.True(t, hub.BroadcastToClient(client, []byte("2")))
+	// Full, the oldest makes room
+	assert.True(t, hub.BroadcastToClient(client, []byte("3")))
+	assert.Equal(t, []byte("2"), <-client.Send)
+	assert.Equal(t, []byte("3"), <-client.Send)
+
+	stats := client.QueueStats()
+	assert.Equal(t, uint64(3), stats.Enqueued)
+	assert.Equal(t, uint64(1), stats.Dropped)
+	assert.Equal(t, 0, stats.Queued)
+	assert.Equal(t, 2, stats.Capacity)
+}
+
+func TestDeliverDisconnect(t *testing.T) {
+	hub := NewHub(false, nil, nil)
+	hub.SlowClientPolicy = SlowClientDisconnect
+	client := &Client{Hub: hub, Send: make(chan []byte, 1)}
+
+	assert.True(t, hub.BroadcastToClient(client, []byte("1")))
+	assert.False(t, hub.BroadcastToClient(client, []byte("2")))
+	// Nothing else is queued while it's being disconnected
+	<-client.Send
+	assert.False(t, hub.BroadcastToClient(client, []byte("3")))
+	assert.False(t, hub.BroadcastPriceToClient(client, []byte("price")))
+	assert.Equal(t, uint64(3), client.QueueStats().Dropped)
+}
+
+func TestDeliverPriceCoalesce(t *testing.T) {
+	hub := NewHub(false, nil, nil)
+	client := &Client{Hub: hub, Send: make(chan []byte, 1), priceReady: make(chan struct{}, 1)}
+
+	assert.True(t, hub.BroadcastPriceToClient(client, []byte("1")))
+	assert.True(t, hub.BroadcastPriceToClient(client, []byte("2")))
+	assert.True(t, hub.BroadcastPriceToClient(client, []byte("3")))
+	// Price updates don't take up the queue
+	assert.Equal(t, 0, len(client.Send))
+	<-client.priceReady
+	assert.Equal(t, []byte("3"), client.takePrice())
+	assert.Nil(t, client.takePrice())
+	assert.Equal(t, uint64(2), client.QueueStats().Coalesced)
+
+	// Queued like anything else when coalescing is off
+	hub.CoalescePrices = false
+	assert.True(t, hub.BroadcastPriceToClient(client, []byte("4")))
+	assert.Equal(t, []byte("4"), <-client.Send)
+}
+
+func TestDeliverAfterClose(t *testing.T) {
+	hub := NewHub(false, nil, nil)
+	for i := 0; i < 100; i++ {
+		client := &Client{Hub: hub, Send: make(chan []byte, 1), priceReady: make(chan struct{}, 1)}
+		var wg sync.WaitGroup
+		wg.Add(3)
+		// Sending on a closed channel would panic
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				hub.BroadcastToClient(client, []byte("message"))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			hub.BroadcastPriceToClient(client, []byte("price"))
+		}()
+		go func() {
+			defer wg.Done()
+			client.close()
+			client.close()
+		}()
+		wg.Wait()
+		assert.False(t, hub.BroadcastToClient(client, []byte("message")))
+	}
+}
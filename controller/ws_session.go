@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// How long a session is kept after it was last used
+	DefaultSessionTTL = 24 * time.Hour
+
+	// How many missed confirmations are kept for a disconnected session
+	DefaultSessionBufferSize = 100
+
+	// A session counts as online until this long after the last pong from its client
+	sessionOnlineExpiry = PongWait * 2
+)
+
+// Session is what a client subscribed to, so it can be restored on reconnect from the uuid alone
+type Session struct {
+	Accounts            []string `json:"accounts"`
+	Currency            string   `json:"currency"`
+	FcmToken            string   `json:"fcm_token,omitempty"`
+	NotificationEnabled bool     `json:"notification_enabled"`
+}
+
+func (h *Hub) sessionKey(id uuid.UUID) string {
+	return fmt.Sprintf("%s:session:%s", h.PricePrefix, id)
+}
+
+// Sorted set of confirmations the session missed, scored by when they arrived
+func (h *Hub) sessionEventsKey(id uuid.UUID) string {
+	return fmt.Sprintf("%s:session:%s:events", h.PricePrefix, id)
+}
+
+// Exists while a client with the session is connected to any replica
+func (h *Hub) sessionOnlineKey(id uuid.UUID) string {
+	return fmt.Sprintf("%s:session:%s:online", h.PricePrefix, id)
+}
+
+// Set of session uuids subscribed to an account
+func (h *Hub) sessionAccountKey(account string) string {
+	return fmt.Sprintf("%s:session_account:%s", h.PricePrefix, account)
+}
+
+// LoadSession returns the stored session, or nil if there isn't one
+func (h *Hub) LoadSession(id uuid.UUID) (*Session, error) {
+	raw, err := database.GetRedisDB().Get(h.sessionKey(id))
+	if err != nil {
+		// redis.Nil, or redis being down, either way there's nothing to restore
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal([]byte(raw), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// updateSession stores the client's current accounts and currency, along with whatever update changes
+func (h *Hub) updateSession(client *Client, update func(session *Session)) error {
+	if client.ID == uuid.Nil {
+		return nil
+	}
+	session, err := h.LoadSession(client.ID)
+	if err != nil || session == nil {
+		session = &Session{}
+	}
+	previousAccounts := session.Accounts
+
+	h.mutex.RLock()
+	session.Accounts = slices.Clone(client.Accounts)
+	h.mutex.RUnlock()
+	session.Currency = client.Currency
+	if update != nil {
+		update(session)
+	}
+
+	serialized, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if err := database.GetRedisDB().Set(h.sessionKey(client.ID), string(serialized), h.SessionTTL); err != nil {
+		return err
+	}
+	for _, account := range previousAccounts {
+		if !slices.Contains(session.Accounts, account) {
+			if err := database.GetRedisDB().Srem(h.sessionAccountKey(account), client.ID.String()); err != nil {
+				return err
+			}
+		}
+	}
+	for _, account := range session.Accounts {
+		if err := database.GetRedisDB().Sadd(h.sessionAccountKey(account), client.ID.String()); err != nil {
+			return err
+		}
+		if err := database.GetRedisDB().Expire(h.sessionAccountKey(account), h.SessionTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markSessionOnline stops confirmations being buffered for the client's session
+func (h *Hub) markSessionOnline(client *Client) {
+	if client.ID == uuid.Nil {
+		return
+	}
+	if err := database.GetRedisDB().Set(h.sessionOnlineKey(client.ID), "1", sessionOnlineExpiry); err != nil {
+		klog.Errorf("Error marking session online %v", err)
+	}
+}
+
+// markSessionOffline starts buffering confirmations for the client's session
+func (h *Hub) markSessionOffline(client *Client) {
+	if client.ID == uuid.Nil {
+		return
+	}
+	if _, err := database.GetRedisDB().Del(h.sessionOnlineKey(client.ID)); err != nil {
+		klog.Errorf("Error marking session offline %v", err)
+	}
+}
+
+// BufferForOfflineSessions keeps a confirmation for every session subscribed to the account that isn't connected
+// Every replica can buffer the same message, the sorted set keeps a single copy
+func (h *Hub) BufferForOfflineSessions(account string, message []byte) {
+	ids, err := database.GetRedisDB().Smembers(h.sessionAccountKey(account))
+	if err != nil {
+		klog.Errorf("Error getting sessions for %s %v", account, err)
+		return
+	}
+	for _, idStr := range ids {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			continue
+		}
+		if _, err := database.GetRedisDB().Get(h.sessionOnlineKey(id)); err == nil {
+			// Connected, it got the message live
+			continue
+		}
+		if _, err := database.GetRedisDB().Get(h.sessionKey(id)); err != nil {
+			// Session expired
+			database.GetRedisDB().Srem(h.sessionAccountKey(account), idStr)
+			continue
+		}
+		eventsKey := h.sessionEventsKey(id)
+		if err := database.GetRedisDB().ZaddNX(eventsKey, float64(time.Now().UnixNano()), string(message)); err != nil {
+			klog.Errorf("Error buffering event for session %s %v", idStr, err)
+			continue
+		}
+		// Only keep the most recent
+		if err := database.GetRedisDB().ZremRangeByRank(eventsKey, 0, -int64(h.SessionBufferSize)-1); err != nil {
+			klog.Errorf("Error trimming events for session %s %v", idStr, err)
+		}
+		if err := database.GetRedisDB().Expire(eventsKey, h.SessionTTL); err != nil {
+			klog.Errorf("Error setting expiry for session events %s %v", idStr, err)
+		}
+	}
+}
+
+// replaySession sends the client everything its session missed while disconnected, oldest first
+func (h *Hub) replaySession(client *Client) {
+	if client.ID == uuid.Nil {
+		return
+	}
+	eventsKey := h.sessionEventsKey(client.ID)
+	events, err := database.GetRedisDB().Zrange(eventsKey, 0, -1)
+	if err != nil {
+		klog.Errorf("Error getting session events %v", err)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+	if _, err := database.GetRedisDB().Del(eventsKey); err != nil {
+		klog.Errorf("Error clearing session events %v", err)
+	}
+	for _, event := range events {
+		h.BroadcastToClient(client, []byte(event))
+	}
+}
+
+// saveSession stores the client's subscriptions and marks it online
+// Done before the client is told it's subscribed, so nothing in between gets buffered instead of sent
+func (c *Client) saveSession(update func(session *Session)) {
+	if err := c.Hub.updateSession(c, update); err != nil {
+		klog.Errorf("Error saving session %v", err)
+	}
+	c.Hub.markSessionOnline(c)
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/net"
+	"github.com/appditto/natrium-wallet-server/utils/mocks"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialHub(t *testing.T, server *httptest.Server) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.Equal(t, nil, err)
+	return conn
+}
+
+func TestSessionRestore(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	account := "nano_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3"
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		var request map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&request)
+		body := "{\"frontier\": \"80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F\", \"balance\": \"1\"}"
+		if request["action"] == "receivable" || request["action"] == "accounts_receivable" {
+			body = "{\"blocks\": \"\"}"
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+
+	hub := NewHub(false, &net.RPCClient{Url: "http://localhost:8080"}, nil)
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChl(hub, w, r)
+	}))
+	defer server.Close()
+
+	id := uuid.New()
+	conn := dialHub(t, server)
+	err := conn.WriteJSON(map[string]interface{}{"action": "account_subscribe", "account": account, "uuid": id.String(), "currency": "eur"})
+	assert.Equal(t, nil, err)
+	var response map[string]interface{}
+	err = conn.ReadJSON(&response)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, id.String(), response["uuid"])
+
+	session, err := hub.LoadSession(id)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{account}, session.Accounts)
+	assert.Equal(t, "EUR", session.Currency)
+
+	// Connected sessions get confirmations live, so nothing is buffered
+	hub.BufferForOfflineSessions(account, []byte("{\"hash\":\"live\"}"))
+	events, _ := database.GetRedisDB().Zrange(hub.sessionEventsKey(id), 0, -1)
+	assert.Len(t, events, 0)
+
+	// Disconnect, confirmations are buffered once no matter how many replicas see them
+	conn.Close()
+	assert.Eventually(t, func() bool { return len(hub.ConnectedClients()) == 0 }, time.Second, 10*time.Millisecond)
+	hub.BufferForOfflineSessions(account, []byte("{\"hash\":\"A\"}"))
+	hub.BufferForOfflineSessions(account, []byte("{\"hash\":\"B\"}"))
+	hub.BufferForOfflineSessions(account, []byte("{\"hash\":\"A\"}"))
+
+	// Reconnect with only the uuid
+	conn = dialHub(t, server)
+	defer conn.Close()
+	err = conn.WriteJSON(map[string]interface{}{"action": "account_subscribe", "uuid": id.String()})
+	assert.Equal(t, nil, err)
+	response = nil
+	err = conn.ReadJSON(&response)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, id.String(), response["uuid"])
+	assert.Equal(t, "EUR", response["currency"])
+	assert.Contains(t, response["accounts"], account)
+	assert.Eventually(t, func() bool { return len(hub.ClientsForAccount(account)) == 1 }, time.Second, 10*time.Millisecond)
+
+	// Then the missed confirmations, oldest first
+	for _, hash := range []string{"A", "B"} {
+		response = nil
+		err = conn.ReadJSON(&response)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, hash, response["hash"])
+	}
+	events, _ = database.GetRedisDB().Zrange(hub.sessionEventsKey(id), 0, -1)
+	assert.Len(t, events, 0)
+
+	// Unknown sessions can't be restored
+	err = conn.WriteJSON(map[string]interface{}{"action": "account_subscribe", "uuid": uuid.New().String()})
+	assert.Equal(t, nil, err)
+	response = nil
+	err = conn.ReadJSON(&response)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "session not found", response["error"])
+}
+
+func TestSessionBufferIsBounded(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	hub := NewHub(false, nil, nil)
+	hub.SessionBufferSize = 2
+	client := &Client{Hub: hub, ID: uuid.New(), Currency: "USD", Accounts: []string{"account1"}}
+	err := hub.updateSession(client, notificationUpdate("token", true))
+	assert.Equal(t, nil, err)
+	session, err := hub.LoadSession(client.ID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "token", session.FcmToken)
+	assert.True(t, session.NotificationEnabled)
+
+	for _, event := range []string{"1", "2", "3"} {
+		hub.BufferForOfflineSessions("account1", []byte(event))
+		// Scores are timestamps, keep them apart
+		time.Sleep(time.Millisecond)
+	}
+	events, err := database.GetRedisDB().Zrange(hub.sessionEventsKey(client.ID), 0, -1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"2", "3"}, events)
+
+	// Dropping the account from the session stops buffering for it
+	client.Accounts = []string{}
+	err = hub.updateSession(client, nil)
+	assert.Equal(t, nil, err)
+	sessions, _ := database.GetRedisDB().Smembers(hub.sessionAccountKey("account1"))
+	assert.Len(t, sessions, 0)
+}
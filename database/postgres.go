@@ -1,26 +1,21 @@
 package database
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/appditto/natrium-wallet-server/config"
 	"github.com/appditto/natrium-wallet-server/models/dbmodels"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-type Config struct {
-	Host     string
-	Port     string
-	Password string
-	User     string
-	DBName   string
-	SSLMode  string
-}
+type Config = config.DatabaseConfig
 
-func NewConnection(config *Config) (*gorm.DB, error) {
+func NewConnection(cfg *Config) (*gorm.DB, error) {
 	dsn := fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		config.Host, config.Port, config.User, config.Password, config.DBName, config.SSLMode,
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode,
 	)
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
@@ -30,14 +25,23 @@ func NewConnection(config *Config) (*gorm.DB, error) {
 }
 
 func DropAndCreateTables(db *gorm.DB) error {
-	err := db.Migrator().DropTable(&dbmodels.FcmToken{})
+	err := db.Migrator().DropTable(&dbmodels.FcmToken{}, &dbmodels.ApiKey{})
 	if err != nil {
 		return err
 	}
-	err = db.Migrator().CreateTable(&dbmodels.FcmToken{})
+	err = db.Migrator().CreateTable(&dbmodels.FcmToken{}, &dbmodels.ApiKey{})
 	return err
 }
 
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(&dbmodels.FcmToken{})
+	return db.AutoMigrate(&dbmodels.FcmToken{}, &dbmodels.ApiKey{})
+}
+
+// Ping checks the database connection is usable
+func Ping(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
 }
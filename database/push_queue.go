@@ -0,0 +1,340 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"golang.org/x/exp/slices"
+)
+
+const pushQueueGroup = "workers"
+
+// Remember the job was queued and add it in one go, so a failed add doesn't leave it marked as queued
+// A job with a score is held back in the retry set until then
+var enqueuePushJobScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], "1", "NX", "PX", ARGV[1]) == false then
+	return 0
+end
+if ARGV[3] == "0" then
+	redis.call("XADD", KEYS[2], "*", "job", ARGV[2])
+else
+	redis.call("ZADD", KEYS[3], ARGV[3], ARGV[2])
+end
+return 1
+`)
+
+// Move a job to the retry set, only if it's still in the stream so a job isn't retried twice
+var retryPushJobScript = redis.NewScript(`
+redis.call("XACK", KEYS[1], ARGV[1], ARGV[2])
+if redis.call("XDEL", KEYS[1], ARGV[2]) == 1 then
+	redis.call("ZADD", KEYS[2], ARGV[3], ARGV[4])
+	return 1
+end
+return 0
+`)
+
+var deadLetterPushJobScript = redis.NewScript(`
+redis.call("XACK", KEYS[1], ARGV[1], ARGV[2])
+if redis.call("XDEL", KEYS[1], ARGV[2]) == 1 then
+	redis.call("HSET", KEYS[2], ARGV[3], ARGV[4])
+	return 1
+end
+return 0
+`)
+
+// Only the replica that removes a due retry puts it back in the stream
+var promotePushJobsScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, job in ipairs(due) do
+	if redis.call("ZREM", KEYS[1], job) == 1 then
+		redis.call("XADD", KEYS[2], "*", "job", job)
+	end
+end
+return #due
+`)
+
+var replayPushJobScript = redis.NewScript(`
+if redis.call("HDEL", KEYS[1], ARGV[1]) == 1 then
+	redis.call("XADD", KEYS[2], "*", "job", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// PushJob is a notification waiting to be delivered to one device
+type PushJob struct {
+	// Decided by the block and token, so a notification is only queued once
+	ID       string            `json:"id"`
+	Hash     string            `json:"hash"`
+	Token    string            `json:"token"`
+	Platform string            `json:"platform"`
+	Title    string            `json:"title"`
+	Body     string            `json:"body"`
+	Tag      string            `json:"tag,omitempty"`
+	Data     map[string]string `json:"data,omitempty"`
+	Attempts int               `json:"attempts"`
+	// Why the last attempt failed
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Where the job is in the stream, while it's being worked on
+	StreamID string `json:"-"`
+}
+
+// PushJobID is the job's ID for a block and token
+func PushJobID(hash string, token string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(hash) + ":" + token))
+	return hex.EncodeToString(sum[:16])
+}
+
+// PushQueueStats counts the jobs in each state
+type PushQueueStats struct {
+	Queued   int64 `json:"queued"`
+	Retrying int64 `json:"retrying"`
+	Dead     int64 `json:"dead"`
+}
+
+// PushQueue is a redis stream of push notification jobs, shared by every replica
+// Jobs waiting for a retry are kept in a sorted set by when they're due, jobs that ran out of attempts in a hash
+type PushQueue struct {
+	Prefix string
+	// This replica's name in the consumer group
+	Consumer string
+	// How long a job can be worked on without being acked before another worker takes it over
+	ClaimTimeout time.Duration
+	// How long we remember a job was queued and delivered, so it isn't sent twice
+	DedupeTTL time.Duration
+}
+
+func NewPushQueue(prefix string, consumer string) *PushQueue {
+	return &PushQueue{
+		Prefix:       prefix,
+		Consumer:     consumer,
+		ClaimTimeout: time.Minute,
+		DedupeTTL:    24 * time.Hour,
+	}
+}
+
+func (q *PushQueue) streamKey() string {
+	return fmt.Sprintf("%s:stream", q.Prefix)
+}
+
+func (q *PushQueue) retryKey() string {
+	return fmt.Sprintf("%s:retry", q.Prefix)
+}
+
+func (q *PushQueue) deadKey() string {
+	return fmt.Sprintf("%s:dead", q.Prefix)
+}
+
+// Init creates the stream and consumer group if they don't exist yet
+func (q *PushQueue) Init() error {
+	err := GetRedisDB().Client.XGroupCreateMkStream(ctx, q.streamKey(), pushQueueGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// Enqueue adds the job, returns false if it was already queued
+func (q *PushQueue) Enqueue(job *PushJob) (bool, error) {
+	return q.EnqueueAt(job, time.Time{})
+}
+
+// EnqueueAt holds the job back until at, with retries that are due, or adds it straight away if at is zero
+func (q *PushQueue) EnqueueAt(job *PushJob, at time.Time) (bool, error) {
+	if job.ID == "" {
+		job.ID = PushJobID(job.Hash, job.Token)
+	}
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now().UTC()
+	}
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return false, err
+	}
+	var score int64
+	if !at.IsZero() {
+		score = at.UnixMilli()
+	}
+	queued, err := enqueuePushJobScript.Run(ctx, GetRedisDB().Client, []string{fmt.Sprintf("%s:queued:%s", q.Prefix, job.ID), q.streamKey(), q.retryKey()}, q.DedupeTTL.Milliseconds(), encoded, score).Int()
+	if err != nil {
+		return false, err
+	}
+	return queued == 1, nil
+}
+
+func decodePushJobs(messages []redis.XMessage) []*PushJob {
+	jobs := make([]*PushJob, 0, len(messages))
+	for _, message := range messages {
+		var job PushJob
+		encoded, _ := message.Values["job"].(string)
+		if err := json.Unmarshal([]byte(encoded), &job); err != nil {
+			// Can't do anything with it, but it still has to be acked
+			job = PushJob{LastError: fmt.Sprintf("Invalid job %v", err)}
+		}
+		job.StreamID = message.ID
+		jobs = append(jobs, &job)
+	}
+	return jobs
+}
+
+// Read waits up to block for new jobs, they have to be acked, retried or dead lettered once handled
+func (q *PushQueue) Read(count int64, block time.Duration) ([]*PushJob, error) {
+	// BLOCK 0 waits forever, so don't block at all
+	if block <= 0 {
+		block = -1
+	}
+	streams, err := GetRedisDB().Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    pushQueueGroup,
+		Consumer: q.Consumer,
+		Streams:  []string{q.streamKey(), ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*PushJob
+	for _, stream := range streams {
+		jobs = append(jobs, decodePushJobs(stream.Messages)...)
+	}
+	return jobs, nil
+}
+
+// ClaimStale takes over jobs another worker read but never finished, e.g. because its replica died
+func (q *PushQueue) ClaimStale(count int64) ([]*PushJob, error) {
+	messages, _, err := GetRedisDB().Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   q.streamKey(),
+		Group:    pushQueueGroup,
+		Consumer: q.Consumer,
+		MinIdle:  q.ClaimTimeout,
+		Start:    "0-0",
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodePushJobs(messages), nil
+}
+
+// Ack removes a handled job from the stream
+func (q *PushQueue) Ack(job *PushJob) error {
+	pipe := GetRedisDB().Client.TxPipeline()
+	pipe.XAck(ctx, q.streamKey(), pushQueueGroup, job.StreamID)
+	pipe.XDel(ctx, q.streamKey(), job.StreamID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Retry moves the job out of the stream until at
+func (q *PushQueue) Retry(job *PushJob, at time.Time) error {
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return retryPushJobScript.Run(ctx, GetRedisDB().Client, []string{q.streamKey(), q.retryKey()}, pushQueueGroup, job.StreamID, at.UnixMilli(), encoded).Err()
+}
+
+// DeadLetter moves the job out of the stream for good, until it's replayed
+func (q *PushQueue) DeadLetter(job *PushJob) error {
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return deadLetterPushJobScript.Run(ctx, GetRedisDB().Client, []string{q.streamKey(), q.deadKey()}, pushQueueGroup, job.StreamID, job.ID, encoded).Err()
+}
+
+// PromoteDue puts up to limit retries that are due back in the stream
+func (q *PushQueue) PromoteDue(now time.Time, limit int64) error {
+	return promotePushJobsScript.Run(ctx, GetRedisDB().Client, []string{q.retryKey(), q.streamKey()}, now.UnixMilli(), limit).Err()
+}
+
+// MarkSent remembers the job was delivered, in case it's handled again before it's acked
+func (q *PushQueue) MarkSent(job *PushJob) error {
+	return GetRedisDB().Set(fmt.Sprintf("%s:sent:%s", q.Prefix, job.ID), "1", q.DedupeTTL)
+}
+
+func (q *PushQueue) WasSent(job *PushJob) (bool, error) {
+	count, err := GetRedisDB().Client.Exists(ctx, fmt.Sprintf("%s:sent:%s", q.Prefix, job.ID)).Result()
+	return count > 0, err
+}
+
+// DeadJobs returns the jobs that ran out of attempts, oldest first
+func (q *PushQueue) DeadJobs() ([]*PushJob, error) {
+	encoded, err := GetRedisDB().Hgetall(q.deadKey())
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*PushJob, 0, len(encoded))
+	for _, value := range encoded {
+		var job PushJob
+		if err := json.Unmarshal([]byte(value), &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+	slices.SortFunc(jobs, func(a, b *PushJob) bool { return a.CreatedAt.Before(b.CreatedAt) })
+	return jobs, nil
+}
+
+// Replay puts a dead job back in the stream with its attempts reset, returns false if there's no such job
+func (q *PushQueue) Replay(id string) (bool, error) {
+	encoded, err := GetRedisDB().Hget(q.deadKey(), id)
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var job PushJob
+	if err := json.Unmarshal([]byte(encoded), &job); err != nil {
+		return false, err
+	}
+	job.Attempts = 0
+	job.LastError = ""
+	replayed, err := json.Marshal(job)
+	if err != nil {
+		return false, err
+	}
+	res, err := replayPushJobScript.Run(ctx, GetRedisDB().Client, []string{q.deadKey(), q.streamKey()}, id, replayed).Int()
+	return res == 1, err
+}
+
+func (q *PushQueue) Stats() (*PushQueueStats, error) {
+	pipe := GetRedisDB().Client.Pipeline()
+	queued := pipe.XLen(ctx, q.streamKey())
+	retrying := pipe.ZCard(ctx, q.retryKey())
+	dead := pipe.HLen(ctx, q.deadKey())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return &PushQueueStats{Queued: queued.Val(), Retrying: retrying.Val(), Dead: dead.Val()}, nil
+}
+
+// Close leaves the consumer group, unless we still have jobs in progress for another worker to claim
+func (q *PushQueue) Close() error {
+	pending, err := GetRedisDB().Client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   q.streamKey(),
+		Group:    pushQueueGroup,
+		Consumer: q.Consumer,
+		Start:    "-",
+		End:      "+",
+		Count:    1,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		err = nil
+	}
+	if err != nil || len(pending) > 0 {
+		return err
+	}
+	return GetRedisDB().Client.XGroupDelConsumer(ctx, q.streamKey(), pushQueueGroup, q.Consumer).Err()
+}
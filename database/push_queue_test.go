@@ -0,0 +1,135 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushQueue(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	queue := NewPushQueue("push_queue_test", "replica1")
+	assert.Equal(t, nil, queue.Init())
+	// Already exists
+	assert.Equal(t, nil, queue.Init())
+
+	// The same block and token is only queued once
+	queued, err := queue.Enqueue(&PushJob{Hash: "ABC", Token: "token1", Title: "Received Ӿ1"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, queued)
+	queued, err = queue.Enqueue(&PushJob{Hash: "abc", Token: "token1", Title: "Received Ӿ1"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, queued)
+	queued, err = queue.Enqueue(&PushJob{Hash: "ABC", Token: "token2", Title: "Received Ӿ1"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, queued)
+
+	jobs, err := queue.Read(10, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, PushJobID("ABC", "token1"), jobs[0].ID)
+	assert.Equal(t, "Received Ӿ1", jobs[0].Title)
+	assert.NotEmpty(t, jobs[0].StreamID)
+	// Nothing new
+	jobs2, err := queue.Read(10, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(jobs2))
+
+	// Acked jobs are gone, retries wait until they're due
+	assert.Equal(t, nil, queue.Ack(jobs[0]))
+	jobs[1].Attempts = 1
+	assert.Equal(t, nil, queue.Retry(jobs[1], time.Now().Add(time.Hour)))
+	stats, err := queue.Stats()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, PushQueueStats{Queued: 0, Retrying: 1, Dead: 0}, *stats)
+	assert.Equal(t, nil, queue.PromoteDue(time.Now(), 100))
+	stats, _ = queue.Stats()
+	assert.Equal(t, int64(1), stats.Retrying)
+	assert.Equal(t, nil, queue.PromoteDue(time.Now().Add(2*time.Hour), 100))
+	stats, _ = queue.Stats()
+	assert.Equal(t, PushQueueStats{Queued: 1, Retrying: 0, Dead: 0}, *stats)
+
+	jobs, err = queue.Read(10, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "token2", jobs[0].Token)
+	assert.Equal(t, 1, jobs[0].Attempts)
+
+	// Dead letter, then replay with the attempts reset
+	jobs[0].Attempts = 8
+	jobs[0].LastError = "FCM returned status 503"
+	assert.Equal(t, nil, queue.DeadLetter(jobs[0]))
+	dead, err := queue.DeadJobs()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(dead))
+	assert.Equal(t, "FCM returned status 503", dead[0].LastError)
+	replayed, err := queue.Replay(dead[0].ID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, replayed)
+	replayed, err = queue.Replay(dead[0].ID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, replayed)
+	jobs, err = queue.Read(10, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, 0, jobs[0].Attempts)
+	assert.Equal(t, "", jobs[0].LastError)
+
+	// Another replica takes over jobs that weren't finished in time
+	replica2 := NewPushQueue("push_queue_test", "replica2")
+	replica2.ClaimTimeout = 0
+	claimed, err := replica2.ClaimStale(10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(claimed))
+	assert.Equal(t, jobs[0].ID, claimed[0].ID)
+
+	// Delivered jobs are remembered
+	sent, err := queue.WasSent(claimed[0])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, sent)
+	assert.Equal(t, nil, queue.MarkSent(claimed[0]))
+	sent, _ = queue.WasSent(claimed[0])
+	assert.Equal(t, true, sent)
+
+	// A replica with jobs in progress stays in the group, so they can be claimed
+	assert.Equal(t, nil, replica2.Close())
+	consumers, err := GetRedisDB().Client.Do(ctx, "XINFO", "CONSUMERS", "push_queue_test:stream", pushQueueGroup).Slice()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(consumers))
+	assert.Equal(t, nil, replica2.Ack(claimed[0]))
+	assert.Equal(t, nil, replica2.Close())
+	assert.Equal(t, nil, queue.Close())
+	consumers, err = GetRedisDB().Client.Do(ctx, "XINFO", "CONSUMERS", "push_queue_test:stream", pushQueueGroup).Slice()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(consumers))
+}
+
+func TestPushQueueEnqueueAt(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	queue := NewPushQueue("push_queue_test_at", "replica1")
+	assert.Equal(t, nil, queue.Init())
+
+	// Held back until it's due, like a retry
+	queued, err := queue.EnqueueAt(&PushJob{Hash: "ABC", Token: "token1", Title: "Received Ӿ1"}, time.Now().Add(time.Hour))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, queued)
+	queued, err = queue.Enqueue(&PushJob{Hash: "ABC", Token: "token1", Title: "Received Ӿ1"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, queued)
+	jobs, err := queue.Read(10, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(jobs))
+
+	assert.Equal(t, nil, queue.PromoteDue(time.Now().Add(time.Hour), 100))
+	jobs, err = queue.Read(10, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "token1", jobs[0].Token)
+	assert.Equal(t, 0, jobs[0].Attempts)
+}
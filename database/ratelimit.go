@@ -0,0 +1,88 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// Refill the bucket for the time since it was last used, then take the cost if there's enough
+// Tokens are kept as a float so slow refill rates aren't rounded away
+// The time is redis', so replicas with clocks that are off don't refill buckets more or less than they should
+var takeTokensScript = redis.NewScript(`
+redis.replicate_commands()
+local capacity = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * capacity / period)
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], period)
+return {allowed, tostring(tokens)}
+`)
+
+// TokenBucket is a rate limit shared by every replica, Capacity tokens refill evenly over Period
+type TokenBucket struct {
+	Capacity int64
+	Period   time.Duration
+}
+
+type TokenBucketResult struct {
+	Allowed   bool
+	Remaining int64
+	// Until the bucket is full again
+	Reset time.Duration
+	// Until there are enough tokens for the cost, zero if it was allowed
+	RetryAfter time.Duration
+}
+
+// Take removes cost tokens from the bucket at key, if it has that many
+// A cost above the capacity is charged as the capacity, so it empties the bucket instead of never being allowed
+func (b TokenBucket) Take(key string, cost int64) (*TokenBucketResult, error) {
+	if cost > b.Capacity {
+		cost = b.Capacity
+	}
+	res, err := takeTokensScript.Run(ctx, GetRedisDB().Client, []string{key}, b.Capacity, b.Period.Milliseconds(), cost).Slice()
+	if err != nil {
+		return nil, err
+	}
+	allowed, _ := res[0].(int64)
+	tokensStr, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TokenBucketResult{
+		Allowed:   allowed == 1,
+		Remaining: int64(math.Floor(tokens)),
+		Reset:     b.refillTime(float64(b.Capacity) - tokens),
+	}
+	if !result.Allowed {
+		result.RetryAfter = b.refillTime(float64(cost) - tokens)
+	}
+	return result, nil
+}
+
+// How long it takes to refill the given number of tokens
+func (b TokenBucket) refillTime(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens * float64(b.Period) / float64(b.Capacity)))
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	bucket := TokenBucket{Capacity: 10, Period: time.Hour}
+
+	res, err := bucket.Take("ratelimit_test", 4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, res.Allowed)
+	assert.Equal(t, int64(6), res.Remaining)
+	assert.InDelta(t, float64(24*time.Minute), float64(res.Reset), float64(time.Second))
+
+	res, err = bucket.Take("ratelimit_test", 6)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, res.Allowed)
+	assert.Equal(t, int64(0), res.Remaining)
+
+	// Empty, nothing is taken and it says how long until there's enough
+	res, err = bucket.Take("ratelimit_test", 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, res.Allowed)
+	assert.Equal(t, int64(0), res.Remaining)
+	assert.InDelta(t, float64(12*time.Minute), float64(res.RetryAfter), float64(time.Second))
+
+	// Other keys have their own bucket, and a cost above the capacity empties it
+	res, err = bucket.Take("ratelimit_test_other", 50)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, res.Allowed)
+	assert.Equal(t, int64(0), res.Remaining)
+
+	// Refills over the period
+	fast := TokenBucket{Capacity: 10, Period: 100 * time.Millisecond}
+	res, err = fast.Take("ratelimit_test_fast", 10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, res.Allowed)
+	time.Sleep(50 * time.Millisecond)
+	res, err = fast.Take("ratelimit_test_fast", 4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, res.Allowed)
+}
@@ -3,12 +3,11 @@ package database
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"sync"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
-	"github.com/appditto/natrium-wallet-server/utils"
+	"github.com/appditto/natrium-wallet-server/config"
 	"github.com/go-redis/redis/v9"
 	"k8s.io/klog/v2"
 )
@@ -27,10 +26,26 @@ type redisManager struct {
 var singleton *redisManager
 var once sync.Once
 
+// Set by ConfigureRedis, otherwise read from the environment
+var redisConfig *config.RedisConfig
+
+// ConfigureRedis sets what GetRedisDB connects to, it has to be called before GetRedisDB is first used
+func ConfigureRedis(cfg config.RedisConfig) {
+	redisConfig = &cfg
+}
+
 func GetRedisDB() *redisManager {
 	once.Do(func() {
-		if utils.GetEnv("MOCK_REDIS", "false") == "true" {
-			klog.Infof("Using mock redis client because MOCK_REDIS=true is set in environment")
+		cfg := redisConfig
+		if cfg == nil {
+			defaults := config.Default()
+			if err := config.ApplyEnv(defaults); err != nil {
+				panic(err)
+			}
+			cfg = &defaults.Redis
+		}
+		if cfg.Mock {
+			klog.Infof("Using mock redis client because MOCK_REDIS=true is set")
 			mr, _ := miniredis.Run()
 			client := redis.NewClient(&redis.Options{
 				Addr: mr.Addr(),
@@ -40,17 +55,9 @@ func GetRedisDB() *redisManager {
 				Mock:   true,
 			}
 		} else {
-			redis_port, err := strconv.Atoi(utils.GetEnv("REDIS_PORT", "6379"))
-			if err != nil {
-				panic("Invalid REDIS_PORT specified")
-			}
-			redis_db, err := strconv.Atoi(utils.GetEnv("REDIS_DB", "0"))
-			if err != nil {
-				panic("Invalid REDIS_DB specified")
-			}
 			client := redis.NewClient(&redis.Options{
-				Addr: fmt.Sprintf("%s:%d", utils.GetEnv("REDIS_HOST", "localhost"), redis_port),
-				DB:   redis_db,
+				Addr: fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+				DB:   cfg.DB,
 			})
 			singleton = &redisManager{
 				Client: client,
@@ -61,9 +68,14 @@ func GetRedisDB() *redisManager {
 	return singleton
 }
 
+// ping - Redis PING
+func (r *redisManager) Ping(ctx context.Context) error {
+	return r.Client.Ping(ctx).Err()
+}
+
 // del - Redis DEL
-func (r *redisManager) Del(key string) (int64, error) {
-	val, err := r.Client.Del(ctx, key).Result()
+func (r *redisManager) Del(keys ...string) (int64, error) {
+	val, err := r.Client.Del(ctx, keys...).Result()
 	return val, err
 }
 
@@ -108,3 +120,62 @@ func (r *redisManager) Hdel(key string, field string) error {
 	err := r.Client.HDel(ctx, key, field).Err()
 	return err
 }
+
+// publish - Redis PUBLISH
+func (r *redisManager) Publish(channel string, message interface{}) error {
+	err := r.Client.Publish(ctx, channel, message).Err()
+	return err
+}
+
+// subscribe - Redis SUBSCRIBE, caller is responsible for closing the subscription
+func (r *redisManager) Subscribe(channel string) *redis.PubSub {
+	return r.Client.Subscribe(ctx, channel)
+}
+
+// setnx - Redis SET NX, returns true if the key was set
+func (r *redisManager) SetNX(key string, value string, expiry time.Duration) (bool, error) {
+	val, err := r.Client.SetNX(ctx, key, value, expiry).Result()
+	return val, err
+}
+
+// sadd - Redis SADD
+func (r *redisManager) Sadd(key string, members ...interface{}) error {
+	err := r.Client.SAdd(ctx, key, members...).Err()
+	return err
+}
+
+// smembers - Redis SMEMBERS
+func (r *redisManager) Smembers(key string) ([]string, error) {
+	val, err := r.Client.SMembers(ctx, key).Result()
+	return val, err
+}
+
+// srem - Redis SREM
+func (r *redisManager) Srem(key string, members ...interface{}) error {
+	err := r.Client.SRem(ctx, key, members...).Err()
+	return err
+}
+
+// expire - Redis EXPIRE
+func (r *redisManager) Expire(key string, expiry time.Duration) error {
+	err := r.Client.Expire(ctx, key, expiry).Err()
+	return err
+}
+
+// zaddnx - Redis ZADD NX, existing members keep their score
+func (r *redisManager) ZaddNX(key string, score float64, member string) error {
+	err := r.Client.ZAddNX(ctx, key, redis.Z{Score: score, Member: member}).Err()
+	return err
+}
+
+// zrange - Redis ZRANGE
+func (r *redisManager) Zrange(key string, start int64, stop int64) ([]string, error) {
+	val, err := r.Client.ZRange(ctx, key, start, stop).Result()
+	return val, err
+}
+
+// zremrangebyrank - Redis ZREMRANGEBYRANK
+func (r *redisManager) ZremRangeByRank(key string, start int64, stop int64) error {
+	err := r.Client.ZRemRangeByRank(ctx, key, start, stop).Err()
+	return err
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/go-redis/redis/v9"
 	"github.com/stretchr/testify/assert"
@@ -127,3 +128,93 @@ func TestHgetall(t *testing.T) {
 		assert.Contains(t, []string{v, v2}, val)
 	}
 }
+
+func TestPublishSubscribe(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	c := "channel"
+	v := "v"
+	sub := GetRedisDB().Subscribe(c)
+	defer sub.Close()
+	// Wait for subscription confirmation before publishing
+	_, err := sub.Receive(ctx)
+	assert.Equal(t, nil, err)
+	err = GetRedisDB().Publish(c, v)
+	assert.Equal(t, nil, err)
+	msg, err := sub.ReceiveMessage(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, v, msg.Payload)
+}
+
+func TestSetNX(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	k := "setnx_key"
+	v := "v"
+	set, err := GetRedisDB().SetNX(k, v, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, set)
+	set, err = GetRedisDB().SetNX(k, "other", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, set)
+	val, err := GetRedisDB().Get(k)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, v, val)
+}
+
+func TestSaddSmembers(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	k := "set"
+	err := GetRedisDB().Sadd(k, "v", "v2")
+	assert.Equal(t, nil, err)
+	err = GetRedisDB().Sadd(k, "v")
+	assert.Equal(t, nil, err)
+	vals, err := GetRedisDB().Smembers(k)
+	assert.Equal(t, nil, err)
+	assert.ElementsMatch(t, []string{"v", "v2"}, vals)
+}
+
+func TestSrem(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	k := "srem_set"
+	err := GetRedisDB().Sadd(k, "v", "v2")
+	assert.Equal(t, nil, err)
+	err = GetRedisDB().Srem(k, "v")
+	assert.Equal(t, nil, err)
+	vals, err := GetRedisDB().Smembers(k)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"v2"}, vals)
+}
+
+func TestZsetNX(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	k := "zset"
+	err := GetRedisDB().ZaddNX(k, 2, "b")
+	assert.Equal(t, nil, err)
+	err = GetRedisDB().ZaddNX(k, 1, "a")
+	assert.Equal(t, nil, err)
+	err = GetRedisDB().ZaddNX(k, 3, "c")
+	assert.Equal(t, nil, err)
+	// Existing members keep their score
+	err = GetRedisDB().ZaddNX(k, 4, "a")
+	assert.Equal(t, nil, err)
+	vals, err := GetRedisDB().Zrange(k, 0, -1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"a", "b", "c"}, vals)
+	// Keep only the last 2
+	err = GetRedisDB().ZremRangeByRank(k, 0, -3)
+	assert.Equal(t, nil, err)
+	vals, err = GetRedisDB().Zrange(k, 0, -1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"b", "c"}, vals)
+	err = GetRedisDB().Expire(k, time.Minute)
+	assert.Equal(t, nil, err)
+}
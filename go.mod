@@ -3,6 +3,7 @@ module github.com/appditto/natrium-wallet-server
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/Khan/genqlient v0.5.0
 	github.com/alicebob/miniredis/v2 v2.23.0
 	github.com/bananocoin/boompow/libs/utils v0.0.0-20220829001509-fb8caaad1e4f
@@ -10,14 +11,22 @@ require (
 	github.com/go-redis/redis/v9 v9.0.0-beta.2
 	github.com/google/uuid v1.3.0
 	github.com/googollee/go-socket.io v1.6.2
+	github.com/prometheus/client_golang v1.14.0
 	github.com/recws-org/recws v1.4.0
+	golang.org/x/net v0.12.0
+	golang.org/x/oauth2 v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
 	k8s.io/klog/v2 v2.70.1
 )
 
 require (
+	cloud.google.com/go/compute v1.20.1 // indirect
+	cloud.google.com/go/compute/metadata v0.2.3 // indirect
 	github.com/ajg/form v1.5.1 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/gofrs/uuid v4.0.0+incompatible // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/gomodule/redigo v2.0.0+incompatible // indirect
 	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
 	github.com/jackc/pgconn v1.12.1 // indirect
@@ -29,22 +38,24 @@ require (
 	github.com/jackc/pgx/v4 v4.16.1 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.4 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.37.0 // indirect
+	github.com/prometheus/procfs v0.8.0 // indirect
 	github.com/robfig/cron/v3 v3.0.1 // indirect
 	github.com/vektah/gqlparser/v2 v2.4.5 // indirect
-	golang.org/x/net v0.0.0-20220722155237-a158d28d115b // indirect
 	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4 // indirect
-	golang.org/x/text v0.3.7 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/text v0.11.0 // indirect
+	google.golang.org/appengine v1.6.7 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
 )
 
 require (
 	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
-	github.com/appleboy/go-fcm v0.1.5
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/go-chi/cors v1.2.1
-	github.com/go-chi/httprate v0.7.0
 	github.com/go-chi/render v1.0.2
 	github.com/go-co-op/gocron v1.17.0
 	github.com/go-logr/logr v1.2.3 // indirect
@@ -53,9 +64,9 @@ require (
 	github.com/mitchellh/mapstructure v1.5.0
 	github.com/stretchr/testify v1.8.0
 	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9 // indirect
-	golang.org/x/crypto v0.0.0-20220829220503-c86fa9a7ed90
+	golang.org/x/crypto v0.11.0
 	golang.org/x/exp v0.0.0-20220827204233-334a2380cb91
-	golang.org/x/sys v0.0.0-20220825204002-c680a09ffe64 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 	gorm.io/driver/postgres v1.3.9
 	gorm.io/gorm v1.23.8
 )
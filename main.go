@@ -2,34 +2,41 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	// Timezones for quiet hours, the image doesn't have them
+	_ "time/tzdata"
 
+	"github.com/appditto/natrium-wallet-server/config"
 	"github.com/appditto/natrium-wallet-server/controller"
 	"github.com/appditto/natrium-wallet-server/database"
-	"github.com/appditto/natrium-wallet-server/gql"
+	"github.com/appditto/natrium-wallet-server/metrics"
 	"github.com/appditto/natrium-wallet-server/models"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
 	"github.com/appditto/natrium-wallet-server/net"
 	"github.com/appditto/natrium-wallet-server/repository"
 	"github.com/appditto/natrium-wallet-server/utils"
-	"github.com/appleboy/go-fcm"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
-	"github.com/go-chi/httprate"
 	"github.com/go-chi/render"
 	"github.com/go-co-op/gocron"
+	"github.com/google/uuid"
 	socketio "github.com/googollee/go-socket.io"
 	"github.com/googollee/go-socket.io/engineio"
 	"github.com/googollee/go-socket.io/engineio/transport"
 	"github.com/googollee/go-socket.io/engineio/transport/polling"
 	"github.com/googollee/go-socket.io/engineio/transport/websocket"
-	"golang.org/x/exp/slices"
 	"k8s.io/klog/v2"
 )
 
@@ -56,7 +63,9 @@ func main() {
 	bananoPriceUpdate := flag.Bool("banano-price-update", false, "Update banano prices")
 	bananoMode := flag.Bool("banano", false, "Run in BANANO mode (Kalium)")
 	socketIoServer := flag.Bool("socket-io", false, "Run socket.io server (natrium.io/donate)")
+	wsPubSub := flag.Bool("ws-pubsub", false, "Fan out node websocket confirmations to all replicas over redis pub/sub")
 	version := flag.Bool("version", false, "Display the version")
+	configFile := flag.String("config", "", "YAML or TOML config file, environment variables override it. \"config check\" prints the effective config")
 	flag.Parse()
 
 	if *version {
@@ -64,6 +73,23 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Load config, the mode flags turn their modes on over it
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		klog.Errorf("Error loading config: %v", err)
+		os.Exit(1)
+	}
+	cfg.BananoMode = cfg.BananoMode || *bananoMode
+	cfg.SocketIO = cfg.SocketIO || *socketIoServer
+	cfg.NodeWebsocket.PubSub = cfg.NodeWebsocket.PubSub || *wsPubSub
+	if flag.Arg(0) == "config" {
+		if flag.Arg(1) != "check" {
+			usage()
+		}
+		os.Exit(checkConfig(cfg))
+	}
+	database.ConfigureRedis(cfg.Redis)
+
 	// Price job
 	if *bolivarPriceUpdate {
 		err := net.UpdateDolarTodayPrice()
@@ -119,17 +145,22 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Setup database conn
-	config := &database.Config{
-		Host:     os.Getenv("DB_HOST"),
-		Port:     os.Getenv("DB_PORT"),
-		Password: os.Getenv("DB_PASS"),
-		User:     os.Getenv("DB_USER"),
-		SSLMode:  os.Getenv("DB_SSLMODE"),
-		DBName:   os.Getenv("DB_NAME"),
+	// The price jobs only need redis, the server needs everything else
+	if err := cfg.Validate(); err != nil {
+		klog.Errorf("%v", err)
+		os.Exit(1)
 	}
+
+	// Cancelled on SIGINT or SIGTERM, everything long running stops with it
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	shutdownTimeout := cfg.ShutdownTimeout.Duration()
+	// Background goroutines that take ctx, waited on at shutdown
+	var background sync.WaitGroup
+
+	// Setup database conn
 	fmt.Println("🏡 Connecting to database...")
-	db, err := database.NewConnection(config)
+	db, err := database.NewConnection(&cfg.Database)
 	if err != nil {
 		panic(err)
 	}
@@ -137,92 +168,145 @@ func main() {
 	fmt.Println("🦋 Running database migrations...")
 	database.Migrate(db)
 
-	if utils.GetEnv("WORK_URL", "") == "" && utils.GetEnv("BPOW_KEY", "") == "" {
-		panic("Either WORK_URL or BPOW_KEY must be set for work generation")
-	}
-
 	// Create app
 	app := chi.NewRouter()
 
-	// BPoW if applicable
-	var bpowClient *gql.BpowClient
-	if utils.GetEnv("BPOW_KEY", "") != "" {
-		bpowUrl := "https://boompow.banano.cc/graphql"
-		if utils.GetEnv("BPOW_URL", "") != "" {
-			bpowUrl = utils.GetEnv("BPOW_URL", "")
-		}
-		bpowClient = gql.NewBpowClient(bpowUrl, utils.GetEnv("BPOW_KEY", ""), false)
+	// Setup RPC Client
+	rpcClient, err := net.NewRPCClient(cfg.RPC)
+	if err != nil {
+		panic(err)
+	}
+	// Pool of nodes with health checks and failover, if configured
+	if len(rpcClient.Nodes) > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			rpcClient.RunHealthChecks(ctx, 10*time.Second)
+		}()
 	}
 
-	// Setup RPC Client
-	nanoRpcUrl := utils.GetEnv("RPC_URL", "http://localhost:7076")
-	rpcClient := net.RPCClient{
-		Url:        nanoRpcUrl,
-		BpowClient: bpowClient,
+	// Setup work providers
+	rpcClient.WorkGenerator, err = net.NewWorkGeneratorFromConfig(cfg.Work, rpcClient)
+	if err != nil {
+		panic(err)
 	}
 
-	// Setup FCM client
-	var fcmClient *fcm.Client
-	fcmToken := utils.GetEnv("FCM_API_KEY", "")
-	if fcmToken != "" {
-		svc, err := fcm.NewClient(fcmToken)
+	// Generate work ahead of time for new frontiers of subscribed accounts
+	var workPrecache *net.WorkPrecache
+	if cfg.Work.Precache {
+		precacheMultiplier := 64
+		if cfg.BananoMode {
+			precacheMultiplier = 1
+		}
+		workPrecache = net.NewWorkPrecache(rpcClient.WorkGenerator, precacheMultiplier, cfg.Work.PrecacheMaxJobs)
+	}
+
+	// Setup push notifications, each token is sent with the sender for its platform
+	pushRouter := net.PushRouter{}
+	if cfg.Push.FcmCredentialsFile != "" {
+		fcmSender, err := net.NewFCMSender(cfg.Push.FcmCredentialsFile, cfg.Push.FcmProjectID)
 		if err != nil {
 			klog.Errorf("Error initating FCM client: %v", err)
 			os.Exit(1)
 		}
-		fcmClient = svc
+		pushRouter[dbmodels.PlatformFCM] = fcmSender
+	}
+	if cfg.Push.ApnsKeyFile != "" {
+		apnsSender, err := net.NewAPNsSender(cfg.Push.ApnsKeyFile, cfg.Push.ApnsKeyID, cfg.Push.ApnsTeamID, cfg.Push.ApnsTopic, cfg.Push.ApnsSandbox)
+		if err != nil {
+			klog.Errorf("Error initating APNs client: %v", err)
+			os.Exit(1)
+		}
+		pushRouter[dbmodels.PlatformAPNs] = apnsSender
+	}
+	var pushSender net.PushSender
+	if len(pushRouter) > 0 {
+		pushSender = pushRouter
 	}
 
 	// Create repository
 	fcmRepo := &repository.FcmTokenRepo{
 		DB: db,
 	}
+	apiKeyRepo := &repository.ApiKeyRepo{
+		DB: db,
+	}
 
 	// Setup controllers
-	pricePrefix := "nano"
-	if *bananoMode {
-		pricePrefix = "banano"
+	pricePrefix := cfg.PricePrefix()
+	pushMinimum, err := utils.AmountToRaw(cfg.Push.MinimumAmount, cfg.BananoMode)
+	if err != nil {
+		panic(err)
+	}
+	// No anonymous scopes configured means none, not the defaults
+	hc := controller.HttpController{RPCClient: rpcClient, BananoMode: cfg.BananoMode, FcmTokenRepo: fcmRepo, PushMinimum: pushMinimum, PricePrefix: pricePrefix, WorkPrecache: workPrecache, AnonymousScopes: append([]string{}, cfg.AnonymousScopes...)}
+
+	var pushWorker *controller.PushWorker
+	if pushSender != nil {
+		hostname, _ := os.Hostname()
+		replicaID := fmt.Sprintf("%s-%s", hostname, uuid.New().String())
+		// Every replica queues the callbacks it gets and works on the queue
+		hc.PushQueue = database.NewPushQueue(fmt.Sprintf("%s:push_queue", pricePrefix), replicaID)
+		pushWorker = &controller.PushWorker{
+			Queue:           hc.PushQueue,
+			Sender:          pushSender,
+			Tokens:          fcmRepo,
+			Workers:         cfg.Push.Concurrency,
+			MaxAttempts:     cfg.Push.MaxAttempts,
+			RetryBackoff:    cfg.Push.RetryBackoff.Duration(),
+			MaxRetryBackoff: cfg.Push.MaxRetryBackoff.Duration(),
+			SendTimeout:     30 * time.Second,
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			pushWorker.Run(ctx)
+		}()
 	}
-	hc := controller.HttpController{RPCClient: &rpcClient, BananoMode: *bananoMode, FcmTokenRepo: fcmRepo, FcmClient: fcmClient}
 
-	// Get RATE_LIMIT_WHITELIST from env
-	rateLimitWhitelist := strings.Split(utils.GetEnv("RATE_LIMIT_WHITELIST", ""), ",")
-	// Get ADMIN_API_KEY from env
-	adminAPIKey := utils.GetEnv("ADMIN_API_KEY", "")
+	// Resolve client IPs, forwarding headers are only believed from trusted proxies
+	trustedProxies, err := utils.ParseTrustedProxies(strings.Join(cfg.HTTP.TrustedProxies, ","))
+	if err != nil {
+		panic(err)
+	}
+	cloudflare, _ := utils.ParseTrustedProxies(utils.CloudflareProxies)
+	ipResolver := &utils.IPResolver{
+		TrustedProxies: trustedProxies,
+		Headers:        utils.ParseIPHeaders(strings.Join(cfg.HTTP.IPHeaders, ",")),
+		Cloudflare:     cloudflare,
+	}
+	app.Use(ipResolver.Middleware)
 
 	// Cors middleware
-	app.Use(cors.Handler(cors.Options{
-		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
-		//AllowedOrigins:   []string{"*"},
-		AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
+	corsOptions := cors.Options{
+		AllowedOrigins:   cfg.HTTP.CorsOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: false,
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}))
-	// Rate limiting middleware
-	app.Use(httprate.Limit(
-		100,           // requests
-		1*time.Minute, // per duration
-		// an oversimplified example of rate limiting by a custom header
-		httprate.WithKeyFuncs(func(r *http.Request) (string, error) {
-			key := utils.IPAddress(r)
-			if slices.Contains(rateLimitWhitelist, key) {
-				// Make key unique for whitelisted IPs
-				key = fmt.Sprint(time.Now().UnixNano())
-			}
-			if adminAPIKey != "" && r.Header.Get("Authorization") == adminAPIKey {
-				// Make key unique for admin API key
-				key = fmt.Sprint(time.Now().UnixNano())
-			}
-			return key, nil
-		}),
-	))
+		MaxAge:           cfg.HTTP.CorsMaxAge, // 300 is the maximum value not ignored by any of major browsers
+	}
+	// Any origin unless they're configured, AllowOriginFunc takes precedence over AllowedOrigins
+	if len(cfg.HTTP.CorsOrigins) == 0 {
+		corsOptions.AllowOriginFunc = func(r *http.Request, origin string) bool { return true }
+	}
+	app.Use(cors.Handler(corsOptions))
+	// Attach the API key's identity to requests, it decides the rate limit tier
+	authenticator := &controller.Authenticator{
+		ApiKeyRepo:  apiKeyRepo,
+		AdminAPIKey: cfg.AdminAPIKey,
+	}
+	app.Use(authenticator.Middleware)
+	// Rate limiting middleware, shared across replicas and with the websocket
+	rateLimiter := controller.NewRateLimiter(pricePrefix, cfg.RateLimit)
+	// Throttling the node would lose the confirmations it calls back with
+	rateLimiter.Unlimited = []string{"/callback"}
+	app.Use(rateLimiter.Middleware)
 
 	// HTTP Routes
 	app.Post("/api", hc.HandleAction)
 	app.Post("/callback", hc.HandleHTTPCallback)
+	app.Post("/notifications/preferences", hc.HandleNotificationPreferences)
 
 	// Alerts
 	app.Route("/alerts", func(r chi.Router) {
@@ -248,14 +332,79 @@ func main() {
 	})
 
 	// Setup WS endpoint
-	wsHub := controller.NewHub(*bananoMode, &rpcClient, fcmRepo)
+	wsHub := controller.NewHub(cfg.BananoMode, rpcClient, fcmRepo)
+	// Sessions, and what to do with clients that can't keep up
+	if err := wsHub.ApplyConfig(cfg.Websocket); err != nil {
+		panic(err)
+	}
+	wsHub.RateLimiter = rateLimiter
 	go wsHub.Run()
 	app.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		controller.WebsocketChl(wsHub, w, r)
 	})
+	// Metrics, on their own port if configured so they aren't public
+	var metricsServer *http.Server
+	if cfg.MetricsPort != 0 {
+		metricsApp := chi.NewRouter()
+		metricsApp.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{Addr: fmt.Sprintf(":%d", cfg.MetricsPort), Handler: metricsApp}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				klog.Errorf("Metrics server error: %v", err)
+			}
+		}()
+	} else {
+		app.Handle("/metrics", metrics.Handler())
+	}
+	app.Route("/admin", func(r chi.Router) {
+		r.Use(controller.RequireScope(dbmodels.ScopeAdmin))
+		r.Get("/ws/queues", wsHub.HandleQueueStats)
+		r.Get("/api_keys", authenticator.HandleListApiKeys)
+		r.Post("/api_keys", authenticator.HandleCreateApiKey)
+		r.Delete("/api_keys/{id}", authenticator.HandleDeleteApiKey)
+		if pushWorker != nil {
+			r.Get("/push/queue", pushWorker.HandlePushQueueStats)
+			r.Get("/push/dead", pushWorker.HandleDeadPushJobs)
+			r.Post("/push/dead/replay", pushWorker.HandleReplayPushJobs)
+			r.Post("/push/dead/{id}/replay", pushWorker.HandleReplayPushJob)
+		}
+	})
+
+	// Liveness and readiness probes
+	priceMaxAge := cfg.Health.PriceMaxAge.Duration()
+	healthController := &controller.HealthController{
+		Timeout: 5 * time.Second,
+		Checks: []*controller.HealthCheck{
+			{Name: "postgres", Check: func(ctx context.Context) error {
+				return database.Ping(ctx, db)
+			}},
+			{Name: "redis", Check: func(ctx context.Context) error {
+				return database.GetRedisDB().Ping(ctx)
+			}},
+			{Name: "rpc", Check: func(ctx context.Context) error {
+				_, err := rpcClient.BlockCount()
+				return err
+			}},
+			{Name: "prices", Check: func(ctx context.Context) error {
+				return net.CheckPriceFreshness(pricePrefix, priceMaxAge)
+			}},
+		},
+	}
+	// Only replicas connected to the node have a websocket to check
+	if cfg.NodeWebsocket.Url != "" {
+		healthController.Checks = append(healthController.Checks, &controller.HealthCheck{Name: "node_websocket", Check: func(ctx context.Context) error {
+			if !net.NodeWebsocketConnected() {
+				return fmt.Errorf("Not connected")
+			}
+			return nil
+		}})
+	}
+	if err := healthController.Require(strings.Join(cfg.ReadyRequired(), ",")); err != nil {
+		panic(err)
+	}
 
 	var sio *socketio.Server
-	if *socketIoServer {
+	if cfg.SocketIO {
 		// Socket.io endpoint is only for natrium.io/donate
 		sio = socketio.NewServer(&engineio.Options{
 			Transports: []transport.Transport{
@@ -288,13 +437,38 @@ func main() {
 
 	// Start nano WS client
 	callbackChan := make(chan *net.WSCallbackMsg, 100)
-	if utils.GetEnv("NODE_WS_URL", "") != "" {
-		go net.StartNanoWSClient(utils.GetEnv("NODE_WS_URL", ""), &callbackChan)
+	if cfg.NodeWebsocket.PubSub {
+		// The replica with NODE_WS_URL set acts as the broker, every replica receives from redis
+		pubSubChannel := fmt.Sprintf("%s:confirmations", pricePrefix)
+		if cfg.NodeWebsocket.Url != "" {
+			nodeChan := make(chan *net.WSCallbackMsg, 100)
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				net.StartNanoWSClient(ctx, cfg.NodeWebsocket.Url, &nodeChan)
+			}()
+			go net.PublishCallbacks(pubSubChannel, &nodeChan)
+		}
+		go net.SubscribeCallbacks(ctx, pubSubChannel, &callbackChan)
+	} else if cfg.NodeWebsocket.Url != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			net.StartNanoWSClient(ctx, cfg.NodeWebsocket.Url, &callbackChan)
+		}()
 	}
 
 	// Read channel to notify clients of blocks of new blocks
 	go func() {
 		for msg := range callbackChan {
+			// The account has a new block, so cached responses about it are stale
+			if err := net.InvalidateAccountCache(msg.Account); err != nil {
+				klog.Errorf("Error invalidating cache for %s: %v", msg.Account, err)
+			}
+			// The block is the account's new frontier, get work ready for its next block
+			if workPrecache != nil && len(wsHub.ClientsForAccount(msg.Account)) > 0 {
+				workPrecache.Enqueue(msg.Hash)
+			}
 			if msg.Block.Subtype != "send" {
 				continue
 			}
@@ -312,13 +486,11 @@ func main() {
 			}
 
 			// See if they are subscribed
-			for client, _ := range wsHub.Clients {
-				for _, account := range client.Accounts {
-					if account == msg.Block.LinkAsAccount {
-						client.Hub.BroadcastToClient(client, serialized)
-					}
-				}
+			for _, client := range wsHub.ClientsForAccount(msg.Block.LinkAsAccount) {
+				client.Hub.BroadcastToClient(client, serialized)
 			}
+			// Keep it for sessions that are disconnected, they get it when they come back
+			wsHub.BufferForOfflineSessions(msg.Block.LinkAsAccount, serialized)
 
 			// for socket.io
 			if sio != nil {
@@ -347,7 +519,7 @@ func main() {
 			return
 		}
 		var nanoPriceFloat float64
-		if *bananoMode {
+		if cfg.BananoMode {
 			nanoPriceStr, err := database.GetRedisDB().Hget("prices", fmt.Sprintf("coingecko:%s-nano", pricePrefix))
 			if err != nil {
 				klog.Errorf("Error getting nano price in cron: %v", err)
@@ -355,7 +527,7 @@ func main() {
 			}
 			nanoPriceFloat, err = strconv.ParseFloat(nanoPriceStr, 64)
 		}
-		for client, _ := range wsHub.Clients {
+		for _, client := range wsHub.ConnectedClients() {
 			currency := client.Currency
 			curStr, err := database.GetRedisDB().Hget("prices", fmt.Sprintf("coingecko:%s-%s", pricePrefix, strings.ToLower(currency)))
 			if err != nil {
@@ -372,7 +544,7 @@ func main() {
 				Price:    curFloat,
 				BtcPrice: btcPriceFloat,
 			}
-			if *bananoMode {
+			if cfg.BananoMode {
 				priceMessage.NanoPrice = &nanoPriceFloat
 			}
 			serialized, err := json.Marshal(priceMessage)
@@ -380,10 +552,65 @@ func main() {
 				klog.Errorf("Error serializing price message: %v", err)
 				continue
 			}
-			client.Hub.BroadcastToClient(client, serialized)
+			client.Hub.BroadcastPriceToClient(client, serialized)
 		}
 	})
+	s.Every(15).Seconds().Do(func() {
+		ages, err := net.PriceAges(pricePrefix)
+		if err != nil {
+			klog.Errorf("Error getting price ages: %v", err)
+			return
+		}
+		for currency, age := range ages {
+			metrics.PriceAge.WithLabelValues(currency).Set(age.Seconds())
+		}
+	})
+	if workPrecache != nil {
+		s.Every(10).Minutes().Do(func() {
+			stats := workPrecache.Stats()
+			klog.Infof("Work precache hits: %d, misses: %d, dropped: %d, pending: %d", stats.Hits, stats.Misses, stats.Dropped, stats.Pending)
+		})
+	}
 	s.StartAsync()
 
-	http.ListenAndServe(":3000", app)
+	// Probes skip the middleware, they shouldn't be rate limited or need a key
+	router := chi.NewRouter()
+	router.Get("/healthz", healthController.HandleHealthz)
+	router.Get("/readyz", healthController.HandleReadyz)
+	router.Mount("/", app)
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: router}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorf("Server error: %v", err)
+			stop()
+		}
+	}()
+	<-ctx.Done()
+	stop()
+
+	klog.Infof("Shutting down, waiting up to %s", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	shutdownSteps := map[string]func(context.Context) error{
+		// Stops accepting connections and waits for in-flight requests, callbacks only queue their push notifications
+		"http server": server.Shutdown,
+		// Websocket connections are hijacked, so the server doesn't wait for them
+		"websocket clients": wsHub.Shutdown,
+		"scheduler": func(ctx context.Context) error {
+			// Waits for running jobs
+			s.Stop()
+			return nil
+		},
+		// Includes the push workers, they finish the notifications they're sending and leave jobs still queued to other replicas
+		"background jobs": func(ctx context.Context) error {
+			background.Wait()
+			return nil
+		},
+	}
+	if metricsServer != nil {
+		shutdownSteps["metrics server"] = metricsServer.Shutdown
+	}
+	shutdown(shutdownCtx, shutdownSteps)
+	klog.Infof("Shutdown complete")
 }
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "natrium"
+
+var (
+	RPCRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_requests_total",
+		Help:      "RPC requests made to nodes, by action, node and result",
+	}, []string{"action", "node", "result"})
+
+	RPCRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_request_duration_seconds",
+		Help:      "Latency of RPC requests made to nodes, by action and node",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"action", "node"})
+
+	WorkGenerateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "work_generate_duration_seconds",
+		Help:      "Latency of work generation, by provider and result",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30},
+	}, []string{"provider", "result"})
+
+	WebsocketClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_clients",
+		Help:      "Connected websocket clients",
+	})
+
+	WebsocketSubscribedAccounts = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_subscribed_accounts",
+		Help:      "Distinct accounts connected websocket clients are subscribed to",
+	})
+
+	NodeWebsocketConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_websocket_connected",
+		Help:      "Whether the node websocket is connected, by host",
+	}, []string{"node"})
+
+	NodeWebsocketConfirmations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_websocket_confirmations_total",
+		Help:      "Confirmations received from the node websocket, by host",
+	}, []string{"node"})
+
+	PushSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "push_sends_total",
+		Help:      "Push notifications sent, by platform",
+	}, []string{"platform"})
+
+	PushFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "push_failures_total",
+		Help:      "Push notifications that failed to send, by platform",
+	}, []string{"platform"})
+
+	PushRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "push_retries_total",
+		Help:      "Push notifications queued to be retried after failing",
+	})
+
+	PushDeadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "push_dead_letters_total",
+		Help:      "Push notifications given up on after running out of attempts",
+	})
+
+	PriceAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "price_age_seconds",
+		Help:      "Time since each price was last updated, by currency",
+	}, []string{"currency"})
+
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected for going over the rate limit, by tier and whether they came over HTTP or the websocket",
+	}, []string{"tier", "source"})
+)
+
+// Result label for an error
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// NodeLabel is the host of a node's URL, so credentials in the URL don't end up in the metrics
+func NodeLabel(nodeUrl string) string {
+	parsed, err := url.Parse(nodeUrl)
+	if err != nil || parsed.Host == "" {
+		return "unknown"
+	}
+	return parsed.Host
+}
+
+// ObserveRPC records an RPC request to a node that started at start
+func ObserveRPC(action string, nodeUrl string, start time.Time, err error) {
+	node := NodeLabel(nodeUrl)
+	RPCRequests.WithLabelValues(action, node, Result(err)).Inc()
+	RPCRequestDuration.WithLabelValues(action, node).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves every registered metric
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
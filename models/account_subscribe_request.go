@@ -8,4 +8,6 @@ type AccountSubscribe struct {
 	Currency            *string `json:"currency,omitempty" mapstructure:"currency,omitempty"`
 	FcmToken            string  `json:"fcm_token_v2" mapstructure:"fcm_token_v2"`
 	NotificationEnabled bool    `json:"notification_enabled" mapstructure:"notification_enabled"`
+	// fcm (default) or apns for a native iOS device token
+	Platform string `json:"platform,omitempty" mapstructure:"platform,omitempty"`
 }
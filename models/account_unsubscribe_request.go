@@ -0,0 +1,8 @@
+package models
+
+// account_unsubscribe request
+type AccountUnsubscribe struct {
+	Action   string `json:"action" mapstructure:"action"`
+	Account  string `json:"account" mapstructure:"account"`
+	FcmToken string `json:"fcm_token_v2" mapstructure:"fcm_token_v2"`
+}
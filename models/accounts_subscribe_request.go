@@ -0,0 +1,13 @@
+package models
+
+// accounts_subscribe request, account_subscribe for several accounts at once
+type AccountsSubscribe struct {
+	Action              string   `json:"action" mapstructure:"action"`
+	Uuid                *string  `json:"uuid,omitempty" mapstructure:"uuid,omitempty"`
+	Accounts            []string `json:"accounts" mapstructure:"accounts"`
+	Currency            *string  `json:"currency,omitempty" mapstructure:"currency,omitempty"`
+	FcmToken            string   `json:"fcm_token_v2" mapstructure:"fcm_token_v2"`
+	NotificationEnabled bool     `json:"notification_enabled" mapstructure:"notification_enabled"`
+	// fcm (default) or apns for a native iOS device token
+	Platform string `json:"platform,omitempty" mapstructure:"platform,omitempty"`
+}
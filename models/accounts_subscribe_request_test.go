@@ -0,0 +1,26 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapStructureDecodeAccountsSubscribeRequest(t *testing.T) {
+	// Lists come out of json.Unmarshal as []interface{}
+	request := map[string]interface{}{
+		"action":               "accounts_subscribe",
+		"accounts":             []interface{}{"1", "2"},
+		"fcm_token_v2":         "token",
+		"notification_enabled": true,
+	}
+	var decoded AccountsSubscribe
+	err := mapstructure.Decode(request, &decoded)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "accounts_subscribe", decoded.Action)
+	assert.Equal(t, []string{"1", "2"}, decoded.Accounts)
+	assert.Equal(t, "token", decoded.FcmToken)
+	assert.Equal(t, true, decoded.NotificationEnabled)
+	assert.Nil(t, decoded.Uuid)
+}
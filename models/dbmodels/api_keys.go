@@ -0,0 +1,64 @@
+package dbmodels
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// What an API key is allowed to do
+const (
+	ScopeRPCRead = "rpc:read"
+	ScopeProcess = "process"
+	ScopeWork    = "work"
+	// Everything, including the admin endpoints
+	ScopeAdmin = "admin"
+)
+
+var ApiKeyScopes = []string{ScopeRPCRead, ScopeProcess, ScopeWork, ScopeAdmin}
+
+// Scopes of requests made without an API key, unless they're configured
+// The wallet publishes blocks and asks for work without a key
+var DefaultAnonymousScopes = []string{ScopeRPCRead, ScopeProcess, ScopeWork}
+
+// Rate limit tiers an API key can be in, each has its own limit in the config
+const (
+	RateTierDefault     = "default"
+	RateTierWhitelisted = "whitelisted"
+	RateTierAdmin       = "admin"
+)
+
+var ApiKeyRateTiers = []string{RateTierDefault, RateTierWhitelisted, RateTierAdmin}
+
+// API keys for integrations, only a hash of the key is stored
+type ApiKey struct {
+	Base
+	Name    string `json:"name" gorm:"uniqueIndex"`
+	KeyHash string `json:"-" gorm:"uniqueIndex"`
+	// Comma separated
+	Scopes string `json:"scopes"`
+	// Rate limit tier, empty for the default
+	RateTier string `json:"rate_tier"`
+	// Most a count can be in requests made with the key, 0 for the default
+	MaxCount   int64      `json:"max_count"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func (k *ApiKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+// HasScope is true if the key has the scope, or the admin scope
+func (k *ApiKey) HasScope(scope string) bool {
+	scopes := k.ScopeList()
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, ScopeAdmin)
+}
+
+func (k *ApiKey) IsExpired() bool {
+	return k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now())
+}
@@ -1,8 +1,20 @@
 package dbmodels
 
+// Where a token's push notifications are sent
+const (
+	PlatformFCM = "fcm"
+	// Native iOS device tokens, sent to APNs directly
+	PlatformAPNs = "apns"
+)
+
+var PushPlatforms = []string{PlatformFCM, PlatformAPNs}
+
 // Store FCM tokens in database for push notifications
 type FcmToken struct {
 	Base
 	FcmToken string `json:"fcm_token" gorm:"index:fcm_token_index,unique"`
 	Account  string `json:"account" gorm:"index:fcm_token_index,unique"`
+	Platform string `json:"platform" gorm:"not null;default:fcm"`
+	// Which notifications it gets for the account
+	NotificationPreferences `gorm:"embedded"`
 }
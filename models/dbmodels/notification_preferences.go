@@ -0,0 +1,77 @@
+package dbmodels
+
+import (
+	"time"
+)
+
+// What a device can be notified of
+const (
+	// Someone sent to the account
+	NotificationEventReceive = "receive"
+	// The account sent, e.g. from another device
+	NotificationEventSend = "send"
+)
+
+var NotificationEvents = []string{NotificationEventReceive, NotificationEventSend}
+
+// NotificationPreferences decide which notifications a token gets for an account, and how they read
+type NotificationPreferences struct {
+	// Raw, empty for the server's default
+	MinimumRaw string `json:"minimum_raw,omitempty"`
+	Language   string `json:"language" gorm:"not null;default:en"`
+	// Fiat currency the amount is also shown in, none if empty
+	Currency string `json:"currency,omitempty"`
+	// Local HH:MM times in the timezone, notifications are held back between them
+	QuietStart    string `json:"quiet_start,omitempty"`
+	QuietEnd      string `json:"quiet_end,omitempty"`
+	Timezone      string `json:"timezone,omitempty"`
+	NotifyReceive bool   `json:"notify_receive" gorm:"not null;default:true"`
+	NotifySend    bool   `json:"notify_send" gorm:"not null;default:false"`
+}
+
+// Wants returns whether the event is turned on
+func (p *NotificationPreferences) Wants(event string) bool {
+	switch event {
+	case NotificationEventReceive:
+		return p.NotifyReceive
+	case NotificationEventSend:
+		return p.NotifySend
+	}
+	return false
+}
+
+// QuietUntil returns when the quiet hours end if now is in them
+func (p *NotificationPreferences) QuietUntil(now time.Time) (time.Time, bool) {
+	if p.QuietStart == "" || p.QuietEnd == "" {
+		return time.Time{}, false
+	}
+	start, err := time.Parse("15:04", p.QuietStart)
+	if err != nil {
+		return time.Time{}, false
+	}
+	end, err := time.Parse("15:04", p.QuietEnd)
+	if err != nil {
+		return time.Time{}, false
+	}
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+	quiet := minute >= startMinute && minute < endMinute
+	// e.g. 22:00 to 07:00
+	if startMinute > endMinute {
+		quiet = minute >= startMinute || minute < endMinute
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+	until := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, location)
+	if !until.After(local) {
+		until = until.AddDate(0, 0, 1)
+	}
+	return until, true
+}
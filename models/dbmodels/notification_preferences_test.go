@@ -0,0 +1,44 @@
+package dbmodels
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWants(t *testing.T) {
+	preferences := NotificationPreferences{NotifyReceive: true}
+	assert.True(t, preferences.Wants(NotificationEventReceive))
+	assert.False(t, preferences.Wants(NotificationEventSend))
+	assert.False(t, preferences.Wants("change"))
+}
+
+func TestQuietUntil(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	preferences := NotificationPreferences{QuietStart: "22:00", QuietEnd: "07:30", Timezone: "Europe/Berlin"}
+
+	// Over midnight
+	until, quiet := preferences.QuietUntil(time.Date(2026, 3, 10, 23, 15, 0, 0, berlin))
+	assert.True(t, quiet)
+	assert.Equal(t, time.Date(2026, 3, 11, 7, 30, 0, 0, berlin), until)
+	until, quiet = preferences.QuietUntil(time.Date(2026, 3, 11, 6, 0, 0, 0, berlin).UTC())
+	assert.True(t, quiet)
+	assert.True(t, time.Date(2026, 3, 11, 7, 30, 0, 0, berlin).Equal(until))
+	_, quiet = preferences.QuietUntil(time.Date(2026, 3, 11, 7, 30, 0, 0, berlin))
+	assert.False(t, quiet)
+	_, quiet = preferences.QuietUntil(time.Date(2026, 3, 11, 12, 0, 0, 0, berlin))
+	assert.False(t, quiet)
+
+	// Within a day, UTC without a timezone
+	preferences = NotificationPreferences{QuietStart: "13:00", QuietEnd: "14:00"}
+	until, quiet = preferences.QuietUntil(time.Date(2026, 3, 11, 13, 59, 0, 0, time.UTC))
+	assert.True(t, quiet)
+	assert.Equal(t, time.Date(2026, 3, 11, 14, 0, 0, 0, time.UTC), until)
+	_, quiet = preferences.QuietUntil(time.Date(2026, 3, 11, 22, 0, 0, 0, time.UTC))
+	assert.False(t, quiet)
+
+	// None
+	_, quiet = (&NotificationPreferences{}).QuietUntil(time.Now())
+	assert.False(t, quiet)
+}
@@ -5,4 +5,6 @@ type FcmUpdate struct {
 	FcmToken string `json:"fcm_token_v2" mapstructure:"fcm_token_v2"`
 	Account  string `json:"account" mapstructure:"account"`
 	Enabled  bool   `json:"enabled" mapstructure:"enabled"`
+	// fcm (default) or apns for a native iOS device token
+	Platform string `json:"platform,omitempty" mapstructure:"platform,omitempty"`
 }
@@ -21,6 +21,14 @@ type ReceivableRequest struct {
 	IncludeOnlyConfirmed bool   `json:"include_only_confirmed"`
 }
 
+type AccountsReceivableRequest struct {
+	Action               string   `json:"action"`
+	Accounts             []string `json:"accounts"`
+	Threshold            string   `json:"threshold"`
+	Count                int      `json:"count"`
+	IncludeOnlyConfirmed bool     `json:"include_only_confirmed"`
+}
+
 type BlockRequest struct {
 	Action    string `json:"action"`
 	Hash      string `json:"hash"`
@@ -69,6 +77,38 @@ func (r *ReceivableResponse) UnmarshalJSON(data []byte) error {
 	return errors.New("unexpected format for blocks")
 }
 
+type AccountsReceivableResponse struct {
+	Blocks map[string]map[string]string
+}
+
+// UnmarshalJSON handles "blocks", or any account in it, being an empty string when nothing is receivable
+func (r *AccountsReceivableResponse) UnmarshalJSON(data []byte) error {
+	var obj struct {
+		Blocks json.RawMessage `json:"blocks"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	r.Blocks = make(map[string]map[string]string)
+	if len(obj.Blocks) == 0 || string(obj.Blocks) == `""` {
+		return nil
+	}
+	var accounts map[string]json.RawMessage
+	if err := json.Unmarshal(obj.Blocks, &accounts); err != nil {
+		return errors.New("unexpected format for blocks")
+	}
+	for account, raw := range accounts {
+		var blocks map[string]string
+		if string(raw) != `""` {
+			if err := json.Unmarshal(raw, &blocks); err != nil {
+				return errors.New("unexpected format for blocks")
+			}
+		}
+		r.Blocks[account] = blocks
+	}
+	return nil
+}
+
 type BlockContents struct {
 	Type           string `json:"type"`
 	Account        string `json:"account"`
@@ -93,6 +133,18 @@ type BlockResponse struct {
 	Subtype        string        `json:"subtype"`
 }
 
+type BlockCountResponse struct {
+	Count     string `json:"count"`
+	Unchecked string `json:"unchecked"`
+	Cemented  string `json:"cemented"`
+}
+
+type VersionResponse struct {
+	RpcVersion   string `json:"rpc_version"`
+	StoreVersion string `json:"store_version"`
+	NodeVendor   string `json:"node_vendor"`
+}
+
 type WorkResponse struct {
 	Work       string `json:"work"`
 	Difficulty string `json:"difficulty"`
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalAccountsReceivableResponse(t *testing.T) {
+	var decoded AccountsReceivableResponse
+	err := json.Unmarshal([]byte(`{"blocks": {"nano_1": {"A": "1", "B": "2"}, "nano_2": ""}}`), &decoded)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(decoded.Blocks["nano_1"]))
+	assert.Equal(t, 0, len(decoded.Blocks["nano_2"]))
+
+	// Nothing receivable for any account
+	err = json.Unmarshal([]byte(`{"blocks": ""}`), &decoded)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(decoded.Blocks))
+
+	err = json.Unmarshal([]byte(`{"blocks": ["A"]}`), &decoded)
+	assert.NotEqual(t, nil, err)
+}
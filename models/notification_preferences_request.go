@@ -0,0 +1,25 @@
+package models
+
+// notification_preferences request, preferences that are left out aren't changed
+type NotificationPreferences struct {
+	Action   string `json:"action,omitempty" mapstructure:"action,omitempty"`
+	FcmToken string `json:"fcm_token_v2" mapstructure:"fcm_token_v2"`
+	Account  string `json:"account" mapstructure:"account"`
+	// In NANO or BANANO, empty for the server's default
+	MinimumAmount *string `json:"minimum_amount,omitempty" mapstructure:"minimum_amount,omitempty"`
+	Language      *string `json:"language,omitempty" mapstructure:"language,omitempty"`
+	// Empty to leave out the fiat amount
+	Currency *string `json:"currency,omitempty" mapstructure:"currency,omitempty"`
+	// Empty start and end turn them off
+	QuietHours *QuietHours `json:"quiet_hours,omitempty" mapstructure:"quiet_hours,omitempty"`
+	// By event type, receive or send
+	Events map[string]bool `json:"events,omitempty" mapstructure:"events,omitempty"`
+}
+
+type QuietHours struct {
+	// Local HH:MM
+	Start string `json:"start" mapstructure:"start"`
+	End   string `json:"end" mapstructure:"end"`
+	// IANA name like Europe/Berlin, UTC if empty
+	Timezone string `json:"timezone" mapstructure:"timezone"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapStructureDecodeNotificationPreferencesRequest(t *testing.T) {
+	var request map[string]interface{}
+	json.Unmarshal([]byte(`{"action":"notification_preferences","fcm_token_v2":"token","account":"1","currency":"eur","quiet_hours":{"start":"22:00","end":"07:00","timezone":"Europe/Berlin"},"events":{"send":true}}`), &request)
+	var decoded NotificationPreferences
+	err := mapstructure.Decode(request, &decoded)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "token", decoded.FcmToken)
+	assert.Equal(t, "1", decoded.Account)
+	assert.Equal(t, "eur", *decoded.Currency)
+	assert.Equal(t, &QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Berlin"}, decoded.QuietHours)
+	assert.Equal(t, map[string]bool{"send": true}, decoded.Events)
+	// Left out, so they aren't changed
+	assert.Nil(t, decoded.MinimumAmount)
+	assert.Nil(t, decoded.Language)
+}
@@ -0,0 +1,8 @@
+package models
+
+// work_generate from an API key with the work scope, served by our work providers
+type WorkGenerateRequest struct {
+	Action               string `json:"action" mapstructure:"action"`
+	Hash                 string `json:"hash" mapstructure:"hash"`
+	DifficultyMultiplier int    `json:"difficulty_multiplier,omitempty" mapstructure:"difficulty_multiplier,omitempty"`
+}
@@ -0,0 +1,185 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	apnsProductionEndpoint = "https://api.push.apple.com"
+	apnsSandboxEndpoint    = "https://api.sandbox.push.apple.com"
+)
+
+// Device tokens are hex, 32 bytes today, but Apple says they can get longer
+var apnsDeviceTokenPattern = regexp.MustCompile(`^([0-9a-fA-F]{2}){32,100}$`)
+
+// Apple rejects provider tokens older than an hour, and refreshing more than every 20 minutes
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsSender sends push notifications straight to Apple, authenticated with a .p8 signing key
+type APNsSender struct {
+	KeyID  string
+	TeamID string
+	// The app's bundle ID
+	Topic    string
+	Endpoint string
+	// APNs only speaks HTTP/2
+	Client HTTPClient
+
+	key           *ecdsa.PrivateKey
+	mu            sync.Mutex
+	token         string
+	tokenIssuedAt time.Time
+}
+
+// NewAPNsSender reads the .p8 key, sandbox is for development builds of the app
+func NewAPNsSender(keyFile string, keyID string, teamID string, topic string, sandbox bool) (*APNsSender, error) {
+	contents, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseAPNsKey(contents)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid APNs key %s: %w", keyFile, err)
+	}
+	endpoint := apnsProductionEndpoint
+	if sandbox {
+		endpoint = apnsSandboxEndpoint
+	}
+	return &APNsSender{
+		KeyID:    keyID,
+		TeamID:   teamID,
+		Topic:    topic,
+		Endpoint: endpoint,
+		Client:   &http.Client{Transport: &http2.Transport{}, Timeout: 30 * time.Second},
+		key:      key,
+	}, nil
+}
+
+// ParseAPNsKey parses the PKCS8 P-256 key Apple hands out as a .p8 file
+func ParseAPNsKey(contents []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM block")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Not an ECDSA key")
+	}
+	return key, nil
+}
+
+// authToken returns the provider token, signing a new one when it's close to expiring
+func (s *APNsSender) authToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Since(s.tokenIssuedAt) < apnsTokenLifetime {
+		return s.token, nil
+	}
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": s.KeyID})
+	claims, _ := json.Marshal(map[string]interface{}{"iss": s.TeamID, "iat": now.Unix()})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, hash[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants r and s as fixed size big endian, not ASN.1
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	sig.FillBytes(signature[32:])
+	s.token = unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	s.tokenIssuedAt = now
+	return s.token, nil
+}
+
+// Forget the provider token, so the next send signs a new one
+func (s *APNsSender) resetToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+type apnsErrorResponse struct {
+	Reason string `json:"reason"`
+}
+
+func (s *APNsSender) Send(ctx context.Context, msg *PushMessage) error {
+	// It goes in the URL, so anything else would change the request
+	if !apnsDeviceTokenPattern.MatchString(msg.Token) {
+		return fmt.Errorf("%w: not an APNs device token", ErrInvalidToken)
+	}
+	token, err := s.authToken()
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": msg.Title, "body": msg.Body},
+			"sound": "default",
+		},
+	}
+	for key, value := range msg.Data {
+		payload[key] = value
+	}
+	requestBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/3/device/%s", s.Endpoint, msg.Token), bytes.NewBuffer(requestBody))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "bearer "+token)
+	request.Header.Set("apns-topic", s.Topic)
+	request.Header.Set("apns-push-type", "alert")
+	request.Header.Set("apns-priority", "10")
+	if msg.Tag != "" {
+		request.Header.Set("apns-collapse-id", apnsCollapseID(msg.Tag))
+	}
+	resp, err := s.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var errorResponse apnsErrorResponse
+	if err := json.Unmarshal(body, &errorResponse); err != nil {
+		return fmt.Errorf("APNs returned status %d", resp.StatusCode)
+	}
+	switch errorResponse.Reason {
+	case "BadDeviceToken", "Unregistered", "DeviceTokenNotForTopic":
+		return fmt.Errorf("%w: %s", ErrInvalidToken, errorResponse.Reason)
+	case "ExpiredProviderToken", "InvalidProviderToken":
+		s.resetToken()
+	}
+	return fmt.Errorf("APNs returned status %d %s", resp.StatusCode, errorResponse.Reason)
+}
@@ -0,0 +1,192 @@
+package net
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes a .p8 like the ones Apple hands out
+func writeAPNsKey(t *testing.T) (string, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "AuthKey_ABC123DEFG.p8")
+	assert.Nil(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	return path, key
+}
+
+// Checks the provider token is an ES256 JWT signed with the key
+func verifyAPNsToken(t *testing.T, key *ecdsa.PublicKey, authorization string) {
+	assert.True(t, strings.HasPrefix(authorization, "bearer "))
+	parts := strings.Split(strings.TrimPrefix(authorization, "bearer "), ".")
+	assert.Equal(t, 3, len(parts))
+	var header, claims map[string]interface{}
+	headerJson, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	json.Unmarshal(headerJson, &header)
+	claimsJson, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(claimsJson, &claims)
+	assert.Equal(t, map[string]interface{}{"alg": "ES256", "kid": "ABC123DEFG"}, header)
+	assert.Equal(t, "TEAM123456", claims["iss"])
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.Equal(t, 64, len(signature))
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.True(t, ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])))
+}
+
+// Stands in for APNs, over HTTP/2 with TLS like the real thing
+func newAPNsStub(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, 2, r.ProtoMajor)
+		handler(w, r)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestAPNsSender(t *testing.T, handler http.HandlerFunc) (*APNsSender, *ecdsa.PrivateKey) {
+	keyFile, key := writeAPNsKey(t)
+	sender, err := NewAPNsSender(keyFile, "ABC123DEFG", "TEAM123456", "co.banano.natriumwallet", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.push.apple.com", sender.Endpoint)
+	server := newAPNsStub(t, handler)
+	sender.Endpoint = server.URL
+	sender.Client = server.Client()
+	return sender, key
+}
+
+const testAPNsDeviceToken = "740f4707bebcf74f9b7c25d48e3358945f6aa01da5ddb387462c7eaf61bb78ad"
+
+func TestAPNsSend(t *testing.T) {
+	var key *ecdsa.PrivateKey
+	var tokens []string
+	sender, key := newTestAPNsSender(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/3/device/"+testAPNsDeviceToken, r.URL.Path)
+		verifyAPNsToken(t, &key.PublicKey, r.Header.Get("Authorization"))
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		assert.Equal(t, "co.banano.natriumwallet", r.Header.Get("apns-topic"))
+		assert.Equal(t, "alert", r.Header.Get("apns-push-type"))
+		assert.Equal(t, "10", r.Header.Get("apns-priority"))
+		// Addresses are too long for a collapse ID
+		assert.Equal(t, 64, len(r.Header.Get("apns-collapse-id")))
+
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		json.Unmarshal(body, &payload)
+		assert.Equal(t, map[string]interface{}{
+			"alert": map[string]interface{}{"title": "Received Ӿ1", "body": "Open Natrium to receive this transaction."},
+			"sound": "default",
+		}, payload["aps"])
+		assert.Equal(t, "nano_1natrium1o3z5519ifou7xii8crpxpk8y65qmkih8e8bpsjri651oza8imdd", payload["account"])
+		w.Header().Set("apns-id", "EC1BF194-B3B2-424A-89A5-4D6B5A5B4F2F")
+	})
+
+	msg := &PushMessage{
+		Token:    testAPNsDeviceToken,
+		Platform: dbmodels.PlatformAPNs,
+		Title:    "Received Ӿ1",
+		Body:     "Open Natrium to receive this transaction.",
+		Tag:      "nano_1natrium1o3z5519ifou7xii8crpxpk8y65qmkih8e8bpsjri651oza8imdd",
+		Data:     map[string]string{"account": "nano_1natrium1o3z5519ifou7xii8crpxpk8y65qmkih8e8bpsjri651oza8imdd"},
+	}
+	assert.Nil(t, sender.Send(context.Background(), msg))
+	assert.Nil(t, sender.Send(context.Background(), msg))
+	// The provider token is reused
+	assert.Equal(t, 2, len(tokens))
+	assert.Equal(t, tokens[0], tokens[1])
+}
+
+func TestAPNsSendErrors(t *testing.T) {
+	var status int
+	var reason string
+	var requests atomic.Int32
+	var lastToken string
+	sender, _ := newTestAPNsSender(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		lastToken = r.Header.Get("Authorization")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"reason": "` + reason + `"}`))
+	})
+	msg := &PushMessage{Token: testAPNsDeviceToken, Title: "Received Ӿ1"}
+
+	for _, invalid := range []struct {
+		status int
+		reason string
+	}{{410, "Unregistered"}, {400, "BadDeviceToken"}, {400, "DeviceTokenNotForTopic"}} {
+		status, reason = invalid.status, invalid.reason
+		err := sender.Send(context.Background(), msg)
+		assert.True(t, errors.Is(err, ErrInvalidToken), invalid.reason)
+	}
+
+	status, reason = 429, "TooManyRequests"
+	err := sender.Send(context.Background(), msg)
+	assert.EqualError(t, err, "APNs returned status 429 TooManyRequests")
+	assert.False(t, errors.Is(err, ErrInvalidToken))
+
+	// A rejected provider token is signed again for the next send
+	status, reason = 403, "ExpiredProviderToken"
+	assert.NotNil(t, sender.Send(context.Background(), msg))
+	expired := lastToken
+	status, reason = 200, ""
+	assert.Nil(t, sender.Send(context.Background(), msg))
+	assert.NotEqual(t, expired, lastToken)
+	assert.Equal(t, int32(6), requests.Load())
+
+	// Tokens that aren't hex never make it into a request
+	for _, token := range []string{"devicetoken1", "../../3/device/" + testAPNsDeviceToken, testAPNsDeviceToken[:62], testAPNsDeviceToken + "0"} {
+		err = sender.Send(context.Background(), &PushMessage{Token: token, Title: "Received Ӿ1"})
+		assert.True(t, errors.Is(err, ErrInvalidToken), token)
+	}
+	assert.Equal(t, int32(6), requests.Load())
+}
+
+// recordingPushSender keeps the tokens it's asked to send to
+type recordingPushSender struct {
+	tokens []string
+}
+
+func (r *recordingPushSender) Send(ctx context.Context, msg *PushMessage) error {
+	r.tokens = append(r.tokens, msg.Token)
+	return nil
+}
+
+func TestPushRouter(t *testing.T) {
+	fcm := &recordingPushSender{}
+	apns := &recordingPushSender{}
+	router := PushRouter{dbmodels.PlatformFCM: fcm, dbmodels.PlatformAPNs: apns}
+
+	assert.Nil(t, router.Send(context.Background(), &PushMessage{Token: "fcm1"}))
+	assert.Nil(t, router.Send(context.Background(), &PushMessage{Token: "fcm2", Platform: dbmodels.PlatformFCM}))
+	assert.Nil(t, router.Send(context.Background(), &PushMessage{Token: "apns1", Platform: dbmodels.PlatformAPNs}))
+	assert.Equal(t, []string{"fcm1", "fcm2"}, fcm.tokens)
+	assert.Equal(t, []string{"apns1"}, apns.tokens)
+
+	// No APNs key configured
+	router = PushRouter{dbmodels.PlatformFCM: fcm}
+	err := router.Send(context.Background(), &PushMessage{Token: "apns1", Platform: dbmodels.PlatformAPNs})
+	assert.EqualError(t, err, "No push sender for platform apns")
+	assert.False(t, errors.Is(err, ErrInvalidToken))
+	assert.True(t, errors.Is(err, ErrNoPushSender))
+}
@@ -0,0 +1,57 @@
+package net
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"k8s.io/klog/v2"
+)
+
+// Confirmations are fanned out to every replica through redis pub/sub
+// One replica (the broker) consumes the node websocket and publishes, all replicas subscribe
+
+// PublishCallbacks reads confirmations from the node websocket and publishes them on the channel
+func PublishCallbacks(channel string, nodeChan *chan *WSCallbackMsg) {
+	for msg := range *nodeChan {
+		// Re-serializing the struct normalizes the message, anything we don't use is dropped
+		serialized, err := json.Marshal(msg)
+		if err != nil {
+			klog.Errorf("Error serializing callback for pubsub %v", err)
+			continue
+		}
+		if err := database.GetRedisDB().Publish(channel, serialized); err != nil {
+			klog.Errorf("Error publishing callback %s %v", msg.Hash, err)
+		}
+	}
+}
+
+// SubscribeCallbacks delivers confirmations published on the channel to callbackChan until the context is done
+func SubscribeCallbacks(ctx context.Context, channel string, callbackChan *chan *WSCallbackMsg) {
+	sub := database.GetRedisDB().Subscribe(channel)
+	defer sub.Close()
+	klog.Infof("Subscribed to confirmations on %s", channel)
+
+	// The channel reconnects automatically if redis goes away
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var deserialized WSCallbackMsg
+			if err := json.Unmarshal([]byte(msg.Payload), &deserialized); err != nil {
+				klog.Errorf("Error: decoding pubsub callback to WSCallbackMsg %v", err)
+				continue
+			}
+			select {
+			case *callbackChan <- &deserialized:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
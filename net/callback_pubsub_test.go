@@ -0,0 +1,55 @@
+package net
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallbackPubSub(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+
+	channel := "nano:confirmations:test"
+	nodeChan := make(chan *WSCallbackMsg, 1)
+	callbackChan := make(chan *WSCallbackMsg, 1)
+	go PublishCallbacks(channel, &nodeChan)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go SubscribeCallbacks(ctx, channel, &callbackChan)
+
+	msg := &WSCallbackMsg{
+		IsSend:  "true",
+		Account: "nano_1ipx847tk8o46pwxt5qjdbncjqcbwcc1rrmqnkztrfjy5k7z4imsrata9est",
+		Hash:    "80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F",
+		Amount:  "1000000000000000000000000",
+		Block: WSCallbackBlock{
+			Type:          "state",
+			Subtype:       "send",
+			LinkAsAccount: "nano_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3",
+		},
+	}
+
+	// Subscription happens asynchronously, so keep publishing until it comes through
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case received := <-callbackChan:
+			assert.Equal(t, msg, received)
+			return
+		case <-ticker.C:
+			select {
+			case nodeChan <- msg:
+			default:
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for published callback")
+		}
+	}
+}
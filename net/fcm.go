@@ -0,0 +1,166 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+const fcmEndpoint = "https://fcm.googleapis.com"
+
+// FCMSender sends push notifications with the FCM HTTP v1 API
+type FCMSender struct {
+	ProjectID string
+	// Authorized as the service account
+	Client   HTTPClient
+	Endpoint string
+}
+
+// NewFCMSender authenticates with a service account key, the project defaults to the key's
+func NewFCMSender(credentialsFile string, projectID string) (*FCMSender, error) {
+	contents, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := google.CredentialsFromJSON(context.Background(), contents, fcmScope)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid FCM credentials %s: %w", credentialsFile, err)
+	}
+	if projectID == "" {
+		projectID = creds.ProjectID
+	}
+	if projectID == "" {
+		return nil, fmt.Errorf("No FCM project in %s", credentialsFile)
+	}
+	return &FCMSender{
+		ProjectID: projectID,
+		Client:    oauth2.NewClient(context.Background(), creds.TokenSource),
+		Endpoint:  fcmEndpoint,
+	}, nil
+}
+
+type fcmRequest struct {
+	Message fcmMessage `json:"message"`
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification fcmNotification   `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+	Android      fcmAndroidConfig  `json:"android"`
+	Apns         fcmApnsConfig     `json:"apns"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type fcmAndroidConfig struct {
+	Priority     string                 `json:"priority"`
+	Notification fcmAndroidNotification `json:"notification"`
+}
+
+type fcmAndroidNotification struct {
+	Tag         string `json:"tag,omitempty"`
+	Sound       string `json:"sound"`
+	ClickAction string `json:"click_action"`
+}
+
+type fcmApnsConfig struct {
+	Headers map[string]string      `json:"headers"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+type fcmErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Type      string `json:"@type"`
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func (s *FCMSender) Send(ctx context.Context, msg *PushMessage) error {
+	apnsHeaders := map[string]string{"apns-priority": "10"}
+	if msg.Tag != "" {
+		apnsHeaders["apns-collapse-id"] = apnsCollapseID(msg.Tag)
+	}
+	requestBody, err := json.Marshal(fcmRequest{
+		Message: fcmMessage{
+			Token:        msg.Token,
+			Notification: fcmNotification{Title: msg.Title, Body: msg.Body},
+			Data:         msg.Data,
+			Android: fcmAndroidConfig{
+				Priority: "high",
+				Notification: fcmAndroidNotification{
+					Tag:         msg.Tag,
+					Sound:       "default",
+					ClickAction: "FLUTTER_NOTIFICATION_CLICK",
+				},
+			},
+			Apns: fcmApnsConfig{
+				Headers: apnsHeaders,
+				Payload: map[string]interface{}{"aps": map[string]interface{}{"sound": "default"}},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v1/projects/%s/messages:send", s.Endpoint, s.ProjectID)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return fcmError(resp.StatusCode, body)
+}
+
+// fcmError wraps ErrInvalidToken when FCM says the token is gone or was never valid
+func fcmError(statusCode int, body []byte) error {
+	var errorResponse fcmErrorResponse
+	if err := json.Unmarshal(body, &errorResponse); err != nil {
+		return fmt.Errorf("FCM returned status %d", statusCode)
+	}
+	errorCode := errorResponse.Error.Status
+	for _, detail := range errorResponse.Error.Details {
+		if detail.ErrorCode != "" {
+			errorCode = detail.ErrorCode
+		}
+	}
+	switch errorCode {
+	case "UNREGISTERED", "SENDER_ID_MISMATCH":
+		return fmt.Errorf("%w: %s %s", ErrInvalidToken, errorCode, errorResponse.Error.Message)
+	case "INVALID_ARGUMENT":
+		// The rest of the message is always the same, so only trust it when it's about the token
+		if strings.Contains(strings.ToLower(errorResponse.Error.Message), "registration token") {
+			return fmt.Errorf("%w: %s %s", ErrInvalidToken, errorCode, errorResponse.Error.Message)
+		}
+	}
+	return fmt.Errorf("FCM returned status %d %s %s", statusCode, errorCode, errorResponse.Error.Message)
+}
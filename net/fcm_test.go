@@ -0,0 +1,110 @@
+package net
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appditto/natrium-wallet-server/utils/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeServiceAccount(t *testing.T, projectID string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	contents, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   projectID,
+		"private_key":  string(keyPem),
+		"client_email": "push@natrium.iam.gserviceaccount.com",
+		"token_uri":    "https://oauth2.googleapis.com/token",
+	})
+	path := filepath.Join(t.TempDir(), "service-account.json")
+	assert.Nil(t, os.WriteFile(path, contents, 0600))
+	return path
+}
+
+func TestNewFCMSender(t *testing.T) {
+	sender, err := NewFCMSender(writeServiceAccount(t, "natrium"), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "natrium", sender.ProjectID)
+	sender, err = NewFCMSender(writeServiceAccount(t, "natrium"), "kalium")
+	assert.Nil(t, err)
+	assert.Equal(t, "kalium", sender.ProjectID)
+
+	_, err = NewFCMSender(writeServiceAccount(t, ""), "")
+	assert.NotNil(t, err)
+	_, err = NewFCMSender(filepath.Join(t.TempDir(), "missing.json"), "")
+	assert.NotNil(t, err)
+}
+
+func TestFCMSend(t *testing.T) {
+	sender := &FCMSender{ProjectID: "natrium", Client: &mocks.MockClient{}, Endpoint: fcmEndpoint}
+	var sent map[string]interface{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "https://fcm.googleapis.com/v1/projects/natrium/messages:send", req.URL.String())
+		body, _ := io.ReadAll(req.Body)
+		json.Unmarshal(body, &sent)
+		return mockJsonResponse("{\"name\": \"projects/natrium/messages/1\"}"), nil
+	}
+	err := sender.Send(context.Background(), &PushMessage{
+		Token: "token1",
+		Title: "Received Ӿ1",
+		Body:  "Open Natrium to receive this transaction.",
+		Tag:   "nano_1account",
+		Data:  map[string]string{"account": "nano_1account"},
+	})
+	assert.Nil(t, err)
+	message := sent["message"].(map[string]interface{})
+	assert.Equal(t, "token1", message["token"])
+	assert.Equal(t, map[string]interface{}{"title": "Received Ӿ1", "body": "Open Natrium to receive this transaction."}, message["notification"])
+	assert.Equal(t, map[string]interface{}{"account": "nano_1account"}, message["data"])
+	android := message["android"].(map[string]interface{})
+	assert.Equal(t, "high", android["priority"])
+	assert.Equal(t, "nano_1account", android["notification"].(map[string]interface{})["tag"])
+	apns := message["apns"].(map[string]interface{})
+	assert.Equal(t, "nano_1account", apns["headers"].(map[string]interface{})["apns-collapse-id"])
+}
+
+func TestFCMSendErrors(t *testing.T) {
+	sender := &FCMSender{ProjectID: "natrium", Client: &mocks.MockClient{}, Endpoint: fcmEndpoint}
+	respond := func(status int, body string) {
+		mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+			resp := mockJsonResponse(body)
+			resp.StatusCode = status
+			return resp, nil
+		}
+	}
+
+	respond(404, `{"error": {"code": 404, "message": "Requested entity was not found.", "status": "NOT_FOUND", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`)
+	err := sender.Send(context.Background(), &PushMessage{Token: "token1"})
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+
+	respond(400, `{"error": {"code": 400, "message": "The registration token is not a valid FCM registration token", "status": "INVALID_ARGUMENT", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "INVALID_ARGUMENT"}]}}`)
+	err = sender.Send(context.Background(), &PushMessage{Token: "token1"})
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+
+	// Anything else isn't the token's fault
+	respond(400, `{"error": {"code": 400, "message": "Invalid JSON payload received.", "status": "INVALID_ARGUMENT"}}`)
+	err = sender.Send(context.Background(), &PushMessage{Token: "token1"})
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrInvalidToken))
+
+	respond(503, `{"error": {"code": 503, "message": "The service is currently unavailable.", "status": "UNAVAILABLE"}}`)
+	err = sender.Send(context.Background(), &PushMessage{Token: "token1"})
+	assert.EqualError(t, err, "FCM returned status 503 UNAVAILABLE The service is currently unavailable.")
+
+	respond(502, "<html>Bad Gateway</html>")
+	err = sender.Send(context.Background(), &PushMessage{Token: "token1"})
+	assert.EqualError(t, err, "FCM returned status 502")
+}
@@ -3,11 +3,10 @@ package net
 import (
 	"context"
 	"encoding/json"
-	"os"
-	"os/signal"
-	"syscall"
+	"sync/atomic"
 	"time"
 
+	"github.com/appditto/natrium-wallet-server/metrics"
 	guuid "github.com/google/uuid"
 	"github.com/recws-org/recws"
 	"k8s.io/klog/v2"
@@ -50,8 +49,16 @@ type WSCallbackMsg struct {
 	Amount  string          `json:"amount"`
 }
 
-func StartNanoWSClient(wsUrl string, callbackChan *chan *WSCallbackMsg) {
-	ctx, cancel := context.WithCancel(context.Background())
+// Whether the node websocket is connected and subscribed, for readiness checks
+var nodeWebsocketConnected atomic.Bool
+
+// NodeWebsocketConnected returns whether StartNanoWSClient is connected to the node
+func NodeWebsocketConnected() bool {
+	return nodeWebsocketConnected.Load()
+}
+
+// StartNanoWSClient delivers confirmations from the node websocket to callbackChan until the context is done
+func StartNanoWSClient(ctx context.Context, wsUrl string, callbackChan *chan *WSCallbackMsg) {
 	sentSubscribe := false
 	ws := recws.RecConn{}
 	// Nano subscription request
@@ -68,32 +75,33 @@ func StartNanoWSClient(wsUrl string, callbackChan *chan *WSCallbackMsg) {
 		// },
 	}
 	ws.Dial(wsUrl, nil)
-
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc,
-		syscall.SIGHUP,
-		syscall.SIGINT,
-		syscall.SIGTERM,
-		syscall.SIGQUIT)
+	connected := metrics.NodeWebsocketConnected.WithLabelValues(metrics.NodeLabel(wsUrl))
+	confirmations := metrics.NodeWebsocketConfirmations.WithLabelValues(metrics.NodeLabel(wsUrl))
 	defer func() {
-		signal.Stop(sigc)
-		cancel()
+		connected.Set(0)
+		nodeWebsocketConnected.Store(false)
+	}()
+	// Reads block, closing the connection is what interrupts them
+	go func() {
+		<-ctx.Done()
+		if ws.IsConnected() {
+			ws.Shutdown(time.Second)
+		}
 	}()
 
 	for {
 		select {
-		case <-sigc:
-			cancel()
-			return
 		case <-ctx.Done():
-			go ws.Close()
+			ws.Close()
 			klog.Infof("Websocket closed %s", ws.GetURL())
 			return
 		default:
 			if !ws.IsConnected() {
+				connected.Set(0)
+				nodeWebsocketConnected.Store(false)
 				sentSubscribe = false
 				klog.Infof("Websocket disconnected %s", ws.GetURL())
-				time.Sleep(2 * time.Second)
+				wait(ctx, 2*time.Second)
 				continue
 			}
 
@@ -101,10 +109,12 @@ func StartNanoWSClient(wsUrl string, callbackChan *chan *WSCallbackMsg) {
 			if !sentSubscribe {
 				if err := ws.WriteJSON(subRequest); err != nil {
 					klog.Infof("Error sending subscribe request %s", ws.GetURL())
-					time.Sleep(2 * time.Second)
+					wait(ctx, 2*time.Second)
 					continue
 				} else {
 					sentSubscribe = true
+					connected.Set(1)
+					nodeWebsocketConnected.Store(true)
 				}
 			}
 
@@ -118,6 +128,7 @@ func StartNanoWSClient(wsUrl string, callbackChan *chan *WSCallbackMsg) {
 
 			// Trigger callback
 			if confMessage.Topic == "confirmation" {
+				confirmations.Inc()
 				var deserialized WSCallbackMsg
 				serialized, err := json.Marshal(confMessage.Message)
 				if err != nil {
@@ -128,8 +139,19 @@ func StartNanoWSClient(wsUrl string, callbackChan *chan *WSCallbackMsg) {
 					klog.Errorf("Error: decoding the callback to WSCallbackMsg %v", err)
 					continue
 				}
-				*callbackChan <- &deserialized
+				select {
+				case *callbackChan <- &deserialized:
+				case <-ctx.Done():
+				}
 			}
 		}
 	}
 }
+
+// wait sleeps for the duration, or until the context is done
+func wait(ctx context.Context, duration time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+}
@@ -0,0 +1,68 @@
+package net
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNanoWSClientStopsWithContext(t *testing.T) {
+	// Stand in for the node, sends a confirmation once subscribed
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var subscribe wsSubscribe
+		if err := conn.ReadJSON(&subscribe); err != nil || subscribe.Topic != "confirmation" {
+			return
+		}
+		conn.WriteJSON(map[string]interface{}{
+			"topic": "confirmation",
+			"message": map[string]interface{}{
+				"account": "nano_1ipx847tk8o46pwxt5qjdbncjqcbwcc1rrmqnkztrfjy5k7z4imsrata9est",
+				"hash":    "80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F",
+			},
+		})
+		// Until the client closes
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	callbackChan := make(chan *WSCallbackMsg, 1)
+	done := make(chan struct{})
+	go func() {
+		StartNanoWSClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &callbackChan)
+		close(done)
+	}()
+
+	select {
+	case msg := <-callbackChan:
+		assert.Equal(t, "80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F", msg.Hash)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for confirmation")
+	}
+	assert.True(t, NodeWebsocketConnected())
+
+	// Blocked reading the next message, cancelling still stops it
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for client to stop")
+	}
+	assert.False(t, NodeWebsocketConnected())
+}
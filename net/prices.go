@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/appditto/natrium-wallet-server/config"
 	"github.com/appditto/natrium-wallet-server/database"
@@ -45,6 +46,55 @@ func MakeGetRequest(url string) ([]byte, error) {
 	return body, nil
 }
 
+// Unix time each price was last set, so stale prices can be noticed
+const pricesUpdatedKey = "prices_updated"
+
+// setPrice stores a price along with when it was updated
+func setPrice(field string, value interface{}) error {
+	if err := database.GetRedisDB().Hset("prices", field, value); err != nil {
+		return err
+	}
+	return database.GetRedisDB().Hset(pricesUpdatedKey, field, time.Now().Unix())
+}
+
+// PriceAges returns how long ago each coingecko price for the prefix was updated, by currency
+func PriceAges(pricePrefix string) (map[string]time.Duration, error) {
+	updated, err := database.GetRedisDB().Hgetall(pricesUpdatedKey)
+	if err != nil {
+		return nil, err
+	}
+	fieldPrefix := fmt.Sprintf("coingecko:%s-", pricePrefix)
+	ages := make(map[string]time.Duration)
+	for field, timestamp := range updated {
+		if !strings.HasPrefix(field, fieldPrefix) {
+			continue
+		}
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		ages[strings.TrimPrefix(field, fieldPrefix)] = time.Since(time.Unix(unix, 0))
+	}
+	return ages, nil
+}
+
+// CheckPriceFreshness errors if there are no prices for the prefix or the oldest is older than maxAge
+func CheckPriceFreshness(pricePrefix string, maxAge time.Duration) error {
+	ages, err := PriceAges(pricePrefix)
+	if err != nil {
+		return err
+	}
+	if len(ages) == 0 {
+		return fmt.Errorf("No %s prices", pricePrefix)
+	}
+	for currency, age := range ages {
+		if age > maxAge {
+			return fmt.Errorf("%s price is %s old", currency, age.Truncate(time.Second))
+		}
+	}
+	return nil
+}
+
 // DolarTodayResponse structure based on expected JSON response
 func UpdateDolarTodayPrice() error {
 	// Data to be sent in POST request
@@ -98,7 +148,7 @@ func UpdateDolarTodayPrice() error {
 
 	fmt.Printf("%s %s\n", "DolarToday USD-VES:", match)
 
-	database.GetRedisDB().Hset("prices", "dolartoday:usd-ves", match)
+	setPrice("dolartoday:usd-ves", match)
 
 	return nil
 }
@@ -126,7 +176,7 @@ func UpdateDolarSiPrice() error {
 	price_ars := strings.ReplaceAll(dolarsiResponse[1].Casa.Venta, ".", "")
 	price_ars = strings.ReplaceAll(price_ars, ",", ".")
 	fmt.Printf("%s %s\n", "DolarSi USD-ARS", price_ars)
-	database.GetRedisDB().Hset("prices", "dolarsi:usd-ars", price_ars)
+	setPrice("dolarsi:usd-ars", price_ars)
 
 	return nil
 }
@@ -147,7 +197,7 @@ func UpdateNanoCoingeckoPrices() error {
 		data_name := strings.ToLower(currency)
 		if val, ok := cgResp.MarketData.CurrentPrice[data_name]; ok {
 			fmt.Printf("%s %f\n", "Coingecko NANO-"+currency, val)
-			database.GetRedisDB().Hset("prices", "coingecko:nano-"+data_name, val)
+			setPrice("coingecko:nano-"+data_name, val)
 		} else {
 			klog.Errorf("Error getting coingecko price for %s", data_name)
 		}
@@ -174,7 +224,7 @@ func UpdateNanoCoingeckoPrices() error {
 		return err
 	}
 	convertedves := usdPriceFloat * bolivarPriceFloat
-	if err := database.GetRedisDB().Hset("prices", "coingecko:nano-ves", convertedves); err != nil {
+	if err := setPrice("coingecko:nano-ves", convertedves); err != nil {
 		klog.Errorf("Error setting coingecko price for nano-ves %s", err)
 		return err
 	}
@@ -192,7 +242,7 @@ func UpdateNanoCoingeckoPrices() error {
 		return err
 	}
 	convertedars := usdPriceFloat * arsPriceFloat
-	if err := database.GetRedisDB().Hset("prices", "coingecko:nano-ars", convertedars); err != nil {
+	if err := setPrice("coingecko:nano-ars", convertedars); err != nil {
 		klog.Errorf("Error setting coingecko price for nano-ves %s", err)
 		return err
 	}
@@ -217,7 +267,7 @@ func UpdateBananoCoingeckoPrices() error {
 		data_name := strings.ToLower(currency)
 		if val, ok := cgResp.MarketData.CurrentPrice[data_name]; ok {
 			fmt.Printf("%s %f\n", "Coingecko BANANO-"+currency, val)
-			database.GetRedisDB().Hset("prices", "coingecko:banano-"+data_name, val)
+			setPrice("coingecko:banano-"+data_name, val)
 		} else {
 			klog.Errorf("Error getting coingecko price for %s", data_name)
 		}
@@ -244,7 +294,7 @@ func UpdateBananoCoingeckoPrices() error {
 		return err
 	}
 	convertedves := usdPriceFloat * bolivarPriceFloat
-	if err := database.GetRedisDB().Hset("prices", "coingecko:banano-ves", convertedves); err != nil {
+	if err := setPrice("coingecko:banano-ves", convertedves); err != nil {
 		klog.Errorf("Error setting coingecko price for banano-ves %s", err)
 		return err
 	}
@@ -262,7 +312,7 @@ func UpdateBananoCoingeckoPrices() error {
 		return err
 	}
 	convertedars := usdPriceFloat * arsPriceFloat
-	if err := database.GetRedisDB().Hset("prices", "coingecko:banano-ars", convertedars); err != nil {
+	if err := setPrice("coingecko:banano-ars", convertedars); err != nil {
 		klog.Errorf("Error setting coingecko price for banano-ves %s", err)
 		return err
 	}
@@ -282,7 +332,7 @@ func UpdateBananoCoingeckoPrices() error {
 		return err
 	}
 	nanoBanPrice := cgResp.MarketData.CurrentPrice["btc"] / nanopriceFloat
-	if err := database.GetRedisDB().Hset("prices", "coingecko:banano-nano", nanoBanPrice); err != nil {
+	if err := setPrice("coingecko:banano-nano", nanoBanPrice); err != nil {
 		klog.Errorf("Error setting price for banano-nano %s", err)
 		return err
 	}
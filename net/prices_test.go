@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/appditto/natrium-wallet-server/database"
 	"github.com/appditto/natrium-wallet-server/utils/mocks"
@@ -60,6 +61,17 @@ func TestUpdateNanoPrice(t *testing.T) {
 	err := UpdateNanoCoingeckoPrices()
 	assert.Equal(t, nil, err)
 
+	// Every price's update time is kept
+	ages, err := PriceAges("nano")
+	assert.Equal(t, nil, err)
+	assert.Contains(t, ages, "usd")
+	assert.Less(t, ages["usd"], time.Minute)
+	assert.Equal(t, nil, CheckPriceFreshness("nano", time.Minute))
+	// Updated longer ago than we accept
+	database.GetRedisDB().Hset("prices_updated", "coingecko:nano-usd", time.Now().Add(-time.Hour).Unix())
+	assert.NotEqual(t, nil, CheckPriceFreshness("nano", time.Minute))
+	assert.NotEqual(t, nil, CheckPriceFreshness("nothing", time.Minute))
+
 	for _, v := range CurrencyList {
 		price, err := database.GetRedisDB().Hget("prices", fmt.Sprintf("coingecko:nano-%s", strings.ToLower(v)))
 		assert.Equal(t, nil, err)
@@ -0,0 +1,58 @@
+package net
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+)
+
+// ErrInvalidToken means the token will never work again, so it should be forgotten
+var ErrInvalidToken = errors.New("Invalid push token")
+
+// ErrNoPushSender means the platform isn't configured, so retrying won't help
+var ErrNoPushSender = errors.New("No push sender")
+
+// PushMessage is a notification for a single device
+type PushMessage struct {
+	Token string
+	// Which sender the token belongs to, FCM if empty
+	Platform string
+	Title    string
+	Body     string
+	// Notifications with the same tag replace each other on the device
+	Tag  string
+	Data map[string]string
+}
+
+// PushSender sends push notifications, errors wrap ErrInvalidToken when the token should be removed
+type PushSender interface {
+	Send(ctx context.Context, msg *PushMessage) error
+}
+
+// PushRouter sends each message with the sender for its platform
+type PushRouter map[string]PushSender
+
+func (r PushRouter) Send(ctx context.Context, msg *PushMessage) error {
+	platform := msg.Platform
+	if platform == "" {
+		platform = dbmodels.PlatformFCM
+	}
+	sender, ok := r[platform]
+	if !ok {
+		return fmt.Errorf("%w for platform %s", ErrNoPushSender, platform)
+	}
+	return sender.Send(ctx, msg)
+}
+
+// APNs collapse IDs can be at most 64 bytes, so longer tags like nano addresses are hashed
+func apnsCollapseID(tag string) string {
+	if len(tag) <= 64 {
+		return tag
+	}
+	hash := sha256.Sum256([]byte(tag))
+	return hex.EncodeToString(hash[:])
+}
@@ -7,37 +7,81 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/appditto/natrium-wallet-server/gql"
+	"github.com/appditto/natrium-wallet-server/config"
+	"github.com/appditto/natrium-wallet-server/metrics"
 	"github.com/appditto/natrium-wallet-server/models"
-	"github.com/appditto/natrium-wallet-server/utils"
 	"k8s.io/klog/v2"
 )
 
 type RPCClient struct {
-	Url        string
-	BpowClient *gql.BpowClient
+	Url           string
+	WorkGenerator *WorkGenerator
+	// When set, requests are routed to a healthy node in the pool instead of Url
+	Nodes      []*RPCNode
+	MaxSyncLag uint64
+	// How long a request to Url can take, DefaultNodeTimeout if it's not set
+	Timeout time.Duration
+}
+
+// NewRPCClient creates a client for the configured node, or pool of nodes
+func NewRPCClient(cfg config.RPCConfig) (*RPCClient, error) {
+	client := &RPCClient{Url: cfg.Url, MaxSyncLag: cfg.MaxSyncLag, Timeout: cfg.Timeout.Duration()}
+	if len(cfg.Nodes) > 0 {
+		nodes, err := ParseRPCNodes(strings.Join(cfg.Nodes, ","))
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			if cfg.Timeout > 0 {
+				node.Timeout = cfg.Timeout.Duration()
+			}
+		}
+		client.Nodes = nodes
+	}
+	return client, nil
 }
 
 // Base request
 func (client *RPCClient) MakeRequest(request interface{}) ([]byte, error) {
 	requestBody, _ := json.Marshal(request)
+	action := requestAction(requestBody)
+	if len(client.Nodes) > 0 {
+		body, err := client.makePoolRequest(action, requestBody)
+		if err != nil {
+			klog.Errorf("Error making RPC request %s", err)
+			return nil, err
+		}
+		return body, nil
+	}
+	timeout := client.Timeout
+	if timeout <= 0 {
+		timeout = DefaultNodeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	// HTTP post
-	httpRequest, err := http.NewRequest(http.MethodPost, client.Url, bytes.NewBuffer(requestBody))
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Url, bytes.NewBuffer(requestBody))
 	if err != nil {
 		klog.Errorf("Error building request %s", err)
 		return nil, err
 	}
 	httpRequest.Header.Add("Content-Type", "application/json")
+	start := time.Now()
 	resp, err := Client.Do(httpRequest)
 	if err != nil {
+		metrics.ObserveRPC(action, client.Url, start, err)
 		klog.Errorf("Error making RPC request %s", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 	// Try to decode+deserialize
 	body, err := io.ReadAll(resp.Body)
+	metrics.ObserveRPC(action, client.Url, start, err)
 	if err != nil {
 		klog.Errorf("Error decoding response body %s", err)
 		return nil, err
@@ -45,6 +89,17 @@ func (client *RPCClient) MakeRequest(request interface{}) ([]byte, error) {
 	return body, nil
 }
 
+// The action of a serialized request, for metrics
+func requestAction(requestBody []byte) string {
+	var request struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(requestBody, &request); err != nil || request.Action == "" {
+		return "unknown"
+	}
+	return request.Action
+}
+
 func (client *RPCClient) MakeAccountInfoRequest(account string) (map[string]interface{}, error) {
 	request := models.AccountInfoAction{
 		Action:         "account_info",
@@ -78,12 +133,40 @@ func (client *RPCClient) MakeAccountInfoRequest(account string) (map[string]inte
 	return responseMap, nil
 }
 
-// This returns how many pending blocks an account has, up to 51, for anti-spam measures
-func (client *RPCClient) GetReceivableCount(account string, bananoMode bool) (int, error) {
-	threshold := "1000000000000000000000000"
+// MakeAccountsInfoRequest gets account_info for several accounts at once
+// Accounts that errored are left out of the result
+func (client *RPCClient) MakeAccountsInfoRequest(accounts []string) map[string]map[string]interface{} {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	infos := make(map[string]map[string]interface{}, len(accounts))
+	for _, account := range accounts {
+		wg.Add(1)
+		go func(account string) {
+			defer wg.Done()
+			accountInfo, err := client.MakeAccountInfoRequest(account)
+			if err != nil || accountInfo == nil {
+				return
+			}
+			mutex.Lock()
+			infos[account] = accountInfo
+			mutex.Unlock()
+		}(account)
+	}
+	wg.Wait()
+	return infos
+}
+
+// Ignore receivable blocks below this, they're likely spam
+func receivableThreshold(bananoMode bool) string {
 	if bananoMode {
-		threshold = "1000000000000000000000000000"
+		return "1000000000000000000000000000"
 	}
+	return "1000000000000000000000000"
+}
+
+// This returns how many pending blocks an account has, up to 51, for anti-spam measures
+func (client *RPCClient) GetReceivableCount(account string, bananoMode bool) (int, error) {
+	threshold := receivableThreshold(bananoMode)
 	request := models.ReceivableRequest{
 		Action:               "receivable",
 		Account:              account,
@@ -106,6 +189,33 @@ func (client *RPCClient) GetReceivableCount(account string, bananoMode bool) (in
 	return len(parsed.Blocks), nil
 }
 
+// GetReceivableCounts is GetReceivableCount for several accounts in one request
+func (client *RPCClient) GetReceivableCounts(accounts []string, bananoMode bool) (map[string]int, error) {
+	request := models.AccountsReceivableRequest{
+		Action:               "accounts_receivable",
+		Accounts:             accounts,
+		Threshold:            receivableThreshold(bananoMode),
+		Count:                51,
+		IncludeOnlyConfirmed: true,
+	}
+	response, err := client.MakeRequest(request)
+	if err != nil {
+		klog.Errorf("Error making request %s", err)
+		return nil, err
+	}
+	var parsed models.AccountsReceivableResponse
+	err = json.Unmarshal(response, &parsed)
+	if err != nil {
+		klog.Errorf("Error unmarshalling response %s", err)
+		return nil, err
+	}
+	counts := make(map[string]int, len(accounts))
+	for _, account := range accounts {
+		counts[account] = len(parsed.Blocks[account])
+	}
+	return counts, nil
+}
+
 func (client *RPCClient) MakeBlockRequest(hash string) (models.BlockResponse, error) {
 	request := models.BlockRequest{
 		Action:    "block_info",
@@ -126,135 +236,27 @@ func (client *RPCClient) MakeBlockRequest(hash string) (models.BlockResponse, er
 	return blockResponse, nil
 }
 
-type workResult struct {
-	result string
-	source string // "bpowClient" or "httpRequest"
-}
-
-func (client *RPCClient) WorkGenerate(hash string, difficultyMultiplier int) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	chanSize := 0
-	if client.BpowClient != nil {
-		chanSize++
-	}
-	if utils.GetEnv("WORK_URL", "") != "" {
-		chanSize++
-	}
-
-	if chanSize == 0 {
-		return "", fmt.Errorf("No work providers available")
-	}
-
-	results := make(chan workResult, chanSize)
-	errors := make(chan error, chanSize)
-
-	if client.BpowClient != nil {
-		go func() {
-			res, err := client.BpowClient.WorkGenerate(hash, difficultyMultiplier)
-			if err != nil {
-				errors <- err
-				return
-			}
-			results <- workResult{result: res, source: "bpowClient"}
-		}()
-	}
-
-	workURL := utils.GetEnv("WORK_URL", "")
-	if workURL != "" {
-		go client.httpWorkGenerate(ctx, hash, difficultyMultiplier, results, errors)
-	}
-
-	select {
-	case res := <-results:
-		if res.source != "httpRequest" {
-			client.sendWorkCancel(hash) // Only send work cancel if the result did not come from HTTP request
-		}
-		return res.result, nil
-	case err := <-errors:
-		return "", err
-	case <-ctx.Done():
-		return "", ctx.Err()
-	}
-}
-
-func (client *RPCClient) httpWorkGenerate(ctx context.Context, hash string, difficultyMultiplier int, results chan<- workResult, errors chan<- error) {
-	difficulty := "fffffff800000000"
-	if difficultyMultiplier < 64 {
-		difficulty = "fffffe0000000000"
-	}
-
-	request := models.WorkGenerate{
-		Action:     "work_generate",
-		Hash:       hash,
-		Difficulty: difficulty,
-	}
-
-	requestBody, _ := json.Marshal(request)
-	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, utils.GetEnv("WORK_URL", ""), bytes.NewBuffer(requestBody))
+// BlockCount returns the node's block count, goes through the pool if there is one
+func (client *RPCClient) BlockCount() (uint64, error) {
+	response, err := client.MakeRequest(map[string]string{"action": "block_count"})
 	if err != nil {
-		errors <- err
-		return
+		return 0, err
 	}
-	httpRequest.Header.Add("Content-Type", "application/json")
-
-	resp, err := Client.Do(httpRequest)
-	if err != nil {
-		errors <- err
-		return
+	var blockCount models.BlockCountResponse
+	if err := json.Unmarshal(response, &blockCount); err != nil {
+		return 0, err
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	count, err := strconv.ParseUint(blockCount.Count, 10, 64)
 	if err != nil {
-		errors <- err
-		return
-	}
-
-	var workResp models.WorkResponse
-	if err := json.Unmarshal(body, &workResp); err != nil {
-		errors <- err
-		return
-	}
-
-	// Send the result along with the source identifier
-	results <- workResult{
-		result: workResp.Work,
-		source: "httpRequest",
+		return 0, fmt.Errorf("Invalid block_count response")
 	}
+	return count, nil
 }
 
-func (client *RPCClient) sendWorkCancel(hash string) {
-	workURL := utils.GetEnv("WORK_URL", "")
-	if workURL == "" {
-		// If WORK_URL is not set, do not proceed with the HTTP request
-		return
-	}
-
-	// Construct the request for work cancellation
-	request := models.WorkGenerate{
-		Action: "work_cancel",
-		Hash:   hash,
-	}
-
-	requestBody, err := json.Marshal(request)
-	if err != nil {
-		klog.Errorf("Error marshalling work cancel request: %s", err)
-		return
-	}
-
-	httpRequest, err := http.NewRequest(http.MethodPost, workURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		klog.Errorf("Error creating work cancel request: %s", err)
-		return
-	}
-	httpRequest.Header.Add("Content-Type", "application/json")
-
-	resp, err := Client.Do(httpRequest)
-	if err != nil {
-		klog.Errorf("Error sending work cancel request: %s", err)
-		return
+// WorkGenerate gets work for a hash from the configured work providers
+func (client *RPCClient) WorkGenerate(hash string, difficultyMultiplier int) (string, error) {
+	if client.WorkGenerator == nil {
+		return "", fmt.Errorf("No work providers available")
 	}
-	defer resp.Body.Close()
+	return client.WorkGenerator.WorkGenerate(hash, difficultyMultiplier)
 }
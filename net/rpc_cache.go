@@ -0,0 +1,204 @@
+package net
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"k8s.io/klog/v2"
+)
+
+// Read-only actions we cache in redis, and for how long
+// Responses about settled blocks are cached for a day, not indefinitely, see settledBlockTTL
+var cachedActions = map[string]time.Duration{
+	"block_info":             1 * time.Minute,
+	"blocks_info":            1 * time.Minute,
+	"representatives":        5 * time.Minute,
+	"available_supply":       5 * time.Minute,
+	"version":                1 * time.Minute,
+	"frontier_count":         30 * time.Second,
+	"account_representative": 5 * time.Minute,
+}
+
+const rpcCachePrefix = "rpc_cache"
+
+// Confirmed blocks with a successor don't change anymore, so they're cached for longer
+// They'd never have to expire, but then redis would keep every block anyone ever asked about
+const settledBlockTTL = 24 * time.Hour
+
+// Account indexes expire with the longest lived response they can hold, settled blocks are only indexed with receivableOptions
+const rpcCacheIndexTTL = 5 * time.Minute
+
+// The successor of a block that doesn't have one yet
+const noSuccessor = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// settledBlock is whether a block_info or blocks_info entry can't change anymore
+func settledBlock(block map[string]interface{}) bool {
+	successor, _ := block["successor"].(string)
+	return block["confirmed"] == "true" && successor != "" && successor != noSuccessor
+}
+
+// With these a block's entry also depends on whether the account it sent to has received it
+var receivableOptions = []string{"receivable", "pending", "source"}
+
+func withReceivable(request map[string]interface{}) bool {
+	for _, option := range receivableOptions {
+		if enabled, _ := strconv.ParseBool(fmt.Sprintf("%v", request[option])); enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// blockAccounts are the accounts whose new blocks can change a block_info or blocks_info entry
+func blockAccounts(block map[string]interface{}, receivable bool) []string {
+	var accounts []string
+	if account, ok := block["block_account"].(string); ok {
+		accounts = append(accounts, account)
+	}
+	if !receivable {
+		return accounts
+	}
+	if contents, ok := block["contents"].(map[string]interface{}); ok {
+		if link, ok := contents["link_as_account"].(string); ok && link != "" {
+			accounts = append(accounts, link)
+		}
+	}
+	return accounts
+}
+
+// Set of cache keys that depend on an account, so they can be dropped when it gets a new block
+func rpcCacheAccountKey(account string) string {
+	return fmt.Sprintf("%s:account:%s", rpcCachePrefix, account)
+}
+
+// rpcCacheKey builds a key from the canonicalized request
+func rpcCacheKey(action string, request map[string]interface{}) (string, error) {
+	canonical := make(map[string]interface{}, len(request))
+	for k, v := range request {
+		canonical[k] = v
+	}
+	canonical["action"] = action
+	// Hashes are case insensitive to the node
+	if hash, ok := canonical["hash"].(string); ok {
+		canonical["hash"] = strings.ToUpper(hash)
+	}
+	if hashes, ok := canonical["hashes"].([]interface{}); ok {
+		upper := make([]interface{}, len(hashes))
+		for i, hash := range hashes {
+			upper[i] = strings.ToUpper(fmt.Sprintf("%v", hash))
+		}
+		canonical["hashes"] = upper
+	}
+	// json.Marshal sorts map keys, so equivalent requests serialize the same way
+	serialized, err := json.Marshal(canonical)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(serialized)
+	return fmt.Sprintf("%s:%s:%s", rpcCachePrefix, action, hex.EncodeToString(sum[:])), nil
+}
+
+// MakeCachedRequest serves read-only actions from the cache, other actions go straight to the node
+func (client *RPCClient) MakeCachedRequest(request map[string]interface{}) ([]byte, error) {
+	action := strings.ToLower(fmt.Sprintf("%v", request["action"]))
+	ttl, ok := cachedActions[action]
+	if !ok {
+		return client.MakeRequest(request)
+	}
+	key, err := rpcCacheKey(action, request)
+	if err != nil {
+		return client.MakeRequest(request)
+	}
+
+	if cached, err := database.GetRedisDB().Get(key); err == nil {
+		return []byte(cached), nil
+	}
+
+	response, err := client.MakeRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := cacheResponse(action, key, ttl, request, response); err != nil {
+		klog.Errorf("Error caching %s response %v", action, err)
+	}
+	return response, nil
+}
+
+func cacheResponse(action string, key string, ttl time.Duration, request map[string]interface{}, response []byte) error {
+	var responseMap map[string]interface{}
+	if err := json.Unmarshal(response, &responseMap); err != nil {
+		return err
+	}
+	// Never cache errors
+	if _, ok := responseMap["error"]; ok {
+		return nil
+	}
+
+	// Accounts whose new blocks invalidate this response
+	var accounts []string
+	switch action {
+	case "account_representative":
+		if account, ok := request["account"].(string); ok {
+			accounts = append(accounts, account)
+		}
+	case "block_info":
+		// The successor and confirmation change until it's settled, when the account gets a new block
+		// Whether it's receivable changes when the account it sent to gets one, even after it's settled
+		receivable := withReceivable(request)
+		if settledBlock(responseMap) && !receivable {
+			ttl = settledBlockTTL
+		} else {
+			accounts = append(accounts, blockAccounts(responseMap, receivable)...)
+		}
+	case "blocks_info":
+		receivable := withReceivable(request)
+		blocks, ok := responseMap["blocks"].(map[string]interface{})
+		allSettled := ok && len(blocks) > 0
+		for _, block := range blocks {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				allSettled = false
+				continue
+			}
+			if settledBlock(blockMap) && !receivable {
+				continue
+			}
+			allSettled = false
+			accounts = append(accounts, blockAccounts(blockMap, receivable)...)
+		}
+		if allSettled {
+			ttl = settledBlockTTL
+		}
+	}
+
+	if err := database.GetRedisDB().Set(key, string(response), ttl); err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		indexKey := rpcCacheAccountKey(account)
+		if err := database.GetRedisDB().Sadd(indexKey, key); err != nil {
+			return err
+		}
+		if err := database.GetRedisDB().Expire(indexKey, rpcCacheIndexTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateAccountCache drops every cached response that depends on the account
+func InvalidateAccountCache(account string) error {
+	indexKey := rpcCacheAccountKey(account)
+	keys, err := database.GetRedisDB().Smembers(indexKey)
+	if err != nil {
+		return err
+	}
+	_, err = database.GetRedisDB().Del(append(keys, indexKey)...)
+	return err
+}
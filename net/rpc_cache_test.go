@@ -0,0 +1,108 @@
+package net
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/utils/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachedRequest(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	requests := 0
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		requests++
+		return mockJsonResponse("{\"representative\": \"nano_1gyeqc6u5j3oaxbe5qy1hyz3q745a318kh8h9ocnpan7fuxnq85cxqboapu5\"}"), nil
+	}
+	account := "nano_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3"
+	request := map[string]interface{}{"action": "account_representative", "account": account}
+
+	resp, err := RpcClient.MakeCachedRequest(request)
+	assert.Equal(t, nil, err)
+	assert.Contains(t, string(resp), "nano_1gyeqc6u5j3oaxbe5qy1hyz3q745a318kh8h9ocnpan7fuxnq85cxqboapu5")
+	// Same request with a different action case is served from the cache
+	cached, err := RpcClient.MakeCachedRequest(map[string]interface{}{"action": "ACCOUNT_REPRESENTATIVE", "account": account})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, resp, cached)
+	assert.Equal(t, 1, requests)
+
+	// A confirmation for the account invalidates it
+	err = InvalidateAccountCache(account)
+	assert.Equal(t, nil, err)
+	_, err = RpcClient.MakeCachedRequest(request)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, requests)
+
+	// Uncached actions always go to the node
+	RpcClient.MakeCachedRequest(map[string]interface{}{"action": "account_balance", "account": account})
+	RpcClient.MakeCachedRequest(map[string]interface{}{"action": "account_balance", "account": account})
+	assert.Equal(t, 4, requests)
+}
+
+func TestCachedRequestConfirmedBlock(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		return mockJsonResponse("{\"block_account\": \"nano_1ipx847tk8o46pwxt5qjdbncjqcbwcc1rrmqnkztrfjy5k7z4imsrata9est\", \"confirmed\": \"true\", \"successor\": \"8D3AB98B301224253750D448B4BD997132400CEDD0A8432F775724F2D9821C72\"}"), nil
+	}
+	request := map[string]interface{}{"action": "block_info", "hash": "80a6745762493fa21a22718abfa4f635656a707b48b3324198ac7f3938de6d4f", "json_block": true}
+	_, err := RpcClient.MakeCachedRequest(request)
+	assert.Equal(t, nil, err)
+
+	key, err := rpcCacheKey("block_info", request)
+	assert.Equal(t, nil, err)
+	ttl, err := database.GetRedisDB().Client.TTL(context.Background(), key).Result()
+	assert.Equal(t, nil, err)
+	// Settled, so it's kept for longer and not indexed by account
+	assert.Equal(t, settledBlockTTL, ttl)
+	indexKey := rpcCacheAccountKey("nano_1ipx847tk8o46pwxt5qjdbncjqcbwcc1rrmqnkztrfjy5k7z4imsrata9est")
+	members, _ := database.GetRedisDB().Smembers(indexKey)
+	assert.Empty(t, members)
+
+	// The frontier's successor is still to come
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		return mockJsonResponse("{\"block_account\": \"nano_1ipx847tk8o46pwxt5qjdbncjqcbwcc1rrmqnkztrfjy5k7z4imsrata9est\", \"confirmed\": \"true\", \"successor\": \"0000000000000000000000000000000000000000000000000000000000000000\"}"), nil
+	}
+	request["hash"] = "8D3AB98B301224253750D448B4BD997132400CEDD0A8432F775724F2D9821C72"
+	_, err = RpcClient.MakeCachedRequest(request)
+	assert.Equal(t, nil, err)
+	key, _ = rpcCacheKey("block_info", request)
+	ttl, _ = database.GetRedisDB().Client.TTL(context.Background(), key).Result()
+	assert.Equal(t, cachedActions["block_info"], ttl)
+	members, _ = database.GetRedisDB().Smembers(indexKey)
+	assert.Equal(t, []string{key}, members)
+	// The index doesn't outlive what's in it
+	ttl, _ = database.GetRedisDB().Client.TTL(context.Background(), indexKey).Result()
+	assert.Equal(t, rpcCacheIndexTTL, ttl)
+
+	// Whether a settled send is receivable changes when the account it sent to receives it
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		return mockJsonResponse("{\"blocks\": {\"80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F\": {\"block_account\": \"nano_1ipx847tk8o46pwxt5qjdbncjqcbwcc1rrmqnkztrfjy5k7z4imsrata9est\", \"confirmed\": \"true\", \"successor\": \"8D3AB98B301224253750D448B4BD997132400CEDD0A8432F775724F2D9821C72\", \"receivable\": \"1\", \"contents\": {\"link_as_account\": \"nano_1gyeqc6u5j3oaxbe5qy1hyz3q745a318kh8h9ocnpan7fuxnq85cxqboapu5\"}}}}"), nil
+	}
+	request = map[string]interface{}{"action": "blocks_info", "hashes": []interface{}{"80a6745762493fa21a22718abfa4f635656a707b48b3324198ac7f3938de6d4f"}, "json_block": true, "receivable": true}
+	_, err = RpcClient.MakeCachedRequest(request)
+	assert.Equal(t, nil, err)
+	key, _ = rpcCacheKey("blocks_info", request)
+	ttl, _ = database.GetRedisDB().Client.TTL(context.Background(), key).Result()
+	assert.Equal(t, cachedActions["blocks_info"], ttl)
+	members, _ = database.GetRedisDB().Smembers(rpcCacheAccountKey("nano_1gyeqc6u5j3oaxbe5qy1hyz3q745a318kh8h9ocnpan7fuxnq85cxqboapu5"))
+	assert.Equal(t, []string{key}, members)
+
+	// Errors are not cached
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		return mockJsonResponse("{\"error\": \"Block not found\"}"), nil
+	}
+	request["hash"] = "0000000000000000000000000000000000000000000000000000000000000001"
+	_, err = RpcClient.MakeCachedRequest(request)
+	assert.Equal(t, nil, err)
+	key, _ = rpcCacheKey("block_info", request)
+	_, err = database.GetRedisDB().Get(key)
+	assert.NotEqual(t, nil, err)
+}
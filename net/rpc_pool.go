@@ -0,0 +1,344 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/metrics"
+	"github.com/appditto/natrium-wallet-server/models"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+)
+
+// Default number of cemented blocks a node can be behind the best node before it's considered unhealthy
+const DefaultMaxSyncLag = 1000
+
+// Default time a node has to answer a request before we fail over to the next one
+const DefaultNodeTimeout = 30 * time.Second
+
+// Consecutive failed requests before a node is marked unhealthy, so one bad request doesn't take it out
+const nodeFailureThreshold = 3
+
+// Errors returned by the node that mean the node itself is broken, rather than the request
+var unhealthyNodeErrors = []string{
+	"Internal server error in RPC",
+	"Empty response",
+}
+
+// RPCNode is a single node endpoint in the RPC pool
+type RPCNode struct {
+	Url    string
+	Weight int
+	// How long a request can take, so a node that hangs doesn't hold it up forever
+	Timeout time.Duration
+
+	mutex      sync.RWMutex
+	healthy    bool
+	failures   int
+	latency    time.Duration
+	blockCount uint64
+	syncLag    uint64
+	version    string
+}
+
+func NewRPCNode(url string, weight int) *RPCNode {
+	if weight < 1 {
+		weight = 1
+	}
+	// Optimistically healthy until the first probe says otherwise
+	return &RPCNode{Url: url, Weight: weight, Timeout: DefaultNodeTimeout, healthy: true}
+}
+
+// ParseRPCNodes parses a comma separated list of url|weight, weight is optional
+// e.g. http://node1:7076|3,http://node2:7076
+func ParseRPCNodes(nodeList string) ([]*RPCNode, error) {
+	var nodes []*RPCNode
+	for _, entry := range strings.Split(nodeList, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		url, weightStr, hasWeight := strings.Cut(entry, "|")
+		weight := 1
+		if hasWeight {
+			parsed, err := strconv.Atoi(weightStr)
+			if err != nil || parsed < 1 {
+				return nil, fmt.Errorf("Invalid weight for RPC node %s", entry)
+			}
+			weight = parsed
+		}
+		nodes = append(nodes, NewRPCNode(url, weight))
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("No RPC nodes in %s", nodeList)
+	}
+	return nodes, nil
+}
+
+func (node *RPCNode) Healthy() bool {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	return node.healthy
+}
+
+func (node *RPCNode) Latency() time.Duration {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	return node.latency
+}
+
+func (node *RPCNode) BlockCount() uint64 {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	return node.blockCount
+}
+
+func (node *RPCNode) SyncLag() uint64 {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	return node.syncLag
+}
+
+func (node *RPCNode) Version() string {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	return node.version
+}
+
+func (node *RPCNode) markSuccess(latency time.Duration) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	node.latency = latency
+	node.failures = 0
+	if !node.healthy {
+		klog.Infof("RPC node %s is healthy again", node.Url)
+	}
+	node.healthy = true
+}
+
+// markFailure counts a failed request, the node is marked unhealthy after nodeFailureThreshold in a row
+// Timeouts don't count, a slow request isn't necessarily a broken node, the health checks catch nodes that hang
+func (node *RPCNode) markFailure(err error) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return
+	}
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	node.failures++
+	if node.failures >= nodeFailureThreshold {
+		node.markUnhealthy(err)
+	}
+}
+
+// markUnhealthy needs the node's lock held
+func (node *RPCNode) markUnhealthy(err error) {
+	if node.healthy {
+		klog.Errorf("RPC node %s marked unhealthy: %v", node.Url, err)
+	}
+	node.healthy = false
+}
+
+// postToNode sends a raw request to a node, errors on transport failures and 5xx responses
+func postToNode(ctx context.Context, url string, requestBody []byte) ([]byte, error) {
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Add("Content-Type", "application/json")
+	resp, err := Client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("RPC node %s returned status %d", url, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// nodeError returns an error if the response is an error payload meaning the node is unhealthy
+func nodeError(body []byte) error {
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errResp); err != nil {
+		return nil
+	}
+	if slices.Contains(unhealthyNodeErrors, errResp.Error) {
+		return fmt.Errorf("RPC node error: %s", errResp.Error)
+	}
+	return nil
+}
+
+// pickNodes orders the pool for a request
+// A healthy node is chosen at random by weight, followed by the other healthy nodes by weight
+// Unhealthy nodes go last, so we still try them if everything is down
+func (client *RPCClient) pickNodes() []*RPCNode {
+	var healthy, unhealthy []*RPCNode
+	totalWeight := 0
+	for _, node := range client.Nodes {
+		if node.Healthy() {
+			healthy = append(healthy, node)
+			totalWeight += node.Weight
+		} else {
+			unhealthy = append(unhealthy, node)
+		}
+	}
+	sort.SliceStable(healthy, func(i, j int) bool { return healthy[i].Weight > healthy[j].Weight })
+	if totalWeight > 0 {
+		pick := rand.Intn(totalWeight)
+		for i, node := range healthy {
+			if pick < node.Weight {
+				healthy[0], healthy[i] = healthy[i], healthy[0]
+				break
+			}
+			pick -= node.Weight
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// makePoolRequest sends the request to the pool, failing over to the next node on errors
+func (client *RPCClient) makePoolRequest(action string, requestBody []byte) ([]byte, error) {
+	var lastErr error
+	for _, node := range client.pickNodes() {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), node.Timeout)
+		body, err := postToNode(ctx, node.Url, requestBody)
+		cancel()
+		if err == nil {
+			err = nodeError(body)
+		}
+		metrics.ObserveRPC(action, node.Url, start, err)
+		if err != nil {
+			node.markFailure(err)
+			lastErr = err
+			continue
+		}
+		node.markSuccess(time.Since(start))
+		return body, nil
+	}
+	return nil, lastErr
+}
+
+// probe checks a single node's block count and version
+func (client *RPCClient) probe(node *RPCNode, timeout time.Duration) (uint64, string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	requestBody, _ := json.Marshal(map[string]string{"action": "block_count"})
+	body, err := postToNode(ctx, node.Url, requestBody)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	latency := time.Since(start)
+	var blockCount models.BlockCountResponse
+	if err := json.Unmarshal(body, &blockCount); err != nil {
+		return 0, "", 0, err
+	}
+	cemented, err := strconv.ParseUint(blockCount.Cemented, 10, 64)
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("Invalid block_count response from %s", node.Url)
+	}
+
+	requestBody, _ = json.Marshal(map[string]string{"action": "version"})
+	body, err = postToNode(ctx, node.Url, requestBody)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	var version models.VersionResponse
+	if err := json.Unmarshal(body, &version); err != nil {
+		return 0, "", 0, err
+	}
+	if version.NodeVendor == "" {
+		return 0, "", 0, fmt.Errorf("Invalid version response from %s", node.Url)
+	}
+	return cemented, version.NodeVendor, latency, nil
+}
+
+// CheckHealth probes every node in the pool and updates their health
+func (client *RPCClient) CheckHealth() {
+	type probeResult struct {
+		blockCount uint64
+		version    string
+		latency    time.Duration
+		err        error
+	}
+	results := make([]probeResult, len(client.Nodes))
+	var wg sync.WaitGroup
+	for i, node := range client.Nodes {
+		wg.Add(1)
+		go func(i int, node *RPCNode) {
+			defer wg.Done()
+			blockCount, version, latency, err := client.probe(node, 5*time.Second)
+			results[i] = probeResult{blockCount: blockCount, version: version, latency: latency, err: err}
+		}(i, node)
+	}
+	wg.Wait()
+
+	// Lag is measured against the most synced node in the pool
+	var best uint64
+	for _, res := range results {
+		if res.err == nil && res.blockCount > best {
+			best = res.blockCount
+		}
+	}
+	maxSyncLag := client.MaxSyncLag
+	if maxSyncLag == 0 {
+		maxSyncLag = DefaultMaxSyncLag
+	}
+
+	for i, node := range client.Nodes {
+		res := results[i]
+		if res.err != nil {
+			// The probe failing, or timing out, is enough
+			node.mutex.Lock()
+			node.markUnhealthy(res.err)
+			node.mutex.Unlock()
+			continue
+		}
+		node.mutex.Lock()
+		node.blockCount = res.blockCount
+		node.syncLag = best - res.blockCount
+		node.latency = res.latency
+		node.version = res.version
+		wasHealthy := node.healthy
+		node.healthy = node.syncLag <= maxSyncLag
+		node.mutex.Unlock()
+		if !wasHealthy && node.Healthy() {
+			klog.Infof("RPC node %s is healthy again", node.Url)
+		} else if !node.Healthy() {
+			klog.Errorf("RPC node %s is %d blocks behind", node.Url, best-res.blockCount)
+		}
+	}
+}
+
+// RunHealthChecks probes the pool on an interval until the context is done
+func (client *RPCClient) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		client.CheckHealth()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/utils/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockJsonResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: 200,
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+		Body: io.NopCloser(bytes.NewReader([]byte(body))),
+	}
+}
+
+func TestParseRPCNodes(t *testing.T) {
+	nodes, err := ParseRPCNodes("http://node1:7076|3, http://node2:7076")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(nodes))
+	assert.Equal(t, "http://node1:7076", nodes[0].Url)
+	assert.Equal(t, 3, nodes[0].Weight)
+	assert.Equal(t, "http://node2:7076", nodes[1].Url)
+	assert.Equal(t, 1, nodes[1].Weight)
+	assert.Equal(t, true, nodes[1].Healthy())
+
+	_, err = ParseRPCNodes("http://node1:7076|abc")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseRPCNodes("")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestPoolFailover(t *testing.T) {
+	client := &RPCClient{Nodes: []*RPCNode{NewRPCNode("http://node1", 1), NewRPCNode("http://node2", 1), NewRPCNode("http://node3", 1)}}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Host {
+		case "node1":
+			return nil, errors.New("connection refused")
+		case "node2":
+			return mockJsonResponse("{\"error\": \"Internal server error in RPC\"}"), nil
+		}
+		return mockJsonResponse("{\"balance\": \"10000\"}"), nil
+	}
+
+	// Whichever node is picked first, we should end up on node3
+	resp, err := client.MakeRequest(map[string]string{"action": "account_balance"})
+	assert.Equal(t, nil, err)
+	var parsed map[string]string
+	json.Unmarshal(resp, &parsed)
+	assert.Equal(t, "10000", parsed["balance"])
+	assert.Equal(t, true, client.Nodes[2].Healthy())
+
+	// Request errors that aren't about the node don't fail over
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		return mockJsonResponse("{\"error\": \"Account not found\"}"), nil
+	}
+	resp, err = client.MakeRequest(map[string]string{"action": "account_balance"})
+	assert.Equal(t, nil, err)
+	json.Unmarshal(resp, &parsed)
+	assert.Equal(t, "Account not found", parsed["error"])
+
+	// A node that hangs is given up on after its timeout
+	for _, node := range client.Nodes {
+		node.Timeout = 10 * time.Millisecond
+	}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != "node3" {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+		return mockJsonResponse("{\"balance\": \"10000\"}"), nil
+	}
+	for i := 0; i < nodeFailureThreshold; i++ {
+		resp, err = client.MakeRequest(map[string]string{"action": "account_balance"})
+		assert.Equal(t, nil, err)
+		json.Unmarshal(resp, &parsed)
+		assert.Equal(t, "10000", parsed["balance"])
+	}
+	// Timing out doesn't make a node unhealthy, the health checks decide that
+	for _, node := range client.Nodes {
+		assert.Equal(t, true, node.Healthy())
+	}
+
+	// Everything down, nodes are only marked unhealthy after failing a few times in a row
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}
+	_, err = client.MakeRequest(map[string]string{"action": "account_balance"})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, true, client.Nodes[0].Healthy())
+	for i := 1; i < nodeFailureThreshold; i++ {
+		client.MakeRequest(map[string]string{"action": "account_balance"})
+	}
+	for _, node := range client.Nodes {
+		assert.Equal(t, false, node.Healthy())
+	}
+
+	// A node that answers again is healthy again
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		return mockJsonResponse("{\"balance\": \"10000\"}"), nil
+	}
+	_, err = client.MakeRequest(map[string]string{"action": "account_balance"})
+	assert.Equal(t, nil, err)
+	healthy := 0
+	for _, node := range client.Nodes {
+		if node.Healthy() {
+			healthy++
+		}
+	}
+	assert.Equal(t, 1, healthy)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	// A single node is given up on after its timeout too
+	client := &RPCClient{Url: "http://node1", Timeout: 10 * time.Millisecond}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	_, err := client.MakeRequest(map[string]string{"action": "account_balance"})
+	assert.Equal(t, true, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	client := &RPCClient{Nodes: []*RPCNode{NewRPCNode("http://node1", 1), NewRPCNode("http://node2", 1), NewRPCNode("http://node3", 1)}, MaxSyncLag: 100}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		var request map[string]string
+		json.NewDecoder(req.Body).Decode(&request)
+		if req.URL.Host == "node3" {
+			return nil, errors.New("connection refused")
+		}
+		if request["action"] == "version" {
+			return mockJsonResponse("{\"rpc_version\": \"1\", \"store_version\": \"21\", \"node_vendor\": \"Nano V25.0\"}"), nil
+		}
+		if req.URL.Host == "node1" {
+			return mockJsonResponse("{\"count\": \"5000\", \"unchecked\": \"0\", \"cemented\": \"5000\"}"), nil
+		}
+		// node2 is lagging
+		return mockJsonResponse("{\"count\": \"4800\", \"unchecked\": \"0\", \"cemented\": \"4800\"}"), nil
+	}
+
+	client.CheckHealth()
+	assert.Equal(t, true, client.Nodes[0].Healthy())
+	assert.Equal(t, uint64(5000), client.Nodes[0].BlockCount())
+	assert.Equal(t, uint64(0), client.Nodes[0].SyncLag())
+	assert.Equal(t, "Nano V25.0", client.Nodes[0].Version())
+	assert.Equal(t, false, client.Nodes[1].Healthy())
+	assert.Equal(t, uint64(200), client.Nodes[1].SyncLag())
+	assert.Equal(t, false, client.Nodes[2].Healthy())
+
+	// Only the healthy node is picked first
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, client.Nodes[0], client.pickNodes()[0])
+	}
+}
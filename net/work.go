@@ -0,0 +1,162 @@
+package net
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/appditto/natrium-wallet-server/gql"
+	"github.com/appditto/natrium-wallet-server/models"
+	"github.com/appditto/natrium-wallet-server/utils"
+	"k8s.io/klog/v2"
+)
+
+// WorkProvider is something that can generate proof of work for a hash
+type WorkProvider interface {
+	Name() string
+	// WorkGenerate should give up when the context is done
+	WorkGenerate(ctx context.Context, hash string, difficultyMultiplier int) (string, error)
+	// WorkCancel tells the provider to stop working on a hash we no longer need
+	WorkCancel(hash string)
+}
+
+// withContext runs a call that doesn't support contexts, returning early if the context is done
+func withContext(ctx context.Context, fn func() (string, error)) (string, error) {
+	type result struct {
+		work string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		work, err := fn()
+		done <- result{work: work, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.work, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func difficultyHex(difficultyMultiplier int) string {
+	return strconv.FormatUint(utils.DifficultyFromMultiplier(difficultyMultiplier), 16)
+}
+
+func parseWorkResponse(body []byte) (string, error) {
+	var workResp models.WorkResponse
+	if err := json.Unmarshal(body, &workResp); err != nil {
+		return "", err
+	}
+	if workResp.Work == "" {
+		return "", fmt.Errorf("Invalid work response %s", string(body))
+	}
+	return workResp.Work, nil
+}
+
+// BoomPoW, BANANO's distributed proof of work system
+type BpowWorkProvider struct {
+	Client *gql.BpowClient
+}
+
+func (p *BpowWorkProvider) Name() string {
+	return "bpow"
+}
+
+func (p *BpowWorkProvider) WorkGenerate(ctx context.Context, hash string, difficultyMultiplier int) (string, error) {
+	return withContext(ctx, func() (string, error) {
+		return p.Client.WorkGenerate(hash, difficultyMultiplier)
+	})
+}
+
+// BoomPoW has no way to cancel a request
+func (p *BpowWorkProvider) WorkCancel(hash string) {}
+
+// A work server speaking the node's work_generate API, like nano-work-server
+type HTTPWorkProvider struct {
+	Url string
+}
+
+func (p *HTTPWorkProvider) Name() string {
+	return "http"
+}
+
+func (p *HTTPWorkProvider) WorkGenerate(ctx context.Context, hash string, difficultyMultiplier int) (string, error) {
+	request := models.WorkGenerate{
+		Action:     "work_generate",
+		Hash:       hash,
+		Difficulty: difficultyHex(difficultyMultiplier),
+	}
+	requestBody, _ := json.Marshal(request)
+	body, err := postToNode(ctx, p.Url, requestBody)
+	if err != nil {
+		return "", err
+	}
+	return parseWorkResponse(body)
+}
+
+func (p *HTTPWorkProvider) WorkCancel(hash string) {
+	request := models.WorkGenerate{
+		Action: "work_cancel",
+		Hash:   hash,
+	}
+	requestBody, _ := json.Marshal(request)
+	if _, err := postToNode(context.Background(), p.Url, requestBody); err != nil {
+		klog.Errorf("Error sending work cancel request: %s", err)
+	}
+}
+
+// The node's own work_generate, routed like any other RPC request
+type NodeWorkProvider struct {
+	RPCClient *RPCClient
+}
+
+func (p *NodeWorkProvider) Name() string {
+	return "node"
+}
+
+func (p *NodeWorkProvider) WorkGenerate(ctx context.Context, hash string, difficultyMultiplier int) (string, error) {
+	return withContext(ctx, func() (string, error) {
+		body, err := p.RPCClient.MakeRequest(models.WorkGenerate{
+			Action:     "work_generate",
+			Hash:       hash,
+			Difficulty: difficultyHex(difficultyMultiplier),
+		})
+		if err != nil {
+			return "", err
+		}
+		return parseWorkResponse(body)
+	})
+}
+
+func (p *NodeWorkProvider) WorkCancel(hash string) {
+	if _, err := p.RPCClient.MakeRequest(models.WorkGenerate{
+		Action: "work_cancel",
+		Hash:   hash,
+	}); err != nil {
+		klog.Errorf("Error sending work cancel request: %s", err)
+	}
+}
+
+// Work generated on our own CPU, slow but needs nothing external
+type LocalWorkProvider struct {
+	// Goroutines to search with
+	Workers int
+}
+
+func (p *LocalWorkProvider) Name() string {
+	return "local"
+}
+
+func (p *LocalWorkProvider) WorkGenerate(ctx context.Context, hash string, difficultyMultiplier int) (string, error) {
+	root, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", err
+	}
+	return utils.GenerateWork(ctx, root, utils.DifficultyFromMultiplier(difficultyMultiplier), p.Workers)
+}
+
+// Generation stops with the context, so there is nothing to cancel
+func (p *LocalWorkProvider) WorkCancel(hash string) {}
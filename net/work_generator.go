@@ -0,0 +1,311 @@
+package net
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/config"
+	"github.com/appditto/natrium-wallet-server/gql"
+	"github.com/appditto/natrium-wallet-server/metrics"
+	"github.com/appditto/natrium-wallet-server/utils"
+	"k8s.io/klog/v2"
+)
+
+type WorkStrategy string
+
+const (
+	// Ask every provider at once, take the first valid result
+	WorkStrategyRace WorkStrategy = "race"
+	// Ask providers one at a time, in the order they were configured
+	WorkStrategyOrdered WorkStrategy = "ordered"
+	// Ask providers one at a time, in a random order by weight
+	WorkStrategyWeighted WorkStrategy = "weighted"
+)
+
+func ParseWorkStrategy(strategy string) (WorkStrategy, error) {
+	switch WorkStrategy(strings.ToLower(strategy)) {
+	case WorkStrategyRace:
+		return WorkStrategyRace, nil
+	case WorkStrategyOrdered:
+		return WorkStrategyOrdered, nil
+	case WorkStrategyWeighted:
+		return WorkStrategyWeighted, nil
+	}
+	return "", fmt.Errorf("Invalid work strategy %s", strategy)
+}
+
+// Consecutive failures before a provider is skipped, and for how long
+const (
+	breakerFailureThreshold = 3
+	breakerCooldown         = 30 * time.Second
+)
+
+// circuitBreaker stops us waiting on a provider that keeps failing
+// After the cooldown a single request is let through, if it works the breaker closes again
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures < breakerFailureThreshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *circuitBreaker) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= breakerFailureThreshold {
+		b.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+// Abandoned means we stopped waiting on the provider ourselves, so it says nothing about its health
+func (b *circuitBreaker) Abandoned() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) IsOpen() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.failures >= breakerFailureThreshold && time.Now().Before(b.openUntil)
+}
+
+// WorkProviderEntry is a provider along with its settings in the generator
+type WorkProviderEntry struct {
+	Provider WorkProvider
+	Timeout  time.Duration
+	Weight   int
+	// Only asked once every other provider failed, whatever the strategy
+	LastResort bool
+
+	breaker circuitBreaker
+}
+
+func NewWorkProviderEntry(provider WorkProvider, timeout time.Duration, weight int) *WorkProviderEntry {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	return &WorkProviderEntry{Provider: provider, Timeout: timeout, Weight: weight}
+}
+
+// WorkGenerator spreads work requests over providers according to the strategy
+type WorkGenerator struct {
+	Providers []*WorkProviderEntry
+	Strategy  WorkStrategy
+}
+
+func NewWorkGenerator(strategy WorkStrategy, providers ...*WorkProviderEntry) *WorkGenerator {
+	return &WorkGenerator{Providers: providers, Strategy: strategy}
+}
+
+// NewWorkGeneratorFromConfig sets up every configured work provider, our own CPU goes last
+func NewWorkGeneratorFromConfig(cfg config.WorkConfig, rpcClient *RPCClient) (*WorkGenerator, error) {
+	strategy, err := ParseWorkStrategy(cfg.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	entry := func(provider WorkProvider, providerCfg config.WorkProviderConfig) *WorkProviderEntry {
+		return NewWorkProviderEntry(provider, providerCfg.Timeout.Duration(), providerCfg.Weight)
+	}
+	var providers []*WorkProviderEntry
+	if cfg.BpowKey != "" {
+		providers = append(providers, entry(&BpowWorkProvider{Client: gql.NewBpowClient(cfg.BpowUrl, cfg.BpowKey, false)}, cfg.Bpow))
+	}
+	if cfg.Url != "" {
+		providers = append(providers, entry(&HTTPWorkProvider{Url: cfg.Url}, cfg.UrlProvider))
+	}
+	if cfg.UseNode {
+		providers = append(providers, entry(&NodeWorkProvider{RPCClient: rpcClient}, cfg.Node))
+	}
+	if cfg.UseLocal {
+		// Takes every core, so it shouldn't race the others
+		local := entry(&LocalWorkProvider{Workers: cfg.LocalThreads}, cfg.Local)
+		local.LastResort = true
+		providers = append(providers, local)
+	}
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("Either WORK_URL, BPOW_KEY, WORK_USE_NODE or WORK_USE_LOCAL must be set for work generation")
+	}
+	return NewWorkGenerator(strategy, providers...), nil
+}
+
+// available returns providers whose circuit breaker lets them through, in the order to try them
+func (g *WorkGenerator) available() []*WorkProviderEntry {
+	var entries []*WorkProviderEntry
+	totalWeight := 0
+	for _, entry := range g.Providers {
+		if entry.breaker.Allow() {
+			entries = append(entries, entry)
+			totalWeight += entry.Weight
+		}
+	}
+	if g.Strategy != WorkStrategyWeighted {
+		return entries
+	}
+	// Pick one by weight at a time, from what's left
+	ordered := make([]*WorkProviderEntry, 0, len(entries))
+	for len(entries) > 0 {
+		pick := rand.Intn(totalWeight)
+		for i, entry := range entries {
+			if pick < entry.Weight {
+				ordered = append(ordered, entry)
+				totalWeight -= entry.Weight
+				entries = append(entries[:i], entries[i+1:]...)
+				break
+			}
+			pick -= entry.Weight
+		}
+	}
+	return ordered
+}
+
+// attempt asks a single provider for work and validates the result
+func (g *WorkGenerator) attempt(ctx context.Context, entry *WorkProviderEntry, hash string, difficultyMultiplier int, root []byte) (string, error) {
+	providerCtx, cancel := context.WithTimeout(ctx, entry.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	work, err := entry.Provider.WorkGenerate(providerCtx, hash, difficultyMultiplier)
+	if err == nil && !utils.ValidateWork(root, work, utils.DifficultyFromMultiplier(difficultyMultiplier)) {
+		err = fmt.Errorf("invalid work %s for %s", work, hash)
+	}
+	if err == nil {
+		metrics.WorkGenerateDuration.WithLabelValues(entry.Provider.Name(), "success").Observe(time.Since(start).Seconds())
+		entry.breaker.Success()
+		return work, nil
+	}
+
+	if providerCtx.Err() != nil {
+		// It's still working on something we don't want anymore
+		go entry.Provider.WorkCancel(hash)
+	}
+	if ctx.Err() != nil {
+		// Another provider won the race
+		metrics.WorkGenerateDuration.WithLabelValues(entry.Provider.Name(), "abandoned").Observe(time.Since(start).Seconds())
+		entry.breaker.Abandoned()
+	} else {
+		metrics.WorkGenerateDuration.WithLabelValues(entry.Provider.Name(), "error").Observe(time.Since(start).Seconds())
+		entry.breaker.Failure()
+		if entry.breaker.IsOpen() {
+			klog.Errorf("Work provider %s is failing, skipping it for %v", entry.Provider.Name(), breakerCooldown)
+		}
+	}
+	return "", fmt.Errorf("%s: %w", entry.Provider.Name(), err)
+}
+
+// WorkGenerate gets work for the hash from the configured providers
+func (g *WorkGenerator) WorkGenerate(hash string, difficultyMultiplier int) (string, error) {
+	root, err := hex.DecodeString(hash)
+	if err != nil || len(root) != 32 {
+		return "", fmt.Errorf("Invalid work hash %s", hash)
+	}
+	entries := g.available()
+	if len(entries) == 0 {
+		return "", errors.New("No work providers available")
+	}
+
+	var providers, lastResort []*WorkProviderEntry
+	for _, entry := range entries {
+		if entry.LastResort {
+			lastResort = append(lastResort, entry)
+		} else {
+			providers = append(providers, entry)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// Cancelling stops every provider still running once we have a result
+	defer cancel()
+
+	var errs []string
+	var work string
+	if g.Strategy == WorkStrategyRace {
+		work, errs = g.race(ctx, providers, hash, difficultyMultiplier, root)
+	} else {
+		work, errs = g.inOrder(ctx, providers, hash, difficultyMultiplier, root)
+	}
+	if work != "" {
+		for _, skipped := range lastResort {
+			skipped.breaker.Abandoned()
+		}
+		return work, nil
+	}
+	work, lastResortErrs := g.inOrder(ctx, lastResort, hash, difficultyMultiplier, root)
+	if work != "" {
+		return work, nil
+	}
+	errs = append(errs, lastResortErrs...)
+	return "", fmt.Errorf("All work providers failed: %s", strings.Join(errs, "; "))
+}
+
+// race asks every provider at once, returning the first valid work or every error
+func (g *WorkGenerator) race(ctx context.Context, entries []*WorkProviderEntry, hash string, difficultyMultiplier int, root []byte) (string, []string) {
+	type result struct {
+		work string
+		err  error
+	}
+	results := make(chan result, len(entries))
+	for _, entry := range entries {
+		go func(entry *WorkProviderEntry) {
+			work, err := g.attempt(ctx, entry, hash, difficultyMultiplier, root)
+			results <- result{work: work, err: err}
+		}(entry)
+	}
+	var errs []string
+	for range entries {
+		res := <-results
+		if res.err == nil {
+			return res.work, nil
+		}
+		errs = append(errs, res.err.Error())
+	}
+	return "", errs
+}
+
+// inOrder asks providers one at a time, returning the first valid work or every error
+func (g *WorkGenerator) inOrder(ctx context.Context, entries []*WorkProviderEntry, hash string, difficultyMultiplier int, root []byte) (string, []string) {
+	var errs []string
+	for i, entry := range entries {
+		work, err := g.attempt(ctx, entry, hash, difficultyMultiplier, root)
+		if err == nil {
+			// Release the breakers of the ones we didn't get to
+			for _, skipped := range entries[i+1:] {
+				skipped.breaker.Abandoned()
+			}
+			return work, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return "", errs
+}
@@ -0,0 +1,183 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Valid at 64x for testWorkHash
+const testWorkHash = "718CC2121C3E641059BC1C2CFC45666C99E8AE922F7A807B7D07B62C995D79E2"
+const testValidWork = "2b3d689bbcb21dca"
+const testInvalidWork = "00000001cce3db6c"
+
+type fakeWorkProvider struct {
+	name  string
+	work  string
+	err   error
+	delay time.Duration
+
+	mutex     sync.Mutex
+	calls     int
+	cancelled []string
+}
+
+func (p *fakeWorkProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeWorkProvider) WorkGenerate(ctx context.Context, hash string, difficultyMultiplier int) (string, error) {
+	p.mutex.Lock()
+	p.calls++
+	p.mutex.Unlock()
+	select {
+	case <-time.After(p.delay):
+		return p.work, p.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (p *fakeWorkProvider) WorkCancel(hash string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cancelled = append(p.cancelled, hash)
+}
+
+func (p *fakeWorkProvider) Calls() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.calls
+}
+
+func (p *fakeWorkProvider) Cancelled() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.cancelled
+}
+
+func TestParseWorkStrategy(t *testing.T) {
+	strategy, err := ParseWorkStrategy("Ordered")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, WorkStrategyOrdered, strategy)
+
+	_, err = ParseWorkStrategy("fastest")
+	assert.NotNil(t, err)
+}
+
+func TestWorkGeneratorRace(t *testing.T) {
+	fast := &fakeWorkProvider{name: "fast", work: testValidWork}
+	slow := &fakeWorkProvider{name: "slow", work: testValidWork, delay: 5 * time.Second}
+	generator := NewWorkGenerator(WorkStrategyRace, NewWorkProviderEntry(slow, 0, 1), NewWorkProviderEntry(fast, 0, 1))
+
+	work, err := generator.WorkGenerate(testWorkHash, 64)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testValidWork, work)
+	// The loser is told to stop
+	assert.Eventually(t, func() bool {
+		return len(slow.Cancelled()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, fast.Cancelled())
+}
+
+func TestWorkGeneratorLastResort(t *testing.T) {
+	remote := &fakeWorkProvider{name: "remote", work: testValidWork, delay: 20 * time.Millisecond}
+	local := &fakeWorkProvider{name: "local", work: testValidWork}
+	localEntry := NewWorkProviderEntry(local, 0, 1)
+	localEntry.LastResort = true
+	generator := NewWorkGenerator(WorkStrategyRace, localEntry, NewWorkProviderEntry(remote, 0, 1))
+
+	// Not part of the race, even though it would win
+	work, err := generator.WorkGenerate(testWorkHash, 64)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testValidWork, work)
+	assert.Equal(t, 0, local.Calls())
+
+	// Only once the others failed
+	remote.err = errors.New("boom")
+	work, err = generator.WorkGenerate(testWorkHash, 64)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testValidWork, work)
+	assert.Equal(t, 1, local.Calls())
+}
+
+func TestWorkGeneratorOrderedFallback(t *testing.T) {
+	broken := &fakeWorkProvider{name: "broken", err: errors.New("boom")}
+	working := &fakeWorkProvider{name: "working", work: testValidWork}
+	unused := &fakeWorkProvider{name: "unused", work: testValidWork}
+	generator := NewWorkGenerator(WorkStrategyOrdered, NewWorkProviderEntry(broken, 0, 1), NewWorkProviderEntry(working, 0, 1), NewWorkProviderEntry(unused, 0, 1))
+
+	work, err := generator.WorkGenerate(testWorkHash, 64)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testValidWork, work)
+	assert.Equal(t, 1, broken.Calls())
+	assert.Equal(t, 1, working.Calls())
+	assert.Equal(t, 0, unused.Calls())
+}
+
+func TestWorkGeneratorRejectsInvalidWork(t *testing.T) {
+	invalid := &fakeWorkProvider{name: "invalid", work: testInvalidWork}
+	generator := NewWorkGenerator(WorkStrategyOrdered, NewWorkProviderEntry(invalid, 0, 1))
+
+	_, err := generator.WorkGenerate(testWorkHash, 64)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid work")
+
+	_, err = generator.WorkGenerate("not a hash", 64)
+	assert.NotNil(t, err)
+}
+
+func TestWorkGeneratorTimeout(t *testing.T) {
+	stuck := &fakeWorkProvider{name: "stuck", work: testValidWork, delay: 5 * time.Second}
+	generator := NewWorkGenerator(WorkStrategyOrdered, NewWorkProviderEntry(stuck, 50*time.Millisecond, 1))
+
+	_, err := generator.WorkGenerate(testWorkHash, 64)
+	assert.NotNil(t, err)
+	assert.Eventually(t, func() bool {
+		return len(stuck.Cancelled()) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWorkGeneratorCircuitBreaker(t *testing.T) {
+	broken := &fakeWorkProvider{name: "broken", err: errors.New("boom")}
+	backup := &fakeWorkProvider{name: "backup", err: errors.New("also boom")}
+	generator := NewWorkGenerator(WorkStrategyOrdered, NewWorkProviderEntry(broken, 0, 1), NewWorkProviderEntry(backup, 0, 1))
+
+	for i := 0; i < breakerFailureThreshold; i++ {
+		_, err := generator.WorkGenerate(testWorkHash, 64)
+		assert.Equal(t, "All work providers failed: broken: boom; backup: also boom", err.Error())
+	}
+	assert.True(t, generator.Providers[0].breaker.IsOpen())
+
+	// Both breakers are open so nothing is asked
+	_, err := generator.WorkGenerate(testWorkHash, 64)
+	assert.Equal(t, "No work providers available", err.Error())
+	assert.Equal(t, breakerFailureThreshold, broken.Calls())
+
+	// After the cooldown a single probe is let through, and a success closes it
+	generator.Providers[0].breaker.openUntil = time.Now().Add(-time.Second)
+	broken.err = nil
+	broken.work = testValidWork
+	work, err := generator.WorkGenerate(testWorkHash, 64)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testValidWork, work)
+	assert.False(t, generator.Providers[0].breaker.IsOpen())
+}
+
+func TestLocalWorkProvider(t *testing.T) {
+	provider := &LocalWorkProvider{Workers: 2}
+	_, err := provider.WorkGenerate(context.Background(), "not a hash", 1)
+	assert.NotNil(t, err)
+
+	// Send difficulty takes far longer than this on a CPU, so it has to give up
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = provider.WorkGenerate(ctx, testWorkHash, 64)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,142 @@
+package net
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/utils"
+	"k8s.io/klog/v2"
+)
+
+const workPrecachePrefix = "work_precache"
+
+// How long precached work is kept, it's useless once the account has a new frontier anyway
+const workPrecacheExpiry = 24 * time.Hour
+
+// How long a replica holds the claim on generating work for a hash
+const workPrecacheClaimExpiry = 5 * time.Minute
+
+func workPrecacheKey(hash string) string {
+	return fmt.Sprintf("%s:%s", workPrecachePrefix, strings.ToUpper(hash))
+}
+
+func workPrecacheClaimKey(hash string) string {
+	return fmt.Sprintf("%s:claim:%s", workPrecachePrefix, strings.ToUpper(hash))
+}
+
+type WorkPrecacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Dropped uint64 `json:"dropped"`
+	Pending int    `json:"pending"`
+}
+
+// WorkPrecache generates work for new frontiers in the background, so process with do_work doesn't have to wait
+type WorkPrecache struct {
+	Generator *WorkGenerator
+	// Difficulty work is generated at, send difficulty covers every subtype
+	DifficultyMultiplier int
+	// Maximum jobs outstanding at once, new frontiers are dropped beyond that
+	MaxJobs int
+
+	mutex   sync.Mutex
+	pending map[string]bool
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func NewWorkPrecache(generator *WorkGenerator, difficultyMultiplier int, maxJobs int) *WorkPrecache {
+	return &WorkPrecache{
+		Generator:            generator,
+		DifficultyMultiplier: difficultyMultiplier,
+		MaxJobs:              maxJobs,
+		pending:              make(map[string]bool),
+	}
+}
+
+// Enqueue starts generating work for the hash in the background
+// Returns false if it's already being generated or there are too many jobs
+func (p *WorkPrecache) Enqueue(hash string) bool {
+	hash = strings.ToUpper(hash)
+	p.mutex.Lock()
+	if p.pending[hash] {
+		p.mutex.Unlock()
+		return false
+	}
+	if len(p.pending) >= p.MaxJobs {
+		p.mutex.Unlock()
+		p.dropped.Add(1)
+		return false
+	}
+	p.pending[hash] = true
+	p.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			p.mutex.Lock()
+			delete(p.pending, hash)
+			p.mutex.Unlock()
+		}()
+		if err := p.generate(hash); err != nil {
+			klog.Errorf("Error precaching work for %s: %v", hash, err)
+		}
+	}()
+	return true
+}
+
+func (p *WorkPrecache) generate(hash string) error {
+	if _, err := database.GetRedisDB().Get(workPrecacheKey(hash)); err == nil {
+		// Already cached
+		return nil
+	}
+	// Another replica may have the same account subscribed
+	claimed, err := database.GetRedisDB().SetNX(workPrecacheClaimKey(hash), "1", workPrecacheClaimExpiry)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+	work, err := p.Generator.WorkGenerate(hash, p.DifficultyMultiplier)
+	if err != nil {
+		return err
+	}
+	return database.GetRedisDB().Set(workPrecacheKey(hash), work, workPrecacheExpiry)
+}
+
+// Get returns precached work for the hash if it meets the difficulty
+// The work is removed from the cache, since the hash won't be a frontier for long after it's used
+func (p *WorkPrecache) Get(hash string, difficultyMultiplier int) (string, bool) {
+	root, err := hex.DecodeString(hash)
+	if err != nil || len(root) != 32 {
+		return "", false
+	}
+	work, err := database.GetRedisDB().Get(workPrecacheKey(hash))
+	if err != nil || !utils.ValidateWork(root, work, utils.DifficultyFromMultiplier(difficultyMultiplier)) {
+		p.misses.Add(1)
+		return "", false
+	}
+	p.hits.Add(1)
+	if _, err := database.GetRedisDB().Del(workPrecacheKey(hash)); err != nil {
+		klog.Errorf("Error removing precached work for %s: %v", hash, err)
+	}
+	return work, true
+}
+
+func (p *WorkPrecache) Stats() WorkPrecacheStats {
+	p.mutex.Lock()
+	pending := len(p.pending)
+	p.mutex.Unlock()
+	return WorkPrecacheStats{
+		Hits:    p.hits.Load(),
+		Misses:  p.misses.Load(),
+		Dropped: p.dropped.Load(),
+		Pending: pending,
+	}
+}
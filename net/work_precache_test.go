@@ -0,0 +1,69 @@
+package net
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkPrecache(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	provider := &fakeWorkProvider{name: "fake", work: testValidWork}
+	precache := NewWorkPrecache(NewWorkGenerator(WorkStrategyRace, NewWorkProviderEntry(provider, 0, 1)), 64, 10)
+
+	// Nothing cached yet
+	_, ok := precache.Get(testWorkHash, 64)
+	assert.False(t, ok)
+
+	assert.True(t, precache.Enqueue(testWorkHash))
+	assert.Eventually(t, func() bool {
+		_, err := database.GetRedisDB().Get(workPrecacheKey(testWorkHash))
+		return err == nil && precache.Stats().Pending == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// Precached work is used once
+	work, ok := precache.Get(testWorkHash, 64)
+	assert.True(t, ok)
+	assert.Equal(t, testValidWork, work)
+	_, ok = precache.Get(testWorkHash, 64)
+	assert.False(t, ok)
+
+	stats := precache.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, 1, provider.Calls())
+}
+
+func TestWorkPrecacheRejectsLowDifficulty(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	// Work that doesn't meet the requested difficulty is a miss
+	database.GetRedisDB().Set(workPrecacheKey(testWorkHash), testInvalidWork, time.Minute)
+	precache := NewWorkPrecache(NewWorkGenerator(WorkStrategyRace), 64, 10)
+	_, ok := precache.Get(testWorkHash, 64)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(1), precache.Stats().Misses)
+	database.GetRedisDB().Del(workPrecacheKey(testWorkHash))
+}
+
+func TestWorkPrecacheMaxJobs(t *testing.T) {
+	// Mock redis client
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	provider := &fakeWorkProvider{name: "slow", work: testValidWork, delay: 200 * time.Millisecond}
+	precache := NewWorkPrecache(NewWorkGenerator(WorkStrategyRace, NewWorkProviderEntry(provider, 0, 1)), 64, 1)
+
+	assert.True(t, precache.Enqueue("80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F"))
+	// Same hash again is already pending
+	assert.False(t, precache.Enqueue("80a6745762493fa21a22718abfa4f635656a707b48b3324198ac7f3938de6d4f"))
+	// Over the cap
+	assert.False(t, precache.Enqueue(testWorkHash))
+	assert.Equal(t, uint64(1), precache.Stats().Dropped)
+	assert.Equal(t, 1, precache.Stats().Pending)
+}
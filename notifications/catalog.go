@@ -0,0 +1,132 @@
+package notifications
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+)
+
+//go:embed templates.json
+var templatesJson []byte
+
+// Message keys, every language falls back to English for the ones it doesn't have
+const (
+	KeyReceiveTitle    = "receive.title"
+	KeyReceiveBody     = "receive.body"
+	KeyReceiveBodyFiat = "receive.body_fiat"
+	KeySendTitle       = "send.title"
+	KeySendBody        = "send.body"
+	KeySendBodyFiat    = "send.body_fiat"
+)
+
+var Keys = []string{KeyReceiveTitle, KeyReceiveBody, KeyReceiveBodyFiat, KeySendTitle, KeySendBody, KeySendBodyFiat}
+
+const fallbackLanguage = "en"
+
+// Codes the apps have used for a language we have under another one
+var languageAliases = map[string]string{
+	// Indonesian, iDD is what alerts.json uses
+	"idd": "id",
+	"in":  "id",
+}
+
+// Catalog has the notification templates in every language we have them in
+// Templates can use {amount}, {fiat} and {app}
+type Catalog struct {
+	messages map[string]map[string]string
+}
+
+// LoadCatalog parses templates by language, then by message key
+func LoadCatalog(contents []byte) (*Catalog, error) {
+	var messages map[string]map[string]string
+	if err := json.Unmarshal(contents, &messages); err != nil {
+		return nil, err
+	}
+	catalog := &Catalog{messages: map[string]map[string]string{}}
+	for language, templates := range messages {
+		catalog.messages[strings.ToLower(language)] = templates
+	}
+	for _, key := range Keys {
+		if catalog.messages[fallbackLanguage][key] == "" {
+			return nil, fmt.Errorf("%s is missing from %s", key, fallbackLanguage)
+		}
+	}
+	return catalog, nil
+}
+
+var defaultCatalog *Catalog
+
+func init() {
+	var err error
+	defaultCatalog, err = LoadCatalog(templatesJson)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// DefaultCatalog is the one built into the server
+func DefaultCatalog() *Catalog {
+	return defaultCatalog
+}
+
+// Languages returns what's looked up for a language, most specific first
+// e.g. pt-BR tries pt-br, then pt, then en
+func Languages(language string) []string {
+	language = strings.ToLower(strings.ReplaceAll(language, "_", "-"))
+	var languages []string
+	add := func(language string) {
+		if alias, ok := languageAliases[language]; ok {
+			language = alias
+		}
+		for _, existing := range languages {
+			if existing == language {
+				return
+			}
+		}
+		languages = append(languages, language)
+	}
+	if language != "" {
+		add(language)
+		if base, _, found := strings.Cut(language, "-"); found {
+			add(base)
+		}
+	}
+	add(fallbackLanguage)
+	return languages
+}
+
+// Template returns the message in the language, or the closest one we have it in
+func (c *Catalog) Template(language string, key string) string {
+	for _, candidate := range Languages(language) {
+		if template, ok := c.messages[candidate][key]; ok && template != "" {
+			return template
+		}
+	}
+	return ""
+}
+
+// Fiat is an amount in a fiat currency, like EUR
+type Fiat struct {
+	Currency string
+	Amount   float64
+}
+
+// Render returns the title and body of a notification for an event, fiat is left out of the body if it's nil
+func (c *Catalog) Render(brand *Brand, language string, event string, amount *big.Int, fiat *Fiat) (string, string) {
+	titleKey, bodyKey, bodyFiatKey := KeyReceiveTitle, KeyReceiveBody, KeyReceiveBodyFiat
+	if event == dbmodels.NotificationEventSend {
+		titleKey, bodyKey, bodyFiatKey = KeySendTitle, KeySendBody, KeySendBodyFiat
+	}
+	locale := LocaleFor(language)
+	params := []string{"{app}", brand.App, "{amount}", brand.FormatAmount(amount, locale)}
+	if fiat != nil {
+		bodyKey = bodyFiatKey
+		params = append(params, "{fiat}", locale.FormatFiat(fiat.Amount, fiat.Currency))
+	}
+	replacer := strings.NewReplacer(params...)
+	return replacer.Replace(c.Template(language, titleKey)), replacer.Replace(c.Template(language, bodyKey))
+}
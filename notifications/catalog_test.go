@@ -0,0 +1,74 @@
+package notifications
+
+import (
+	"math/big"
+	"regexp"
+	"testing"
+
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/stretchr/testify/assert"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{[a-z]+\}`)
+
+// Every shipped language has every message, with the same placeholders as English
+func TestCatalogComplete(t *testing.T) {
+	catalog := DefaultCatalog()
+	assert.Equal(t, 11, len(catalog.messages))
+	for language, templates := range catalog.messages {
+		_, ok := locales[language]
+		assert.True(t, ok, "%s has no locale", language)
+		for _, key := range Keys {
+			assert.NotEmpty(t, templates[key], "%s is missing %s", language, key)
+			assert.ElementsMatch(t, placeholderPattern.FindAllString(catalog.messages["en"][key], -1), placeholderPattern.FindAllString(templates[key], -1), "%s %s", language, key)
+		}
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	assert.Equal(t, []string{"en"}, Languages(""))
+	assert.Equal(t, []string{"en"}, Languages("en"))
+	assert.Equal(t, []string{"pt-br", "pt", "en"}, Languages("pt_BR"))
+	assert.Equal(t, []string{"id", "en"}, Languages("iDD"))
+	assert.Equal(t, []string{"id", "en"}, Languages("in"))
+}
+
+func TestTemplateFallback(t *testing.T) {
+	catalog, err := LoadCatalog([]byte(`{
+		"en": {"receive.title": "Received {amount}", "receive.body": "Open {app}", "receive.body_fiat": "Worth {fiat}", "send.title": "Sent {amount}", "send.body": "Open {app}", "send.body_fiat": "Worth {fiat}"},
+		"es": {"receive.title": "Has recibido {amount}"},
+		"es-MX": {"receive.title": "Recibiste {amount}"}
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "Recibiste {amount}", catalog.Template("es-mx", KeyReceiveTitle))
+	assert.Equal(t, "Has recibido {amount}", catalog.Template("es-AR", KeyReceiveTitle))
+	assert.Equal(t, "Has recibido {amount}", catalog.Template("es", KeyReceiveTitle))
+	// Missing keys come from English
+	assert.Equal(t, "Open {app}", catalog.Template("es-MX", KeyReceiveBody))
+	assert.Equal(t, "Received {amount}", catalog.Template("xx", KeyReceiveTitle))
+
+	_, err = LoadCatalog([]byte(`{"en": {"receive.title": "Received {amount}"}}`))
+	assert.EqualError(t, err, "receive.body is missing from en")
+}
+
+func TestRender(t *testing.T) {
+	catalog := DefaultCatalog()
+	amount, _ := new(big.Int).SetString("1234567891000000000000000000000000", 10)
+
+	title, body := catalog.Render(Natrium, "en", dbmodels.NotificationEventReceive, amount, nil)
+	assert.Equal(t, "Received Ӿ1,234.567891", title)
+	assert.Equal(t, "Open Natrium to receive this transaction.", body)
+
+	title, body = catalog.Render(Natrium, "de", dbmodels.NotificationEventReceive, amount, &Fiat{Currency: "EUR", Amount: 987.654})
+	assert.Equal(t, "Ӿ1.234,567891 erhalten", title)
+	assert.Equal(t, "Im Wert von 987,65\u00a0€. Öffne Natrium, um diese Transaktion zu empfangen.", body)
+
+	title, body = catalog.Render(Kalium, "iDD", dbmodels.NotificationEventSend, amount, &Fiat{Currency: "IDR", Amount: 150000.4})
+	assert.Equal(t, "Anda mengirim 12.345,67 BANANO", title)
+	assert.Equal(t, "Senilai Rp150.000. Buka Kalium untuk melihat transaksi ini.", body)
+
+	// No translation, English text with English formatting
+	title, body = catalog.Render(Kalium, "pt-BR", dbmodels.NotificationEventReceive, amount, &Fiat{Currency: "BRL", Amount: 5})
+	assert.Equal(t, "Received 12,345.67 BANANO", title)
+	assert.Equal(t, "Worth R$5.00. Open Kalium to receive this transaction.", body)
+}
@@ -0,0 +1,156 @@
+package notifications
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/appditto/natrium-wallet-server/utils"
+)
+
+// Brand is how an app names itself and its coin in notifications
+type Brand struct {
+	App string
+	// Where the number goes in an amount, e.g. Ӿ{number}
+	AmountFormat string
+	// Amounts are cut off after this many decimals
+	Decimals   int
+	BananoMode bool
+}
+
+var Natrium = &Brand{App: "Natrium", AmountFormat: "Ӿ{number}", Decimals: 6}
+
+var Kalium = &Brand{App: "Kalium", AmountFormat: "{number} BANANO", Decimals: 2, BananoMode: true}
+
+func BrandFor(bananoMode bool) *Brand {
+	if bananoMode {
+		return Kalium
+	}
+	return Natrium
+}
+
+// FormatAmount formats a raw amount in the coin, without going through a float
+// Decimals past Decimals are cut off, unless that leaves nothing, then it's cut after the first significant digit
+func (b *Brand) FormatAmount(raw *big.Int, locale *Locale) string {
+	amount := utils.RawToAmount(raw, b.BananoMode)
+	if whole, fraction, found := strings.Cut(amount, "."); found {
+		if len(fraction) > b.Decimals {
+			decimals := b.Decimals
+			if whole == "0" && strings.Trim(fraction[:decimals], "0") == "" {
+				decimals = len(fraction) - len(strings.TrimLeft(fraction, "0")) + 1
+			}
+			fraction = strings.TrimRight(fraction[:decimals], "0")
+		}
+		amount = whole
+		if fraction != "" {
+			amount += "." + fraction
+		}
+	}
+	return strings.Replace(b.AmountFormat, "{number}", locale.FormatNumber(amount), 1)
+}
+
+// Locale is how numbers and money are written in a language
+type Locale struct {
+	Decimal string
+	Group   string
+	// Where the currency symbol goes, {symbol} and {number}
+	FiatFormat string
+}
+
+// Spaces that don't break the line between a number and its currency
+const (
+	nbsp       = "\u00a0"
+	narrowNbsp = "\u202f"
+)
+
+var locales = map[string]*Locale{
+	"en": {Decimal: ".", Group: ",", FiatFormat: "{symbol}{number}"},
+	"es": {Decimal: ",", Group: ".", FiatFormat: "{number}" + nbsp + "{symbol}"},
+	"tr": {Decimal: ",", Group: ".", FiatFormat: "{symbol}{number}"},
+	"ja": {Decimal: ".", Group: ",", FiatFormat: "{symbol}{number}"},
+	"de": {Decimal: ",", Group: ".", FiatFormat: "{number}" + nbsp + "{symbol}"},
+	"fr": {Decimal: ",", Group: narrowNbsp, FiatFormat: "{number}" + nbsp + "{symbol}"},
+	"nl": {Decimal: ",", Group: ".", FiatFormat: "{symbol}" + nbsp + "{number}"},
+	"id": {Decimal: ",", Group: ".", FiatFormat: "{symbol}{number}"},
+	"ru": {Decimal: ",", Group: nbsp, FiatFormat: "{number}" + nbsp + "{symbol}"},
+	"da": {Decimal: ",", Group: ".", FiatFormat: "{number}" + nbsp + "{symbol}"},
+	"sv": {Decimal: ",", Group: nbsp, FiatFormat: "{number}" + nbsp + "{symbol}"},
+}
+
+// LocaleFor returns the locale of the language, falling back like templates do
+func LocaleFor(language string) *Locale {
+	for _, candidate := range Languages(language) {
+		if locale, ok := locales[candidate]; ok {
+			return locale
+		}
+	}
+	return locales[fallbackLanguage]
+}
+
+// Currencies with a symbol that isn't ambiguous, the rest are written with their code
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"CNY": "CN¥",
+	"KRW": "₩",
+	"INR": "₹",
+	"RUB": "₽",
+	"TRY": "₺",
+	"UAH": "₴",
+	"ILS": "₪",
+	"PHP": "₱",
+	"THB": "฿",
+	"BRL": "R$",
+	"AUD": "A$",
+	"CAD": "CA$",
+	"HKD": "HK$",
+	"MXN": "MX$",
+	"NZD": "NZ$",
+	"TWD": "NT$",
+	"IDR": "Rp",
+	"BTC": "₿",
+}
+
+// Decimals a currency is shown with, when it isn't 2
+var currencyDecimals = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"CLP": 0,
+	"IDR": 0,
+	"BTC": 8,
+}
+
+// FormatNumber writes a decimal number like 1234.5 the way the locale does
+func (l *Locale) FormatNumber(number string) string {
+	whole, fraction, found := strings.Cut(number, ".")
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(l.Group)
+		}
+		grouped.WriteRune(digit)
+	}
+	if !found {
+		return grouped.String()
+	}
+	return grouped.String() + l.Decimal + fraction
+}
+
+// FormatFiat writes an amount of money in the currency the way the locale does
+func (l *Locale) FormatFiat(amount float64, currency string) string {
+	decimals, ok := currencyDecimals[currency]
+	if !ok {
+		decimals = 2
+	}
+	number := l.FormatNumber(strconv.FormatFloat(amount, 'f', decimals, 64))
+	symbol, ok := currencySymbols[currency]
+	format := l.FiatFormat
+	if !ok {
+		// Codes always get a space, CHF 4.20 rather than CHF4.20
+		symbol = currency
+		format = strings.Replace(format, "{symbol}{number}", "{symbol}"+nbsp+"{number}", 1)
+	}
+	return strings.NewReplacer("{symbol}", symbol, "{number}", number).Replace(format)
+}
@@ -0,0 +1,46 @@
+package notifications
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatAmount(t *testing.T) {
+	en := LocaleFor("en")
+	raw, _ := new(big.Int).SetString("1000000000000000000000000000000", 10)
+	assert.Equal(t, "Ӿ1", Natrium.FormatAmount(raw, en))
+	assert.Equal(t, "10 BANANO", Kalium.FormatAmount(raw, en))
+	// Cut off, not rounded
+	raw, _ = new(big.Int).SetString("1999999999000000000000000000000", 10)
+	assert.Equal(t, "Ӿ1.999999", Natrium.FormatAmount(raw, en))
+	assert.Equal(t, "19.99 BANANO", Kalium.FormatAmount(raw, en))
+	raw, _ = new(big.Int).SetString("1000000000000000000000000", 10)
+	assert.Equal(t, "Ӿ0.000001", Natrium.FormatAmount(raw, en))
+	// Too small for the decimals, so it keeps the first significant digit instead of showing 0
+	assert.Equal(t, "0.00001 BANANO", Kalium.FormatAmount(raw, en))
+	raw, _ = new(big.Int).SetString("1234", 10)
+	assert.Equal(t, "Ӿ0.000000000000000000000000001", Natrium.FormatAmount(raw, en))
+	assert.Equal(t, "0,00000000000000000000000001 BANANO", Kalium.FormatAmount(raw, LocaleFor("de")))
+}
+
+func TestFormatNumber(t *testing.T) {
+	assert.Equal(t, "1,234,567.89", LocaleFor("en").FormatNumber("1234567.89"))
+	assert.Equal(t, "123", LocaleFor("en").FormatNumber("123"))
+	assert.Equal(t, "1.234.567,89", LocaleFor("de").FormatNumber("1234567.89"))
+	assert.Equal(t, "1\u202f234,5", LocaleFor("fr-CA").FormatNumber("1234.5"))
+	assert.Equal(t, "1\u00a0234,5", LocaleFor("ru").FormatNumber("1234.5"))
+}
+
+func TestFormatFiat(t *testing.T) {
+	assert.Equal(t, "$1,234.50", LocaleFor("en").FormatFiat(1234.5, "USD"))
+	assert.Equal(t, "1.234,50\u00a0$", LocaleFor("es").FormatFiat(1234.5, "USD"))
+	assert.Equal(t, "€\u00a01.234,50", LocaleFor("nl").FormatFiat(1234.5, "EUR"))
+	assert.Equal(t, "¥1,235", LocaleFor("ja").FormatFiat(1234.6, "JPY"))
+	assert.Equal(t, "₿0.00012346", LocaleFor("en").FormatFiat(0.000123456, "BTC"))
+	// Codes for currencies without a clear symbol
+	assert.Equal(t, "CHF\u00a04.20", LocaleFor("en").FormatFiat(4.2, "CHF"))
+	assert.Equal(t, "4,20\u00a0SEK", LocaleFor("sv").FormatFiat(4.2, "SEK"))
+	assert.Equal(t, "₺4,20", LocaleFor("tr").FormatFiat(4.2, "TRY"))
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+)
+
+// Repository for SQL operations
+type ApiKeyRepo struct {
+	DB *gorm.DB
+}
+
+// HashApiKey is what's stored for a key, keys are random so a plain sha256 is enough
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateApiKey generates a new key, it's only ever returned here
+func (repo *ApiKeyRepo) CreateApiKey(name string, scopes []string, rateTier string, maxCount int64, expiresAt *time.Time) (string, *dbmodels.ApiKey, error) {
+	if name == "" {
+		return "", nil, errors.New("API key name is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(dbmodels.ApiKeyScopes, scope) {
+			return "", nil, fmt.Errorf("Invalid API key scope %s", scope)
+		}
+	}
+	if rateTier != "" && !slices.Contains(dbmodels.ApiKeyRateTiers, rateTier) {
+		return "", nil, fmt.Errorf("Invalid API key rate tier %s", rateTier)
+	}
+	if maxCount < 0 {
+		return "", nil, errors.New("API key max count can't be negative")
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	key := hex.EncodeToString(raw)
+	apiKey := &dbmodels.ApiKey{
+		Name:      name,
+		KeyHash:   HashApiKey(key),
+		Scopes:    strings.Join(scopes, ","),
+		RateTier:  rateTier,
+		MaxCount:  maxCount,
+		ExpiresAt: expiresAt,
+	}
+	if err := repo.DB.Create(apiKey).Error; err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+// GetApiKey looks a key up by its hash, returns gorm.ErrRecordNotFound if there isn't one
+func (repo *ApiKeyRepo) GetApiKey(key string) (*dbmodels.ApiKey, error) {
+	var apiKey dbmodels.ApiKey
+	if err := repo.DB.Where("key_hash = ?", HashApiKey(key)).First(&apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+func (repo *ApiKeyRepo) GetApiKeys() ([]dbmodels.ApiKey, error) {
+	var apiKeys []dbmodels.ApiKey
+	if err := repo.DB.Order("created_at").Find(&apiKeys).Error; err != nil {
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+func (repo *ApiKeyRepo) DeleteApiKey(id uuid.UUID) error {
+	return repo.DB.Delete(&dbmodels.ApiKey{}, "id = ?", id).Error
+}
+
+// TouchApiKey records when the key was last used
+func (repo *ApiKeyRepo) TouchApiKey(id uuid.UUID) error {
+	return repo.DB.Model(&dbmodels.ApiKey{}).Where("id = ?", id).UpdateColumn("last_used_at", time.Now().UTC()).Error
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestApiKeys(t *testing.T) {
+	mockDb, err := database.NewConnection(&database.Config{
+		Host:     os.Getenv("DB_MOCK_HOST"),
+		Port:     os.Getenv("DB_MOCK_PORT"),
+		Password: os.Getenv("DB_MOCK_PASS"),
+		User:     os.Getenv("DB_MOCK_USER"),
+		SSLMode:  os.Getenv("DB_SSLMODE"),
+		DBName:   "testing",
+	})
+	assert.Equal(t, nil, err)
+	err = database.DropAndCreateTables(mockDb)
+	assert.Equal(t, nil, err)
+	apiKeyRepo := &ApiKeyRepo{
+		DB: mockDb,
+	}
+
+	_, _, err = apiKeyRepo.CreateApiKey("bad", []string{"everything"}, "", 0, nil)
+	assert.NotNil(t, err)
+	_, _, err = apiKeyRepo.CreateApiKey("bad", []string{dbmodels.ScopeRPCRead}, "unlimited", 0, nil)
+	assert.NotNil(t, err)
+
+	expiry := time.Now().Add(time.Hour)
+	key, created, err := apiKeyRepo.CreateApiKey("explorer", []string{dbmodels.ScopeRPCRead}, "whitelisted", 5000, &expiry)
+	if !assert.Equal(t, nil, err) {
+		return
+	}
+	assert.Equal(t, 64, len(key))
+	// Only the hash is stored
+	assert.Equal(t, HashApiKey(key), created.KeyHash)
+	assert.NotEqual(t, key, created.KeyHash)
+
+	apiKey, err := apiKeyRepo.GetApiKey(key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "explorer", apiKey.Name)
+	assert.Equal(t, true, apiKey.HasScope(dbmodels.ScopeRPCRead))
+	assert.Equal(t, false, apiKey.HasScope(dbmodels.ScopeProcess))
+	assert.Equal(t, int64(5000), apiKey.MaxCount)
+	assert.Nil(t, apiKey.LastUsedAt)
+
+	err = apiKeyRepo.TouchApiKey(apiKey.ID)
+	assert.Equal(t, nil, err)
+	apiKey, err = apiKeyRepo.GetApiKey(key)
+	assert.Equal(t, nil, err)
+	assert.NotNil(t, apiKey.LastUsedAt)
+
+	_, err = apiKeyRepo.GetApiKey("wrong")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	err = apiKeyRepo.DeleteApiKey(apiKey.ID)
+	assert.Equal(t, nil, err)
+	apiKeys, err := apiKeyRepo.GetApiKeys()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(apiKeys))
+}
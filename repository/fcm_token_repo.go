@@ -62,7 +62,13 @@ func (repo *FcmTokenRepo) DeleteFcmToken(token string) error {
 	return repo.DB.Delete(&dbmodels.FcmToken{}, "fcm_token = ?", token).Error
 }
 
-func (repo *FcmTokenRepo) AddOrUpdateToken(token string, account string) error {
+// DeleteTokenForAccount removes a single token/account association, leaving the token's other accounts
+func (repo *FcmTokenRepo) DeleteTokenForAccount(token string, account string) error {
+	return repo.DB.Delete(&dbmodels.FcmToken{}, "fcm_token = ? AND account = ?", token, account).Error
+}
+
+// AddOrUpdateToken associates the token with the account, platform is where its notifications are sent
+func (repo *FcmTokenRepo) AddOrUpdateToken(token string, account string, platform string) error {
 	// Add token to db if not exists
 	var count int64
 	err := repo.DB.Model(&dbmodels.FcmToken{}).Where("fcm_token = ?", token).Where("account = ?", account).Count(&count).Error
@@ -70,16 +76,45 @@ func (repo *FcmTokenRepo) AddOrUpdateToken(token string, account string) error {
 		fcmToken := &dbmodels.FcmToken{
 			FcmToken: token,
 			Account:  account,
+			Platform: platform,
 		}
 		if err = repo.DB.Create(fcmToken).Error; err != nil {
 			return err
 		}
 	} else if count > 0 {
 		// Already exists so we will update updated_at
-		if err = repo.DB.Model(&dbmodels.FcmToken{}).Where("fcm_token = ?", token).Where("account = ?", account).Update("updated_at", time.Now()).Error; err != nil {
+		if err = repo.DB.Model(&dbmodels.FcmToken{}).Where("fcm_token = ?", token).Where("account = ?", account).Updates(map[string]interface{}{"updated_at": time.Now(), "platform": platform}).Error; err != nil {
 			klog.Errorf("Error updating fcm token updated_at %v", err)
 			return err
 		}
 	}
 	return nil
 }
+
+// GetToken returns the token's association with the account, nil if there isn't one
+func (repo *FcmTokenRepo) GetToken(token string, account string) (*dbmodels.FcmToken, error) {
+	var tokens []dbmodels.FcmToken
+	if err := repo.DB.Where("fcm_token = ?", token).Where("account = ?", account).Limit(1).Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	return &tokens[0], nil
+}
+
+// UpdatePreferences replaces the token's notification preferences for the account
+func (repo *FcmTokenRepo) UpdatePreferences(token string, account string, preferences *dbmodels.NotificationPreferences) error {
+	// A map so false and empty values are written too
+	return repo.DB.Model(&dbmodels.FcmToken{}).Where("fcm_token = ?", token).Where("account = ?", account).Updates(map[string]interface{}{
+		"updated_at":     time.Now(),
+		"minimum_raw":    preferences.MinimumRaw,
+		"language":       preferences.Language,
+		"currency":       preferences.Currency,
+		"quiet_start":    preferences.QuietStart,
+		"quiet_end":      preferences.QuietEnd,
+		"timezone":       preferences.Timezone,
+		"notify_receive": preferences.NotifyReceive,
+		"notify_send":    preferences.NotifySend,
+	}).Error
+}
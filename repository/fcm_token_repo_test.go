@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/appditto/natrium-wallet-server/database"
+	"github.com/appditto/natrium-wallet-server/models/dbmodels"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -92,11 +93,112 @@ func TestAddOrUpdateToken(t *testing.T) {
 	err = fcmRepo.CreateMockTokens()
 
 	// * 2) We want to test adding a new token
-	err = fcmRepo.AddOrUpdateToken("token1", "account_new")
+	err = fcmRepo.AddOrUpdateToken("token1", "account_new", dbmodels.PlatformFCM)
 	assert.Equal(t, nil, err)
 
 	tokens, err := fcmRepo.GetTokensForAccount("account_new")
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 1, len(tokens))
 	assert.Equal(t, "token1", tokens[0].FcmToken)
+	assert.Equal(t, dbmodels.PlatformFCM, tokens[0].Platform)
+
+	// * 3) Updating an existing token changes its platform
+	err = fcmRepo.AddOrUpdateToken("token1", "account_new", dbmodels.PlatformAPNs)
+	assert.Equal(t, nil, err)
+	tokens, err = fcmRepo.GetTokensForAccount("account_new")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(tokens))
+	assert.Equal(t, dbmodels.PlatformAPNs, tokens[0].Platform)
+}
+
+func TestDeleteTokenForAccount(t *testing.T) {
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	mockDb, err := database.NewConnection(&database.Config{
+		Host:     os.Getenv("DB_MOCK_HOST"),
+		Port:     os.Getenv("DB_MOCK_PORT"),
+		Password: os.Getenv("DB_MOCK_PASS"),
+		User:     os.Getenv("DB_MOCK_USER"),
+		SSLMode:  os.Getenv("DB_SSLMODE"),
+		DBName:   "testing",
+	})
+	assert.Equal(t, nil, err)
+	err = database.DropAndCreateTables(mockDb)
+	assert.Equal(t, nil, err)
+	fcmRepo := &FcmTokenRepo{
+		DB: mockDb,
+	}
+
+	// Create mock tokens
+	err = fcmRepo.CreateMockTokens()
+	err = fcmRepo.AddOrUpdateToken("token2", "account1", dbmodels.PlatformFCM)
+	assert.Equal(t, nil, err)
+
+	// Only the association with account2 is removed
+	err = fcmRepo.DeleteTokenForAccount("token2", "account2")
+	assert.Equal(t, nil, err)
+	tokens, err := fcmRepo.GetTokensForAccount("account2")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(tokens))
+	assert.Equal(t, "token3", tokens[0].FcmToken)
+	tokens, err = fcmRepo.GetTokensForAccount("account1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(tokens))
+}
+
+func TestUpdatePreferences(t *testing.T) {
+	os.Setenv("MOCK_REDIS", "true")
+	defer os.Unsetenv("MOCK_REDIS")
+	mockDb, err := database.NewConnection(&database.Config{
+		Host:     os.Getenv("DB_MOCK_HOST"),
+		Port:     os.Getenv("DB_MOCK_PORT"),
+		Password: os.Getenv("DB_MOCK_PASS"),
+		User:     os.Getenv("DB_MOCK_USER"),
+		SSLMode:  os.Getenv("DB_SSLMODE"),
+		DBName:   "testing",
+	})
+	assert.Equal(t, nil, err)
+	err = database.DropAndCreateTables(mockDb)
+	assert.Equal(t, nil, err)
+	fcmRepo := &FcmTokenRepo{
+		DB: mockDb,
+	}
+
+	// Create mock tokens
+	err = fcmRepo.CreateMockTokens()
+
+	// New tokens get the defaults
+	token, err := fcmRepo.GetToken("token2", "account2")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, dbmodels.NotificationPreferences{Language: "en", NotifyReceive: true}, token.NotificationPreferences)
+	token, err = fcmRepo.GetToken("token2", "account1")
+	assert.Equal(t, nil, err)
+	assert.Nil(t, token)
+
+	preferences := dbmodels.NotificationPreferences{
+		MinimumRaw:    "100000000000000000000000000000",
+		Language:      "es",
+		Currency:      "EUR",
+		QuietStart:    "22:00",
+		QuietEnd:      "07:00",
+		Timezone:      "Europe/Madrid",
+		NotifyReceive: false,
+		NotifySend:    true,
+	}
+	err = fcmRepo.UpdatePreferences("token2", "account2", &preferences)
+	assert.Equal(t, nil, err)
+	token, err = fcmRepo.GetToken("token2", "account2")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, preferences, token.NotificationPreferences)
+	// Only for that account
+	token, err = fcmRepo.GetToken("token3", "account2")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, token.NotifyReceive)
+
+	// Re-registering the token keeps them
+	err = fcmRepo.AddOrUpdateToken("token2", "account2", dbmodels.PlatformFCM)
+	assert.Equal(t, nil, err)
+	token, err = fcmRepo.GetToken("token2", "account2")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, preferences, token.NotificationPreferences)
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// Run every shutdown step at once, giving up on whichever haven't finished when the context is done
+func shutdown(ctx context.Context, steps map[string]func(context.Context) error) {
+	var wg sync.WaitGroup
+	for name, step := range steps {
+		wg.Add(1)
+		go func(name string, step func(context.Context) error) {
+			defer wg.Done()
+			if err := step(ctx); err != nil {
+				klog.Errorf("Error shutting down %s: %v", name, err)
+			}
+		}(name, step)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		klog.Errorf("Shutdown timed out, exiting anyway")
+	}
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Work thresholds, sends and changes need 64x the work of receives on NANO
+const (
+	SendWorkThreshold    uint64 = 0xfffffff800000000
+	ReceiveWorkThreshold uint64 = 0xfffffe0000000000
+)
+
+// Every state block hash starts with 31 zero bytes followed by the block type, 6
+var stateBlockPreamble = append(make([]byte, 31), 6)
+
+var maxBalance = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+// IsZeroHash returns true for an all zero hash, or the "0" shorthand used for open blocks
+func IsZeroHash(hash string) bool {
+	return strings.ReplaceAll(hash, "0", "") == ""
+}
+
+// DecodeHash decodes a 32 byte hex string, like a block hash or link
+func DecodeHash(hash string) ([]byte, error) {
+	if hash == "0" {
+		return make([]byte, 32), nil
+	}
+	if len(hash) != 64 {
+		return nil, errors.New("Invalid hash length")
+	}
+	return hex.DecodeString(hash)
+}
+
+// LinkToBytes decodes a link, which is either 32 bytes of hex or an account
+func LinkToBytes(link string) ([]byte, error) {
+	if decoded, err := DecodeHash(link); err == nil {
+		return decoded, nil
+	}
+	return AddressToPub(link)
+}
+
+// BalanceToBytes encodes a raw decimal balance as 16 big endian bytes
+func BalanceToBytes(balance string) ([]byte, error) {
+	balanceBig, ok := new(big.Int).SetString(balance, 10)
+	if !ok || balanceBig.Sign() < 0 || balanceBig.Cmp(maxBalance) > 0 {
+		return nil, fmt.Errorf("Invalid balance %s", balance)
+	}
+	return balanceBig.FillBytes(make([]byte, 16)), nil
+}
+
+// HashStateBlock computes the blake2b hash of a state block, which is what gets signed
+func HashStateBlock(account string, previous string, representative string, balance string, link string) ([]byte, error) {
+	accountBytes, err := AddressToPub(account)
+	if err != nil {
+		return nil, err
+	}
+	previousBytes, err := DecodeHash(previous)
+	if err != nil {
+		return nil, err
+	}
+	representativeBytes, err := AddressToPub(representative)
+	if err != nil {
+		return nil, err
+	}
+	balanceBytes, err := BalanceToBytes(balance)
+	if err != nil {
+		return nil, err
+	}
+	linkBytes, err := LinkToBytes(link)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := blake2b.New256(nil)
+	if err != nil {
+		return nil, err
+	}
+	hash.Write(stateBlockPreamble)
+	hash.Write(accountBytes)
+	hash.Write(previousBytes)
+	hash.Write(representativeBytes)
+	hash.Write(balanceBytes)
+	hash.Write(linkBytes)
+	return hash.Sum(nil), nil
+}
+
+// WorkValue returns the difficulty of a work nonce for the given root
+// The root is the previous block hash, or the account public key for open blocks
+func WorkValue(root []byte, work string) (uint64, error) {
+	nonce, err := hex.DecodeString(work)
+	if err != nil || len(nonce) != 8 {
+		return 0, fmt.Errorf("Invalid work %s", work)
+	}
+	hash, err := blake2b.New(8, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Work is displayed big endian but hashed little endian
+	hash.Write(Reversed(nonce))
+	hash.Write(root)
+	return binary.LittleEndian.Uint64(hash.Sum(nil)), nil
+}
+
+// ValidateWork returns true if the work meets the threshold for the root
+func ValidateWork(root []byte, work string, threshold uint64) bool {
+	value, err := WorkValue(root, work)
+	if err != nil {
+		return false
+	}
+	return value >= threshold
+}
+
+// DifficultyFromMultiplier returns the work threshold for a multiplier of the base (receive) threshold
+func DifficultyFromMultiplier(multiplier int) uint64 {
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	return math.MaxUint64 - (math.MaxUint64-ReceiveWorkThreshold)/uint64(multiplier)
+}
+
+// How many nonces a worker tries between checking for cancellation
+const workBatchSize = 1 << 14
+
+// GenerateWork searches for a nonce meeting the threshold on the CPU, across the given number of goroutines
+// Gives up when the context is done
+func GenerateWork(ctx context.Context, root []byte, threshold uint64, workers int) (string, error) {
+	if len(root) != 32 {
+		return "", errors.New("Invalid work root")
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan uint64, workers)
+	var start [8]byte
+	if _, err := rand.Read(start[:]); err != nil {
+		return "", err
+	}
+	// Each worker searches its own stretch of nonces from a random start
+	base := binary.LittleEndian.Uint64(start[:])
+	stride := math.MaxUint64 / uint64(workers)
+	for i := 0; i < workers; i++ {
+		go func(nonce uint64) {
+			hash, _ := blake2b.New(8, nil)
+			input := make([]byte, 40)
+			copy(input[8:], root)
+			sum := make([]byte, 0, 8)
+			for {
+				for n := 0; n < workBatchSize; n++ {
+					binary.LittleEndian.PutUint64(input, nonce)
+					hash.Reset()
+					hash.Write(input)
+					if binary.LittleEndian.Uint64(hash.Sum(sum[:0])) >= threshold {
+						found <- nonce
+						return
+					}
+					nonce++
+				}
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}(base + uint64(i)*stride)
+	}
+
+	select {
+	case nonce := <-found:
+		return fmt.Sprintf("%016x", nonce), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashStateBlock(t *testing.T) {
+	account := "nano_1zyb1s96twbtycqwgh1o6wsnpsksgdoohokikgjqjaz63pxnju457pz8tm3r"
+	hash, err := HashStateBlock(account, "80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F", account, "11999999999999999918751838129509869131", "000D1BAEC8EC208142C99059B393051BAC8380F9B5A2E6B2489A277D81789F3F")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "32CEF4FE562CEAE7360EFECF8035BCF2081C5493AFA9A1C8D9BE7FC47A4D5CCA", strings.ToUpper(hex.EncodeToString(hash)))
+
+	// Link as an account is the same as the public key in hex
+	linkHash, err := HashStateBlock(account, "80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F", account, "11999999999999999918751838129509869131", account)
+	assert.Equal(t, nil, err)
+	pubHash, err := HashStateBlock(account, "80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F", account, "11999999999999999918751838129509869131", "7fc9064e4d713af2afc73c1527334b665972eb57d65093a378a3e40dbb48ec43")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, pubHash, linkHash)
+
+	// Invalid fields
+	_, err = HashStateBlock(account, "80A6", account, "1", "0")
+	assert.NotEqual(t, nil, err)
+	_, err = HashStateBlock(account, "0", "nano_invalid", "1", "0")
+	assert.NotEqual(t, nil, err)
+	_, err = HashStateBlock(account, "0", account, "-1", "0")
+	assert.NotEqual(t, nil, err)
+	_, err = HashStateBlock(account, "0", account, "340282366920938463463374607431768211456", "0")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestValidateWork(t *testing.T) {
+	root, _ := hex.DecodeString("80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F")
+	value, err := WorkValue(root, "00000000000000ab")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0xfffd8ad8298b8159), value)
+	assert.Equal(t, true, ValidateWork(root, "00000000000000ab", 0xff00000000000000))
+	assert.Equal(t, false, ValidateWork(root, "00000000000000ab", SendWorkThreshold))
+	value, err = WorkValue(root, "2b3d689bbcb21dca")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0x1e0264e7812fcf51), value)
+	assert.Equal(t, false, ValidateWork(root, "notwork", 0))
+}
+
+func TestDifficultyFromMultiplier(t *testing.T) {
+	assert.Equal(t, ReceiveWorkThreshold, DifficultyFromMultiplier(1))
+	assert.Equal(t, SendWorkThreshold, DifficultyFromMultiplier(64))
+	assert.Equal(t, ReceiveWorkThreshold, DifficultyFromMultiplier(0))
+}
+
+func TestGenerateWork(t *testing.T) {
+	root, _ := hex.DecodeString("80A6745762493FA21A22718ABFA4F635656A707B48B3324198AC7F3938DE6D4F")
+	work, err := GenerateWork(context.Background(), root, 0xfff0000000000000, 4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ValidateWork(root, work, 0xfff0000000000000))
+
+	// Cancelled before anything can be found
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = GenerateWork(ctx, root, math.MaxUint64, 2)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	_, err = GenerateWork(context.Background(), root[:31], 0, 1)
+	assert.NotEqual(t, nil, err)
+}
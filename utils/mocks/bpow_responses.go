@@ -5,4 +5,4 @@ import (
 	"io"
 )
 
-var BpowWorkGenerateResponse = io.NopCloser(bytes.NewReader([]byte("{\n  \"data\": {\n    \"workGenerate\": \"00000001cce3db6c\"\n  }\n}")))
+var BpowWorkGenerateResponse = io.NopCloser(bytes.NewReader([]byte("{\n  \"data\": {\n    \"workGenerate\": \"2b3d689bbcb21dca\"\n  }\n}")))
@@ -1,17 +1,154 @@
 package utils
 
-import "net/http"
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
 
-func IPAddress(r *http.Request) string {
-	IPAddress := r.Header.Get("CF-Connecting-IP")
-	if IPAddress == "" {
-		IPAddress = r.Header.Get("X-Real-Ip")
+type contextKey int
+
+const ipAddressContextKey contextKey = iota
+
+// Loopback and private ranges, where a reverse proxy in front of us usually is
+const DefaultTrustedProxies = "127.0.0.0/8,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,::1/128,fc00::/7"
+
+// Cloudflare's ranges, from https://www.cloudflare.com/ips/
+// "cloudflare" in a list of trusted proxies stands for these
+const CloudflareProxies = "173.245.48.0/20,103.21.244.0/22,103.22.200.0/22,103.31.4.0/22,141.101.64.0/18,108.162.192.0/18,190.93.240.0/20,188.114.96.0/20,197.234.240.0/22,198.41.128.0/17,162.158.0.0/15,104.16.0.0/13,104.24.0.0/14,172.64.0.0/13,131.0.72.0/22," +
+	"2400:cb00::/32,2606:4700::/32,2803:f800::/32,2405:b500::/32,2405:8100::/32,2a06:98c0::/29,2c0f:f248::/32"
+
+// Only X-Forwarded-For by default, any proxy can pass on the others from the client
+var DefaultIPHeaders = []string{"X-Forwarded-For"}
+
+// IPResolver works out a request's client IP, headers are only believed if the request came from a trusted proxy
+type IPResolver struct {
+	TrustedProxies []*net.IPNet
+	// In order of precedence
+	Headers []string
+	// CF-Connecting-IP is only believed from these, as only Cloudflare sets it
+	Cloudflare []*net.IPNet
+}
+
+// ParseTrustedProxies parses a comma separated list of CIDRs, single IPs are allowed too
+func ParseTrustedProxies(proxies string) ([]*net.IPNet, error) {
+	var trusted []*net.IPNet
+	for _, proxy := range strings.Split(proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if strings.EqualFold(proxy, "cloudflare") {
+			cloudflare, _ := ParseTrustedProxies(CloudflareProxies)
+			trusted = append(trusted, cloudflare...)
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid trusted proxy %s", proxy)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy %s", proxy)
+		}
+		trusted = append(trusted, ipNet)
+	}
+	return trusted, nil
+}
+
+// ParseIPHeaders parses a comma separated list of headers
+func ParseIPHeaders(headers string) []string {
+	var parsed []string
+	for _, header := range strings.Split(headers, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			parsed = append(parsed, http.CanonicalHeaderKey(header))
+		}
+	}
+	return parsed
+}
+
+func (res *IPResolver) isTrusted(ip net.IP) bool {
+	return containsIP(res.TrustedProxies, ip)
+}
+
+func containsIP(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client IP of the request
+// X-Forwarded-For is read right to left, the first address that isn't a trusted proxy is the client,
+// anything to the left of it could have been sent by the client itself
+func (res *IPResolver) Resolve(r *http.Request) string {
+	remote := remoteIP(r)
+	remoteParsed := net.ParseIP(remote)
+	if remoteParsed == nil || !res.isTrusted(remoteParsed) {
+		return remote
+	}
+
+	for _, header := range res.Headers {
+		if strings.EqualFold(header, "X-Forwarded-For") {
+			var hops []string
+			for _, value := range r.Header.Values(header) {
+				hops = append(hops, strings.Split(value, ",")...)
+			}
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := net.ParseIP(strings.TrimSpace(hops[i]))
+				if hop == nil {
+					// Can't trust anything past a malformed hop
+					break
+				}
+				if !res.isTrusted(hop) {
+					return hop.String()
+				}
+			}
+			continue
+		}
+		// A proxy between Cloudflare and us would pass on whatever the client sent
+		if strings.EqualFold(header, "CF-Connecting-IP") && !containsIP(res.Cloudflare, remoteParsed) {
+			continue
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get(header))); ip != nil {
+			return ip.String()
+		}
 	}
-	if IPAddress == "" {
-		IPAddress = r.Header.Get("X-Forwarded-For")
+	return remote
+}
+
+// Middleware resolves the client IP once, for IPAddress
+func (res *IPResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ipAddressContextKey, res.Resolve(r))))
+	})
+}
+
+// IPAddress returns the client IP resolved by IPResolver's middleware
+// Without it no proxy is trusted, so it's the address of whoever connected
+func IPAddress(r *http.Request) string {
+	if ip, ok := r.Context().Value(ipAddressContextKey).(string); ok {
+		return ip
 	}
-	if IPAddress == "" {
-		IPAddress = r.RemoteAddr
+	return remoteIP(r)
+}
+
+// RemoteAddr without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-	return IPAddress
+	return host
 }
@@ -3,6 +3,8 @@ package utils
 import (
 	"bytes"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,26 +12,133 @@ import (
 
 func TestGetIPAddressFromHeader(t *testing.T) {
 	ip := "123.45.67.89"
+	trusted, err := ParseTrustedProxies(DefaultTrustedProxies + ",cloudflare")
+	assert.Nil(t, err)
+	cloudflare, err := ParseTrustedProxies(CloudflareProxies)
+	assert.Nil(t, err)
+	resolver := &IPResolver{TrustedProxies: trusted, Headers: []string{"CF-Connecting-IP", "X-Real-Ip", "X-Forwarded-For"}, Cloudflare: cloudflare}
 
 	// 4 methods of getting IP Address, CF-Connecting-IP preferred, X-Real-Ip, then X-Forwarded-For, then RemoteAddr
+	// Headers are only used when the request comes from a trusted proxy, CF-Connecting-IP only from Cloudflare
 
 	request, _ := http.NewRequest(http.MethodPost, "appditto.com", bytes.NewReader([]byte("")))
+	request.RemoteAddr = "173.245.48.1:1234"
 	request.Header.Set("CF-Connecting-IP", ip)
-	request.Header.Set("X-Real-Ip", "not-the-ip")
-	request.Header.Set("X-Forwarded-For", "not-the-ip")
-	assert.Equal(t, ip, IPAddress(request))
+	request.Header.Set("X-Real-Ip", "1.1.1.1")
+	request.Header.Set("X-Forwarded-For", "1.1.1.1")
+	assert.Equal(t, ip, resolver.Resolve(request))
 
 	request, _ = http.NewRequest(http.MethodPost, "appditto.com", bytes.NewReader([]byte("")))
+	request.RemoteAddr = "10.0.0.1:1234"
 	request.Header.Set("X-Real-Ip", ip)
-	request.Header.Set("X-Forwarded-For", "not-the-ip")
-
-	assert.Equal(t, ip, IPAddress(request))
+	request.Header.Set("X-Forwarded-For", "1.1.1.1")
+	assert.Equal(t, ip, resolver.Resolve(request))
 
 	request, _ = http.NewRequest(http.MethodPost, "appditto.com", bytes.NewReader([]byte("")))
+	request.RemoteAddr = "10.0.0.1:1234"
 	request.Header.Set("X-Forwarded-For", ip)
-	assert.Equal(t, ip, IPAddress(request))
+	assert.Equal(t, ip, resolver.Resolve(request))
 
 	request, _ = http.NewRequest(http.MethodPost, "appditto.com", bytes.NewReader([]byte("")))
-	request.RemoteAddr = ip
-	assert.Equal(t, ip, IPAddress(request))
+	request.RemoteAddr = ip + ":1234"
+	assert.Equal(t, ip, resolver.Resolve(request))
+}
+
+func TestUntrustedHeadersIgnored(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	assert.Nil(t, err)
+	cloudflare, err := ParseTrustedProxies(CloudflareProxies)
+	assert.Nil(t, err)
+	resolver := &IPResolver{TrustedProxies: trusted, Headers: []string{"CF-Connecting-IP", "X-Real-Ip", "X-Forwarded-For"}, Cloudflare: cloudflare}
+
+	// Anyone connecting directly can send whatever headers they like
+	request, _ := http.NewRequest(http.MethodPost, "appditto.com", nil)
+	request.RemoteAddr = "123.45.67.89:1234"
+	request.Header.Set("CF-Connecting-IP", "1.1.1.1")
+	request.Header.Set("X-Forwarded-For", "1.1.1.1")
+	assert.Equal(t, "123.45.67.89", resolver.Resolve(request))
+
+	// A private ingress passes on CF-Connecting-IP from the client, only Cloudflare is believed
+	request, _ = http.NewRequest(http.MethodPost, "appditto.com", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("CF-Connecting-IP", "1.1.1.1")
+	request.Header.Set("X-Forwarded-For", "1.1.1.1, 123.45.67.89")
+	assert.Equal(t, "123.45.67.89", resolver.Resolve(request))
+
+	// Garbage in a trusted header falls through to the next one
+	request, _ = http.NewRequest(http.MethodPost, "appditto.com", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Real-Ip", "not-an-ip")
+	request.Header.Set("X-Forwarded-For", "123.45.67.89")
+	assert.Equal(t, "123.45.67.89", resolver.Resolve(request))
+
+	// By default only X-Forwarded-For is read
+	resolver.Headers = DefaultIPHeaders
+	request, _ = http.NewRequest(http.MethodPost, "appditto.com", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Real-Ip", "1.1.1.1")
+	request.Header.Set("X-Forwarded-For", "123.45.67.89")
+	assert.Equal(t, "123.45.67.89", resolver.Resolve(request))
+}
+
+func TestForwardedForRightToLeft(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8,192.168.1.1")
+	assert.Nil(t, err)
+	resolver := &IPResolver{TrustedProxies: trusted, Headers: []string{"X-Forwarded-For"}}
+
+	request, _ := http.NewRequest(http.MethodPost, "appditto.com", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	// The client made up the first hop, our proxies appended the rest
+	request.Header.Set("X-Forwarded-For", "1.1.1.1, 123.45.67.89, 192.168.1.1")
+	request.Header.Add("X-Forwarded-For", "10.0.0.2")
+	assert.Equal(t, "123.45.67.89", resolver.Resolve(request))
+
+	// A malformed hop stops the walk
+	request.Header.Set("X-Forwarded-For", "123.45.67.89, garbage, 10.0.0.2")
+	assert.Equal(t, "10.0.0.1", resolver.Resolve(request))
+
+	// Every hop trusted
+	request.Header.Set("X-Forwarded-For", "10.0.0.3, 10.0.0.2")
+	assert.Equal(t, "10.0.0.1", resolver.Resolve(request))
+
+	// IPv6
+	trusted, err = ParseTrustedProxies("::1")
+	assert.Nil(t, err)
+	resolver.TrustedProxies = trusted
+	request.RemoteAddr = "[::1]:1234"
+	request.Header.Set("X-Forwarded-For", "2001:db8::1")
+	assert.Equal(t, "2001:db8::1", resolver.Resolve(request))
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	trusted, err := ParseTrustedProxies("")
+	assert.Nil(t, err)
+	assert.Len(t, trusted, 0)
+	trusted, err = ParseTrustedProxies(" 10.0.0.0/8 , 1.2.3.4,::1 ")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "1.2.3.4/32", "::1/128"}, []string{trusted[0].String(), trusted[1].String(), trusted[2].String()})
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.NotNil(t, err)
+	_, err = ParseTrustedProxies("proxy.local")
+	assert.NotNil(t, err)
+	trusted, err = ParseTrustedProxies("10.0.0.0/8,Cloudflare")
+	assert.Nil(t, err)
+	assert.Len(t, trusted, 1+len(strings.Split(CloudflareProxies, ",")))
+	assert.Equal(t, []string{"X-Real-Ip", "X-Forwarded-For"}, ParseIPHeaders("x-real-ip, X-Forwarded-For,"))
+}
+
+func TestIPAddressMiddleware(t *testing.T) {
+	resolver := &IPResolver{TrustedProxies: nil, Headers: DefaultIPHeaders}
+	var resolved string
+	handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolved = IPAddress(r)
+	}))
+	request := httptest.NewRequest(http.MethodPost, "/api", nil)
+	request.RemoteAddr = "123.45.67.89:1234"
+	request.Header.Set("X-Real-Ip", "1.1.1.1")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(t, "123.45.67.89", resolved)
+
+	// Without the middleware nothing is trusted
+	assert.Equal(t, "123.45.67.89", IPAddress(request))
 }
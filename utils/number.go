@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/big"
 	"strconv"
+	"strings"
 )
 
 const rawPerNanoStr = "1000000000000000000000000000000"
@@ -82,3 +83,31 @@ func NanoToRaw(nano float64) string {
 
 	return fmt.Sprintf("%d", res)
 }
+
+// AmountToRaw converts a decimal NANO or BANANO amount, like one typed by a user, to raw without going through a float
+func AmountToRaw(amount string, bananoMode bool) (*big.Int, error) {
+	asRat, ok := new(big.Rat).SetString(amount)
+	if !ok || asRat.Sign() < 0 || strings.ContainsAny(amount, "/eE") {
+		return nil, fmt.Errorf("Invalid amount %s", amount)
+	}
+	asRat.Mul(asRat, new(big.Rat).SetInt(rawPer(bananoMode)))
+	if !asRat.IsInt() {
+		return nil, fmt.Errorf("Amount %s is more precise than raw", amount)
+	}
+	return new(big.Int).Set(asRat.Num()), nil
+}
+
+// RawToAmount is the exact decimal NANO or BANANO amount, without trailing zeros
+func RawToAmount(raw *big.Int, bananoMode bool) string {
+	asStr := new(big.Rat).SetFrac(raw, rawPer(bananoMode)).FloatString(30)
+	return strings.TrimSuffix(strings.TrimRight(asStr, "0"), ".")
+}
+
+func rawPer(bananoMode bool) *big.Int {
+	if bananoMode {
+		res, _ := new(big.Int).SetString(rawPerBananoStr, 10)
+		return res
+	}
+	res, _ := new(big.Int).SetString(rawPerNanoStr, 10)
+	return res
+}
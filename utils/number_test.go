@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -54,3 +55,26 @@ func TestNanoToRaw(t *testing.T) {
 	converted := NanoToRaw(amount)
 	assert.Equal(t, "1000000000000000000000000000000", converted)
 }
+
+func TestAmountToRaw(t *testing.T) {
+	raw, err := AmountToRaw("1", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "1000000000000000000000000000000", raw.String())
+	raw, err = AmountToRaw("0.000001", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "1000000000000000000000000", raw.String())
+	raw, err = AmountToRaw("1", true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "100000000000000000000000000000", raw.String())
+
+	raw, _ = RawToBigInt("1250000000000000000000000000000")
+	assert.Equal(t, "12.5", RawToAmount(raw, true))
+	assert.Equal(t, "1.25", RawToAmount(raw, false))
+	assert.Equal(t, "0.000000000000000001", RawToAmount(big.NewInt(1000000000000), false))
+	assert.Equal(t, "0", RawToAmount(big.NewInt(0), false))
+
+	for _, invalid := range []string{"", "-1", "abc", "1/2", "1e3", "0.0000000000000000000000000000001"} {
+		_, err = AmountToRaw(invalid, false)
+		assert.NotNil(t, err, invalid)
+	}
+}
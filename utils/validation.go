@@ -78,6 +78,21 @@ func AddressToPub(account string) (public_key []byte, err error) {
 	return nil, errors.New("Invalid address format")
 }
 
+// Convert a public key to an address
+func PubToAddress(pub []byte, bananoMode bool) (string, error) {
+	if len(pub) != 32 {
+		return "", errors.New("Invalid public key length")
+	}
+	prefix := "nano_"
+	if bananoMode {
+		prefix = "ban_"
+	}
+	// Pad to 280 bits, the 4 leading "1"s are the padding
+	padded := append([]byte{0, 0, 0}, pub...)
+	encoded := NanoEncoding.EncodeToString(padded)[4:]
+	return prefix + encoded + NanoEncoding.EncodeToString(GetAddressChecksum(pub)), nil
+}
+
 func GetAddressChecksum(pub ed25519.PublicKey) []byte {
 	hash, err := blake2b.New(5, nil)
 	if err != nil {
@@ -45,3 +45,15 @@ func TestAddressToPub(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, "7fc9064e4d713af2afc73c1527334b665972eb57d65093a378a3e40dbb48ec43", hex.EncodeToString(pub))
 }
+
+func TestPubToAddress(t *testing.T) {
+	pub, _ := hex.DecodeString("7fc9064e4d713af2afc73c1527334b665972eb57d65093a378a3e40dbb48ec43")
+	address, err := PubToAddress(pub, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "nano_1zyb1s96twbtycqwgh1o6wsnpsksgdoohokikgjqjaz63pxnju457pz8tm3r", address)
+	address, err = PubToAddress(pub, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ban_1zyb1s96twbtycqwgh1o6wsnpsksgdoohokikgjqjaz63pxnju457pz8tm3r", address)
+	_, err = PubToAddress(pub[1:], false)
+	assert.NotEqual(t, nil, err)
+}